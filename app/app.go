@@ -1,14 +1,15 @@
 package app
 
 import (
-	"crypto/tls"
 	_ "embed"
-	"fmt"
-	"io"
-	"log"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+	"git.0x0001f346.de/andreas/ablage/search"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -24,7 +25,33 @@ var assetScriptJS []byte
 //go:embed assets/style.css
 var assetStyleCSS []byte
 
-func Init() error {
+// App serves the web UI and the HTTP API of a single ablage instance.
+type App struct {
+	config       *config.Config
+	draining     atomic.Bool
+	events       *events
+	handler      http.Handler
+	index        *search.Index
+	indexTrigger chan struct{}
+	metrics      *metrics
+	quota        *quota
+	storage      *filesystem.Storage
+}
+
+func New(c *config.Config, storage *filesystem.Storage) *App {
+	a := &App{
+		config:       c,
+		events:       newEvents(),
+		indexTrigger: make(chan struct{}, 1),
+		metrics:      newMetrics(),
+		quota:        newQuota(),
+		storage:      storage,
+	}
+
+	if a.config.SearchMode {
+		a.index = search.New(c)
+	}
+
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,63 +62,127 @@ func Init() error {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	})
 
-	router.GET(httpPathRoot, httpGetRoot)
-	router.GET(httpPathConfig, httpGetConfig)
-	router.GET(httpPathFaviconICO, httpGetFaviconICO)
-	router.GET(httpPathFaviconSVG, httpGetFaviconSVG)
-	router.GET(httpPathFiles, httpGetFiles)
-	router.GET(httpPathFilesDeleteFilename, httpGetFilesDeleteFilename)
-	router.GET(httpPathFilesGetFilename, httpGetFilesGetFilename)
-	router.GET(httpPathScriptJS, httpGetScriptJS)
-	router.GET(httpPathStyleCSS, httpGetStyleCSS)
-	router.POST(httpPathUpload, httpPostUpload)
-
-	var handler http.Handler = router
-
-	if config.GetBasicAuthMode() {
-		handler = basicAuthMiddleware(handler, config.GetBasicAuthUsername(), config.GetBasicAuthPassword())
+	router.GET(httpPathRoot, a.instrument(httpPathRoot, a.httpGetRoot))
+	router.GET(httpPathConfig, a.instrument(httpPathConfig, a.httpGetConfig))
+	router.GET(httpPathEvents, a.instrument(httpPathEvents, a.httpGetEvents))
+	router.GET(httpPathFaviconICO, a.instrument(httpPathFaviconICO, a.httpGetFaviconICO))
+	router.GET(httpPathFaviconSVG, a.instrument(httpPathFaviconSVG, a.httpGetFaviconSVG))
+	router.GET(httpPathFiles, a.instrument(httpPathFiles, a.httpGetFiles))
+	router.GET(httpPathFilesDeleteFilename, a.instrument(httpPathFilesDeleteFilename, a.httpGetFilesDeleteFilename))
+	router.GET(httpPathFilesGetFilename, a.instrument(httpPathFilesGetFilename, a.httpGetFilesGetFilename))
+	router.POST(httpPathFilesMetadataFilename, a.instrument(httpPathFilesMetadataFilename, a.httpPostFilesMetadataFilename))
+	router.GET(httpPathScriptJS, a.instrument(httpPathScriptJS, a.httpGetScriptJS))
+	router.GET(httpPathStyleCSS, a.instrument(httpPathStyleCSS, a.httpGetStyleCSS))
+	router.GET(httpPathUsage, a.instrument(httpPathUsage, a.httpGetUsage))
+	router.POST(httpPathUpload, a.instrument(httpPathUpload, a.httpPostUpload))
+
+	if a.config.MetricsMode && a.config.MetricsListenAddress == "" {
+		router.GET(httpPathMetrics, a.httpGetMetrics)
 	}
 
-	if config.GetHttpMode() {
-		config.PrintStartupBanner()
-		err := http.ListenAndServe(fmt.Sprintf(":%d", config.GetPortToListenOn()), handler)
-		if err != nil {
-			return fmt.Errorf("Webserver exited with error: %v", err)
-		}
-		return nil
+	if a.config.CAMode {
+		router.GET(httpPathCACertificate, a.instrument(httpPathCACertificate, a.httpGetCACertificate))
 	}
 
-	tlsCert, err := tls.X509KeyPair(config.GetTLSCertificate(), config.GetTLSKey())
-	if err != nil {
-		return fmt.Errorf("Faild to parse PEM encoded public/private key pair: %v", err)
+	if a.config.SearchMode {
+		router.GET(httpPathSearch, a.instrument(httpPathSearch, a.httpGetSearch))
 	}
 
-	server := &http.Server{
-		Addr:     fmt.Sprintf(":%d", config.GetPortToListenOn()),
-		ErrorLog: log.New(io.Discard, "", 0),
-		Handler:  handler,
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{tlsCert},
-		},
-		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	if a.config.TrashMode {
+		router.GET(httpPathTrash, a.instrument(httpPathTrash, a.httpGetTrash))
+		router.GET(httpPathTrashPurgeID, a.instrument(httpPathTrashPurgeID, a.httpGetTrashPurgeID))
+		router.GET(httpPathTrashRestoreID, a.instrument(httpPathTrashRestoreID, a.httpGetTrashRestoreID))
 	}
 
-	config.PrintStartupBanner()
+	if a.config.VersioningMode {
+		router.GET(httpPathVersionsFilename, a.instrument(httpPathVersionsFilename, a.httpGetVersionsFilename))
+		router.GET(httpPathVersionsFilenameGetID, a.instrument(httpPathVersionsFilenameGetID, a.httpGetVersionsFilenameGetID))
+		router.GET(httpPathVersionsFilenameRestoreID, a.instrument(httpPathVersionsFilenameRestoreID, a.httpGetVersionsFilenameRestoreID))
+	}
 
-	err = server.ListenAndServeTLS("", "")
+	a.handler = router
+
+	if a.config.BasicAuthMode {
+		a.handler = basicAuthMiddleware(a.handler, a.config.BasicAuthUsername, a.config.BasicAuthPassword, func(r *http.Request) {
+			a.metrics.authFailures.Add(1)
+			a.getLogger(r).Warn("Authentication failed", "client_ip", a.getClientIP(r), "path", r.URL.Path)
+		})
+	}
+
+	a.handler = a.publicEndpointsMiddleware(a.handler)
+	a.handler = a.requestLoggingMiddleware(a.handler)
+
+	return a
+}
+
+// CheckLiveness runs the checks of /readyz that tell whether the app can
+// still do its job at all: the data folder is reachable and the upload folder
+// is present. Low disk space and draining are not failures here.
+func (a *App) CheckLiveness() error {
+	err := a.storage.CheckAvailability()
 	if err != nil {
-		return fmt.Errorf("Webserver exited with error: %v", err)
+		return err
 	}
 
-	return nil
+	return a.storage.CheckUploadFolder()
+}
+
+func (a *App) Handler() http.Handler {
+	return a.handler
 }
 
-func getClientIP(r *http.Request) string {
-	if r.Header.Get("X-Forwarded-For") != "" {
-		return r.Header.Get("X-Forwarded-For")
+// StartDraining makes the app reject new uploads, so that a shutdown only has
+// to wait for the uploads that are already in flight.
+func (a *App) StartDraining() {
+	a.draining.Store(true)
+}
+
+// getClientIP returns the IP address of the client without the port. Any
+// client can set X-Forwarded-For, so it is only used on connections from
+// trusted proxies. As proxies append the address a request came from, the
+// client is the last address that isn't a trusted proxy itself.
+func (a *App) getClientIP(r *http.Request) string {
+	clientIP := getHost(r.RemoteAddr)
+	if !a.config.IsTrustedProxy(r.RemoteAddr) {
+		return clientIP
+	}
+
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwardedFor[i])
+		if address == "" {
+			continue
+		}
+
+		clientIP = getHost(address)
+		if !a.config.IsTrustedProxy(address) {
+			break
+		}
+	}
+
+	return clientIP
+}
+
+// getUploader identifies the owner of an upload for per-user quotas: the
+// basic authentication user if authentication is enabled, otherwise the
+// client IP address.
+func (a *App) getUploader(r *http.Request) string {
+	if a.config.BasicAuthMode {
+		username, _, _ := r.BasicAuth()
+		return username
+	}
+
+	return a.getClientIP(r)
+}
+
+// getHost strips the port from address, if it has one.
+func getHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
 	}
 
-	return r.RemoteAddr
+	return host
 }
 
 func isBrowserDisplayableFileType(extension string) bool {
@@ -1,10 +1,32 @@
 (() => {
   "use strict";
 
+  const FILE_LIST_PAGE_SIZE = 200;
+
+  // With live updates, the list is only loaded again now and then, so the
+  // remaining lifetimes of the files stay current.
+  const FILE_LIST_REFRESH_INTERVAL = 60 * 1000;
+
+  // Searching starts once typing pauses for this long.
+  const SEARCH_DELAY = 300;
+
   const state = {
     config: null,
+    events: null,
+    eventsConnected: false,
+    fileItems: {},
+    fileListFetchedAt: 0,
+    fileListFilter: "",
+    fileListHasMore: false,
+    fileListLimit: FILE_LIST_PAGE_SIZE,
+    fileListSort: "name-asc",
     files: {},
+    searchQuery: "",
+    searchTimeout: null,
     ui: {},
+    usage: null,
+    versionsOpen: {},
+    view: "files",
     errorTimeout: null,
   };
 
@@ -16,6 +38,7 @@
     uiBindEvents();
 
     await configLoad();
+    eventsConnect();
     appUpdate();
 
     setInterval(appUpdate, 5 * 1000);
@@ -28,7 +51,17 @@
     }
 
     uiUpdate();
-    fileListFetch();
+    if (state.view === "trash") {
+      trashListFetch();
+      return;
+    }
+    if (
+      !state.eventsConnected ||
+      Date.now() - state.fileListFetchedAt > FILE_LIST_REFRESH_INTERVAL
+    ) {
+      fileListFetch();
+    }
+    usageFetch();
   }
 
   // ===== config ===========================
@@ -46,11 +79,101 @@
     }
   }
 
+  // ===== events ===========================
+
+  // eventsConnect subscribes to the changes of the files, so they show up
+  // right away instead of with the next poll. The list is loaded again
+  // whenever the stream (re)connects, as changes may have been missed.
+  function eventsConnect() {
+    if (
+      !window.EventSource ||
+      state.config === null ||
+      !state.config.Endpoints.Events ||
+      state.config.Modes.Sinkhole
+    ) {
+      return;
+    }
+
+    const events = new EventSource(state.config.Endpoints.Events);
+
+    events.addEventListener("open", () => {
+      state.eventsConnected = true;
+      if (state.view === "files") fileListFetch();
+    });
+
+    events.addEventListener("error", () => {
+      state.eventsConnected = false;
+    });
+
+    ["add", "modify", "remove"].forEach((type) => {
+      events.addEventListener(type, (event) => {
+        if (state.view !== "files") return;
+        eventsApply(type, JSON.parse(event.data));
+        usageFetch();
+      });
+    });
+
+    state.events = events;
+  }
+
+  function eventsApply(type, file) {
+    const li = state.fileItems[file.Name];
+
+    if (li) {
+      li.remove();
+      delete state.fileItems[file.Name];
+      delete state.files[file.Name];
+    }
+
+    if (type === "remove") {
+      // A shortened list has to make room for the next file.
+      if (li && state.fileListHasMore) fileListFetch();
+      return;
+    }
+
+    if (type === "modify" && !li) return;
+    if (!fileMatchesFilter(file)) return;
+
+    const next = Array.from(state.ui.fileList.children).find(
+      (item) =>
+        item.dataset.name === undefined ||
+        fileCompare(file, state.files[item.dataset.name]) < 0
+    );
+
+    // Files that sort after the last shown file belong to a later page.
+    if (!next && state.fileListHasMore) return;
+    if (next && next.dataset.name === undefined && state.fileListHasMore) {
+      return;
+    }
+
+    fileListInsert(file, next || null);
+  }
+
   // ===== files ============================
 
+  // fileCompare sorts like the server does, by the chosen key and then by name.
+  function fileCompare(a, b) {
+    const [sort, order] = state.fileListSort.split("-");
+
+    let c = 0;
+    if (sort === "mtime") {
+      c = Date.parse(a.ModifiedAt) - Date.parse(b.ModifiedAt);
+    } else if (sort === "size") {
+      c = a.Size - b.Size;
+    }
+    if (c === 0 && a.Name !== b.Name) {
+      c = a.Name < b.Name ? -1 : 1;
+    }
+
+    return order === "desc" ? -c : c;
+  }
+
   async function fileDeleteClickHandler(event, file) {
     event.preventDefault();
-    if (!confirm(`Do you really want to delete "${file.Name}"?`)) return;
+    const question = state.config.Endpoints.Trash
+      ? `Do you want to move "${file.Name}" to the trash?`
+      : `Do you really want to delete "${file.Name}"?`;
+    if (!confirm(question)) return;
 
     try {
       const res = await fetch(
@@ -68,11 +191,56 @@
       }
 
       fileListFetch();
+      usageFetch();
     } catch (err) {
       uiShowError("Delete failed");
     }
   }
 
+  async function fileEditClickHandler(event, file) {
+    event.preventDefault();
+
+    const description = prompt(
+      `Description of "${file.Name}":`,
+      file.Description || ""
+    );
+    if (description === null) return;
+
+    const tags = prompt(
+      `Tags of "${file.Name}", separated by commas:`,
+      (file.Tags || []).join(", ")
+    );
+    if (tags === null) return;
+
+    try {
+      const res = await fetch(
+        state.config.Endpoints.FilesMetadata.replace(
+          ":filename",
+          encodeURIComponent(file.Name)
+        ),
+        {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({
+            Description: description,
+            Tags: tags.split(","),
+          }),
+        }
+      );
+
+      if (res.ok) {
+        uiShowSuccess("File updated: " + file.Name);
+      } else {
+        const body = await res.json().catch(() => ({}));
+        uiShowError(body.error || "Update failed");
+      }
+
+      fileListFetch();
+    } catch (err) {
+      uiShowError("Update failed");
+    }
+  }
+
   async function fileListFetch() {
     if (state.config.Modes.Sinkhole) {
       fileListClear();
@@ -80,43 +248,124 @@
     }
 
     try {
-      let files = await fileListRequest();
-      files = fileSortFiles(files, "name-asc");
-      state.files = {};
+      const { files, hasMore } = await fileListRequest();
+      state.fileListFetchedAt = Date.now();
       fileListClear();
-      fileListRender(files);
+      fileListRender(files, hasMore);
+      uiSetDegraded(false);
     } catch (err) {
       console.error("fileListFetch failed:", err);
+      if (err.status === 503) {
+        fileListClear();
+        uiSetDegraded(true);
+      }
     }
   }
 
+  // fileListRequest lets the server sort and filter the files and only asks
+  // for as many as are shown, which keeps large folders responsive.
   async function fileListRequest() {
-    const res = await fetch(state.config.Endpoints.Files, {
+    const [sort, order] = state.fileListSort.split("-");
+    const params = new URLSearchParams({
+      limit: state.fileListLimit,
+      order: order,
+      sort: sort,
+    });
+
+    const filter = state.fileListFilter.trim();
+    if (filter !== "") {
+      params.set("glob", /[*?[]/.test(filter) ? filter : `*${filter}*`);
+    }
+
+    const res = await fetch(`${state.config.Endpoints.Files}?${params}`, {
       cache: "no-store",
     });
-    if (!res.ok) throw new Error("HTTP " + res.status);
-    return res.json();
+    if (!res.ok) {
+      const err = new Error("HTTP " + res.status);
+      err.status = res.status;
+      throw err;
+    }
+
+    const link = res.headers.get("Link") || "";
+    return { files: await res.json(), hasMore: /rel="next"/.test(link) };
   }
 
   function fileListClear() {
+    state.fileItems = {};
+    state.files = {};
     if (state.ui.fileList) state.ui.fileList.innerHTML = "";
   }
 
-  function fileListRender(files) {
-    if (!state.ui.fileList) return;
+  // fileListInsert shows file in front of the list item before, or at the end
+  // of the list if before is null.
+  function fileListInsert(file, before) {
+    state.files[file.Name] = file;
 
-    files.forEach((file) => {
-      state.files[file.Name] = true;
+    const li = document.createElement("li");
+    li.dataset.name = file.Name;
+    li.appendChild(uiCreateDownloadLink(file));
 
-      const li = document.createElement("li");
-      li.appendChild(uiCreateDownloadLink(file));
+    if (state.config.Endpoints.Versions && file.Versions > 0) {
+      li.appendChild(uiCreateVersionsLink(file));
+    }
 
-      if (!state.config.Modes.Readonly) {
-        li.appendChild(uiCreateDeleteLink(file));
-      }
+    if (!state.config.Modes.Readonly) {
+      li.appendChild(uiCreateEditLink(file));
+      li.appendChild(uiCreateDeleteLink(file));
+    }
+
+    if (file.Description || file.Tags) {
+      li.appendChild(uiCreateFileDetails(file));
+    }
+
+    if (state.config.Endpoints.Versions && state.versionsOpen[file.Name]) {
+      const ul = document.createElement("ul");
+      ul.className = "version-list";
+      li.appendChild(ul);
+      versionListFetch(file, ul);
+    }
+
+    state.fileItems[file.Name] = li;
+    state.ui.fileList.insertBefore(li, before);
+  }
+
+  // fileMatchesFilter applies the filter of the list to files that are added
+  // while the list is shown, the same way the server applies the glob.
+  function fileMatchesFilter(file) {
+    const filter = state.fileListFilter.trim();
+    if (filter === "") return true;
+
+    const glob = /[*?[]/.test(filter) ? filter : `*${filter}*`;
+    const pattern = glob
+      .split(/(\[[^\]]*\]|\*|\?)/)
+      .map((part) => {
+        if (part === "*") return "[^/]*";
+        if (part === "?") return "[^/]";
+        if (part.startsWith("[") && part.endsWith("]")) {
+          return part.replace(/^\[\^/, "[^");
+        }
+        return part.replace(/[.*+?^${}()|[\]\\]/g, "\\$&");
+      })
+      .join("");
+
+    try {
+      return new RegExp(`^${pattern}$`).test(file.Name);
+    } catch (err) {
+      return false;
+    }
+  }
 
+  function fileListRender(files, hasMore) {
+    if (!state.ui.fileList) return;
+
+    files.forEach((file) => fileListInsert(file, null));
+
+    state.fileListHasMore = hasMore;
+    if (hasMore) {
+      const li = document.createElement("li");
+      li.appendChild(uiCreateShowMoreLink());
       state.ui.fileList.appendChild(li);
-    });
+    }
   }
 
   function fileSanitizeName(dirtyFilename) {
@@ -169,44 +418,43 @@
     return cleanedFilename + extension;
   }
 
-  function fileSortFiles(files, mode = "name-asc") {
-    function cmpCodePoint(a, b) {
-      if (a === b) return 0;
-      return a < b ? -1 : 1;
-    }
-
-    const arr = files.slice();
-
-    switch (mode) {
-      case "name-asc":
-        arr.sort((a, b) => cmpCodePoint(a.Name, b.Name));
-        break;
-      case "name-desc":
-        arr.sort((a, b) => cmpCodePoint(b.Name, a.Name));
-        break;
-      case "size-asc":
-        arr.sort((a, b) => a.Size - b.Size);
-        break;
-      case "size-desc":
-        arr.sort((a, b) => b.Size - a.Size);
-        break;
-    }
-
-    return arr;
-  }
-
   function fileValidateBeforeUpload(files) {
+    const usage = state.usage;
+
     for (const f of files) {
       const safeName = fileSanitizeName(f.name);
-      if (safeName === ".upload") {
-        uiShowError("Invalid filename: .upload");
+      if (
+        [
+          ".index",
+          ".metadata",
+          ".objects",
+          ".trash",
+          ".upload",
+          ".versions",
+        ].includes(safeName)
+      ) {
+        uiShowError("Invalid filename: " + safeName);
         return false;
       }
-      if (safeName in state.files) {
+      if (
+        safeName in state.files &&
+        state.config.CollisionPolicy === "reject"
+      ) {
         uiShowError("File already exists: " + f.name);
         return false;
       }
+      if (usage && usage.MaxFileSize > 0 && f.size > usage.MaxFileSize) {
+        uiShowError("File too large: " + f.name);
+        return false;
+      }
+    }
+
+    const totalSize = files.reduce((sum, f) => sum + f.size, 0);
+    if (usage && usage.Remaining >= 0 && totalSize > usage.Remaining) {
+      uiShowError("Not enough space left for this upload");
+      return false;
     }
+
     return true;
   }
 
@@ -234,6 +482,29 @@
       if (e.dataTransfer.files.length > 0) uploadStart(e.dataTransfer.files);
     });
 
+    state.ui.fileListSortSelect.addEventListener("change", () => {
+      state.fileListSort = state.ui.fileListSortSelect.value;
+      fileListFetch();
+    });
+
+    state.ui.fileListFilterInput.addEventListener("input", () => {
+      state.fileListFilter = state.ui.fileListFilterInput.value;
+      state.fileListLimit = FILE_LIST_PAGE_SIZE;
+      fileListFetch();
+    });
+
+    state.ui.searchInput.addEventListener("input", () => {
+      state.searchQuery = state.ui.searchInput.value;
+      clearTimeout(state.searchTimeout);
+      state.searchTimeout = setTimeout(searchFetch, SEARCH_DELAY);
+    });
+
+    state.ui.trashLink.addEventListener("click", (e) => {
+      e.preventDefault();
+      state.view = state.view === "trash" ? "files" : "trash";
+      appUpdate();
+    });
+
     state.ui.fileInput.addEventListener("change", () => {
       if (state.ui.fileInput.files.length > 0)
         uploadStart(state.ui.fileInput.files);
@@ -258,6 +529,19 @@
     divDropzone.style.display = "none";
     document.body.appendChild(divDropzone);
 
+    const divTTL = document.createElement("div");
+    divTTL.id = "ttl";
+    divTTL.className = "ttl";
+    divTTL.style.display = "none";
+    const labelTTL = document.createElement("label");
+    labelTTL.htmlFor = "ttlSelect";
+    labelTTL.textContent = "Delete after: ";
+    const selectTTL = document.createElement("select");
+    selectTTL.id = "ttlSelect";
+    divTTL.appendChild(labelTTL);
+    divTTL.appendChild(selectTTL);
+    document.body.appendChild(divTTL);
+
     const fileInput = document.createElement("input");
     fileInput.type = "file";
     fileInput.id = "fileInput";
@@ -283,10 +567,73 @@
     divOverallProgressContainer.appendChild(divOverallStatus);
     document.body.appendChild(divOverallProgressContainer);
 
+    const ulUploadResults = document.createElement("ul");
+    ulUploadResults.id = "upload-results";
+    ulUploadResults.style.display = "none";
+    document.body.appendChild(ulUploadResults);
+
+    const divFileListControls = document.createElement("div");
+    divFileListControls.id = "fileListControls";
+    divFileListControls.className = "file-list-controls";
+    divFileListControls.style.display = "none";
+    const inputFilter = document.createElement("input");
+    inputFilter.id = "fileListFilterInput";
+    inputFilter.type = "search";
+    inputFilter.placeholder = "Filter, e.g. *.pdf";
+    const selectSort = document.createElement("select");
+    selectSort.id = "fileListSortSelect";
+    [
+      ["name-asc", "Name (A-Z)"],
+      ["name-desc", "Name (Z-A)"],
+      ["mtime-desc", "Newest first"],
+      ["mtime-asc", "Oldest first"],
+      ["size-desc", "Largest first"],
+      ["size-asc", "Smallest first"],
+    ].forEach(([value, label]) => {
+      const option = document.createElement("option");
+      option.value = value;
+      option.textContent = label;
+      selectSort.appendChild(option);
+    });
+    const inputSearch = document.createElement("input");
+    inputSearch.id = "searchInput";
+    inputSearch.type = "search";
+    inputSearch.placeholder = "Search contents";
+    inputSearch.style.display = "none";
+    divFileListControls.appendChild(inputFilter);
+    divFileListControls.appendChild(inputSearch);
+    divFileListControls.appendChild(selectSort);
+    document.body.appendChild(divFileListControls);
+
     const ulFileList = document.createElement("ul");
     ulFileList.id = "file-list";
     document.body.appendChild(ulFileList);
 
+    const ulSearchResults = document.createElement("ul");
+    ulSearchResults.id = "search-results";
+    ulSearchResults.style.display = "none";
+    document.body.appendChild(ulSearchResults);
+
+    const divSearchInfo = document.createElement("div");
+    divSearchInfo.id = "searchInfo";
+    divSearchInfo.className = "searchInfo";
+    divSearchInfo.style.display = "none";
+    document.body.appendChild(divSearchInfo);
+
+    const divDegradedInfo = document.createElement("div");
+    divDegradedInfo.id = "degradedInfo";
+    divDegradedInfo.className = "degradedInfo";
+    divDegradedInfo.style.display = "none";
+    divDegradedInfo.textContent =
+      "- The data folder is unavailable, retrying automatically -";
+    document.body.appendChild(divDegradedInfo);
+
+    const divUsageInfo = document.createElement("div");
+    divUsageInfo.id = "usageInfo";
+    divUsageInfo.className = "usageInfo";
+    divUsageInfo.style.display = "none";
+    document.body.appendChild(divUsageInfo);
+
     const divSinkholeModeInfo = document.createElement("div");
     divSinkholeModeInfo.id = "sinkholeModeInfo";
     divSinkholeModeInfo.className = "sinkholeModeInfo";
@@ -294,19 +641,67 @@
     divSinkholeModeInfo.textContent =
       "- Sinkhole mode enabled, no files will get listed -";
     document.body.appendChild(divSinkholeModeInfo);
+
+    const ulTrashList = document.createElement("ul");
+    ulTrashList.id = "trash-list";
+    ulTrashList.style.display = "none";
+    document.body.appendChild(ulTrashList);
+
+    const divTrashInfo = document.createElement("div");
+    divTrashInfo.id = "trashInfo";
+    divTrashInfo.className = "trashInfo";
+    divTrashInfo.style.display = "none";
+    divTrashInfo.textContent = "- The trash is empty -";
+    document.body.appendChild(divTrashInfo);
+
+    const divFooter = document.createElement("div");
+    divFooter.className = "footer";
+    const aTrash = document.createElement("a");
+    aTrash.id = "trashLink";
+    aTrash.className = "footer-link";
+    aTrash.href = "#";
+    aTrash.textContent = "[Trash]";
+    aTrash.style.display = "none";
+    divFooter.appendChild(aTrash);
+    const aCACertificate = document.createElement("a");
+    aCACertificate.id = "caCertificateLink";
+    aCACertificate.className = "footer-link";
+    aCACertificate.textContent = "[Install CA certificate]";
+    aCACertificate.title =
+      "Trust this certificate once to get rid of browser warnings";
+    aCACertificate.style.display = "none";
+    divFooter.appendChild(aCACertificate);
+    document.body.appendChild(divFooter);
   }
 
   function uiCacheElements() {
+    state.ui.caCertificateLink = document.getElementById("caCertificateLink");
     state.ui.currentFileName = document.getElementById("currentFileName");
+    state.ui.degradedInfo = document.getElementById("degradedInfo");
     state.ui.dropzone = document.getElementById("dropzone");
     state.ui.fileInput = document.getElementById("fileInput");
     state.ui.fileList = document.getElementById("file-list");
+    state.ui.fileListControls = document.getElementById("fileListControls");
+    state.ui.fileListFilterInput = document.getElementById(
+      "fileListFilterInput"
+    );
+    state.ui.fileListSortSelect = document.getElementById("fileListSortSelect");
     state.ui.overallProgress = document.getElementById("overallProgress");
     state.ui.overallStatus = document.getElementById("overallStatus");
     state.ui.overallProgressContainer = document.getElementById(
       "overallProgressContainer"
     );
+    state.ui.searchInfo = document.getElementById("searchInfo");
+    state.ui.searchInput = document.getElementById("searchInput");
+    state.ui.searchResults = document.getElementById("search-results");
     state.ui.sinkholeModeInfo = document.getElementById("sinkholeModeInfo");
+    state.ui.trashInfo = document.getElementById("trashInfo");
+    state.ui.trashLink = document.getElementById("trashLink");
+    state.ui.trashList = document.getElementById("trash-list");
+    state.ui.ttl = document.getElementById("ttl");
+    state.ui.ttlSelect = document.getElementById("ttlSelect");
+    state.ui.uploadResults = document.getElementById("upload-results");
+    state.ui.usageInfo = document.getElementById("usageInfo");
   }
 
   function uiCreateDeleteLink(file) {
@@ -328,9 +723,102 @@
       encodeURIComponent(file.Name)
     );
     link.textContent = `${file.Name} (${size})`;
+    const title = [];
+    if (file.ExpiresIn) {
+      link.textContent = `${file.Name} (${size}, ${uiFormatDuration(
+        file.ExpiresIn
+      )} left)`;
+      title.push("Expires " + new Date(file.ExpiresAt).toLocaleString());
+    }
+    if (file.UploadedAt) {
+      const by = file.Uploader ? ` by ${file.Uploader}` : "";
+      title.push(
+        `Uploaded ${new Date(file.UploadedAt).toLocaleString()}${by}`
+      );
+    }
+    if (file.MIMEType) title.push("Type: " + file.MIMEType);
+    if (file.SHA256) title.push("SHA-256: " + file.SHA256);
+    if (file.BLAKE3) title.push("BLAKE3: " + file.BLAKE3);
+    link.title = title.join("\n");
+    return link;
+  }
+
+  function uiCreateEditLink(file) {
+    const link = document.createElement("a");
+    link.className = "edit-link";
+    link.href = "#";
+    link.textContent = " [Edit]";
+    link.title = "Edit description and tags";
+    link.addEventListener("click", (e) => fileEditClickHandler(e, file));
+    return link;
+  }
+
+  function uiCreateFileDetails(file) {
+    const div = document.createElement("div");
+    div.className = "file-details";
+
+    if (file.Description) {
+      const description = document.createElement("div");
+      description.className = "file-description";
+      description.textContent = file.Description;
+      div.appendChild(description);
+    }
+
+    (file.Tags || []).forEach((tag) => {
+      const span = document.createElement("span");
+      span.className = "file-tag";
+      span.textContent = tag;
+      div.appendChild(span);
+    });
+
+    return div;
+  }
+
+  function uiCreateShowMoreLink() {
+    const link = document.createElement("a");
+    link.className = "restore-link";
+    link.href = "#";
+    link.textContent = "[Show more]";
+    link.title = `Show ${FILE_LIST_PAGE_SIZE} more files`;
+    link.addEventListener("click", (e) => {
+      e.preventDefault();
+      state.fileListLimit += FILE_LIST_PAGE_SIZE;
+      fileListFetch();
+    });
     return link;
   }
 
+  function uiCreateVersionsLink(file) {
+    const link = document.createElement("a");
+    link.className = "restore-link";
+    link.href = "#";
+    link.textContent = state.versionsOpen[file.Name]
+      ? " [Hide versions]"
+      : ` [Versions (${file.Versions})]`;
+    link.title = "Show previous versions";
+    link.addEventListener("click", (e) => {
+      e.preventDefault();
+      if (state.versionsOpen[file.Name]) {
+        delete state.versionsOpen[file.Name];
+      } else {
+        state.versionsOpen[file.Name] = true;
+      }
+      fileListFetch();
+    });
+    return link;
+  }
+
+  function uiFormatDuration(seconds) {
+    const days = Math.floor(seconds / 86400);
+    const hours = Math.floor((seconds % 86400) / 3600);
+    const minutes = Math.floor((seconds % 3600) / 60);
+
+    if (days > 0) return `${days}d ${hours}h`;
+    if (hours > 0) return `${hours}h ${minutes}m`;
+    if (minutes > 0) return `${minutes}m`;
+    return `${seconds}s`;
+  }
+
   function uiFormatSize(bytes) {
     const units = ["B", "KB", "MB", "GB", "TB"];
     let i = 0;
@@ -364,6 +852,13 @@
     state.ui.currentFileName.textContent = "";
   }
 
+  function uiSetDegraded(degraded) {
+    if (state.config.Degraded === degraded) return;
+
+    state.config.Degraded = degraded;
+    uiUpdate();
+  }
+
   function uiShowError(msg) {
     uiShowMessage(msg, "error", 2000);
   }
@@ -386,21 +881,153 @@
   }
 
   function uiUpdate() {
+    if (state.config.Degraded) {
+      state.ui.degradedInfo.style.display = "block";
+    } else {
+      state.ui.degradedInfo.style.display = "none";
+    }
+
+    if (state.config.Endpoints.CACertificate) {
+      state.ui.caCertificateLink.href = state.config.Endpoints.CACertificate;
+      state.ui.caCertificateLink.style.display = "inline";
+    } else {
+      state.ui.caCertificateLink.style.display = "none";
+    }
+
+    const trashAvailable =
+      state.config.Endpoints.Trash &&
+      !state.config.Modes.Readonly &&
+      !state.config.Modes.Sinkhole;
+    if (!trashAvailable) state.view = "files";
+    state.ui.trashLink.style.display = trashAvailable ? "inline" : "none";
+    state.ui.trashLink.textContent =
+      state.view === "trash" ? "[Back to files]" : "[Trash]";
+
+    if (state.view === "trash") {
+      state.ui.dropzone.style.display = "none";
+      state.ui.ttl.style.display = "none";
+      state.ui.fileList.style.display = "none";
+      state.ui.fileListControls.style.display = "none";
+      state.ui.searchInfo.style.display = "none";
+      state.ui.searchResults.style.display = "none";
+      state.ui.sinkholeModeInfo.style.display = "none";
+      state.ui.usageInfo.style.display = "none";
+      state.ui.uploadResults.style.display = "none";
+      state.ui.trashList.style.display = "block";
+      return;
+    }
+
+    state.ui.uploadResults.style.display =
+      state.ui.uploadResults.childElementCount > 0 ? "block" : "none";
+
+    state.ui.trashList.style.display = "none";
+    state.ui.trashInfo.style.display = "none";
+
     if (state.config.Modes.Readonly) {
       state.ui.dropzone.style.display = "none";
+      state.ui.ttl.style.display = "none";
     } else {
       state.ui.dropzone.style.display = "block";
+      state.ui.ttl.style.display = "block";
+      uiUpdateTTLOptions();
     }
 
+    const searching = searchIsActive();
+    state.ui.searchInput.style.display = state.config.Endpoints.Search
+      ? "inline-block"
+      : "none";
+    state.ui.searchResults.style.display = searching ? "block" : "none";
+    state.ui.searchInfo.style.display =
+      searching && state.ui.searchResults.childElementCount === 0
+        ? "block"
+        : "none";
+
     if (state.config.Modes.Sinkhole) {
       state.ui.fileList.style.display = "none";
+      state.ui.fileListControls.style.display = "none";
       state.ui.sinkholeModeInfo.style.display = "block";
     } else {
-      state.ui.fileList.style.display = "block";
+      state.ui.fileList.style.display = searching ? "none" : "block";
+      state.ui.fileListControls.style.display = "flex";
       state.ui.sinkholeModeInfo.style.display = "none";
     }
   }
 
+  // uiUpdateTTLOptions offers every lifetime shorter than the global one,
+  // which is the default and the maximum.
+  function uiUpdateTTLOptions() {
+    const fileTTL = state.config.FileTTL;
+    if (state.ui.ttlSelect.dataset.fileTtl === String(fileTTL)) return;
+
+    const selected = state.ui.ttlSelect.value;
+    const options = [
+      ["", fileTTL > 0 ? `Default (${uiFormatDuration(fileTTL)})` : "Never"],
+      ["1h", "1 hour"],
+      ["24h", "1 day"],
+      ["168h", "7 days"],
+      ["720h", "30 days"],
+    ];
+    const seconds = {
+      "1h": 3600,
+      "24h": 86400,
+      "168h": 604800,
+      "720h": 2592000,
+    };
+
+    state.ui.ttlSelect.innerHTML = "";
+    options
+      .filter(
+        ([value]) => value === "" || fileTTL === 0 || seconds[value] < fileTTL
+      )
+      .forEach(([value, text]) => {
+        const option = document.createElement("option");
+        option.value = value;
+        option.textContent = text;
+        option.selected = value === selected;
+        state.ui.ttlSelect.appendChild(option);
+      });
+
+    state.ui.ttlSelect.dataset.fileTtl = String(fileTTL);
+  }
+
+  function uiUpdateUsage() {
+    const usage = state.usage;
+    if (!usage || state.config.Degraded || state.view === "trash") {
+      state.ui.usageInfo.style.display = "none";
+      return;
+    }
+
+    const parts = [];
+    if (usage.QuotaPerUser > 0) {
+      parts.push(
+        `${uiFormatSize(usage.UsedByUser)} of ${uiFormatSize(
+          usage.QuotaPerUser
+        )} used by you`
+      );
+    } else if (usage.Quota > 0) {
+      parts.push(
+        `${uiFormatSize(usage.Used)} of ${uiFormatSize(usage.Quota)} used`
+      );
+    } else if (!state.config.Modes.Sinkhole) {
+      parts.push(`${uiFormatSize(usage.Used)} used`);
+    }
+
+    if (usage.Deduplicated > 0) {
+      parts.push(`${uiFormatSize(usage.Deduplicated)} saved by deduplication`);
+    }
+
+    if (!state.config.Modes.Readonly && usage.Remaining >= 0) {
+      parts.push(`${uiFormatSize(usage.Remaining)} left`);
+    }
+
+    if (!state.config.Modes.Readonly && usage.MaxFileSize > 0) {
+      parts.push(`max. ${uiFormatSize(usage.MaxFileSize)} per file`);
+    }
+
+    state.ui.usageInfo.textContent = parts.join(" · ");
+    state.ui.usageInfo.style.display = parts.length > 0 ? "block" : "none";
+  }
+
   function uiUpdateProgress(totalUploaded, totalSize, startTime) {
     const percent = (totalUploaded / totalSize) * 100;
     state.ui.overallProgress.value = percent;
@@ -423,6 +1050,240 @@
       }`;
   }
 
+  // ===== search ===========================
+
+  // searchFetch shows the files whose content matches the query instead of
+  // the file list, as long as there is a query.
+  async function searchFetch() {
+    const query = state.searchQuery.trim();
+    if (!searchIsActive()) {
+      uiUpdate();
+      return;
+    }
+
+    try {
+      const params = new URLSearchParams({ q: query });
+      const res = await fetch(`${state.config.Endpoints.Search}?${params}`, {
+        cache: "no-store",
+      });
+      const data = await res.json();
+
+      // Answers to queries that were typed over are dropped.
+      if (query !== state.searchQuery.trim()) return;
+
+      if (!res.ok) {
+        searchRender([], data.error || "HTTP " + res.status);
+        return;
+      }
+      searchRender(data, "- No matches -");
+    } catch (err) {
+      console.error("searchFetch failed:", err);
+    }
+  }
+
+  function searchIsActive() {
+    return (
+      state.config !== null &&
+      !!state.config.Endpoints.Search &&
+      !state.config.Modes.Sinkhole &&
+      state.view === "files" &&
+      state.searchQuery.trim() !== ""
+    );
+  }
+
+  function searchRender(results, emptyText) {
+    state.ui.searchResults.innerHTML = "";
+
+    results.forEach((result) => {
+      const li = document.createElement("li");
+      li.appendChild(uiCreateDownloadLink(result));
+
+      if (result.Snippet) {
+        const snippet = document.createElement("div");
+        snippet.className = "search-snippet";
+        snippet.textContent = result.Snippet;
+        li.appendChild(snippet);
+      }
+
+      state.ui.searchResults.appendChild(li);
+    });
+
+    state.ui.searchInfo.textContent = emptyText;
+    uiUpdate();
+  }
+
+  // ===== trash ============================
+
+  async function trashActionClickHandler(event, entry, action) {
+    event.preventDefault();
+
+    const endpoint =
+      action === "restore"
+        ? state.config.Endpoints.TrashRestore
+        : state.config.Endpoints.TrashPurge;
+    if (
+      action === "purge" &&
+      !confirm(`Do you really want to delete "${entry.Name}" for good?`)
+    )
+      return;
+
+    try {
+      const res = await fetch(
+        endpoint.replace(":id", encodeURIComponent(entry.ID)),
+        { method: "GET" }
+      );
+
+      if (res.ok) {
+        uiShowSuccess(
+          (action === "restore" ? "File restored: " : "File purged: ") +
+            entry.Name
+        );
+      } else if (res.status === 409) {
+        uiShowError("File already exists: " + entry.Name);
+      } else {
+        uiShowError(action === "restore" ? "Restore failed" : "Purge failed");
+      }
+
+      trashListFetch();
+    } catch (err) {
+      uiShowError(action === "restore" ? "Restore failed" : "Purge failed");
+    }
+  }
+
+  async function trashListFetch() {
+    try {
+      const res = await fetch(state.config.Endpoints.Trash, {
+        cache: "no-store",
+      });
+      if (!res.ok) {
+        throw new Error("HTTP " + res.status);
+      }
+      const entries = await res.json();
+      entries.sort((a, b) => (a.DeletedAt < b.DeletedAt ? 1 : -1));
+      trashListRender(entries);
+    } catch (err) {
+      console.error("trashListFetch failed:", err);
+    }
+  }
+
+  function trashListRender(entries) {
+    state.ui.trashList.innerHTML = "";
+    state.ui.trashInfo.style.display = entries.length === 0 ? "block" : "none";
+
+    entries.forEach((entry) => {
+      const li = document.createElement("li");
+
+      const span = document.createElement("span");
+      span.className = "trash-entry";
+      span.textContent = `${entry.Name} (${uiFormatSize(
+        entry.Size
+      )}, purged in ${uiFormatDuration(entry.PurgeIn)})`;
+      span.title = "Deleted " + new Date(entry.DeletedAt).toLocaleString();
+      li.appendChild(span);
+
+      const restore = document.createElement("a");
+      restore.className = "restore-link";
+      restore.href = "#";
+      restore.textContent = " [Restore]";
+      restore.addEventListener("click", (e) =>
+        trashActionClickHandler(e, entry, "restore")
+      );
+      li.appendChild(restore);
+
+      const purge = document.createElement("a");
+      purge.className = "delete-link";
+      purge.href = "#";
+      purge.textContent = " [Purge]";
+      purge.addEventListener("click", (e) =>
+        trashActionClickHandler(e, entry, "purge")
+      );
+      li.appendChild(purge);
+
+      state.ui.trashList.appendChild(li);
+    });
+  }
+
+  // ===== versions =========================
+
+  async function versionListFetch(file, ul) {
+    try {
+      const res = await fetch(
+        state.config.Endpoints.Versions.replace(
+          ":filename",
+          encodeURIComponent(file.Name)
+        ),
+        { cache: "no-store" }
+      );
+      if (!res.ok) {
+        throw new Error("HTTP " + res.status);
+      }
+      versionListRender(file, ul, await res.json());
+    } catch (err) {
+      console.error("versionListFetch failed:", err);
+    }
+  }
+
+  function versionListRender(file, ul, versions) {
+    ul.innerHTML = "";
+
+    versions.forEach((version) => {
+      const li = document.createElement("li");
+
+      const link = document.createElement("a");
+      link.className = "download-link";
+      link.href = state.config.Endpoints.VersionsGet.replace(
+        ":filename",
+        encodeURIComponent(file.Name)
+      ).replace(":id", encodeURIComponent(version.ID));
+      link.textContent = `${new Date(
+        version.ArchivedAt
+      ).toLocaleString()} (${uiFormatSize(version.Size)})`;
+      li.appendChild(link);
+
+      if (!state.config.Modes.Readonly) {
+        const restore = document.createElement("a");
+        restore.className = "restore-link";
+        restore.href = "#";
+        restore.textContent = " [Restore]";
+        restore.title = "Make this version the current one";
+        restore.addEventListener("click", (e) =>
+          versionRestoreClickHandler(e, file, version)
+        );
+        li.appendChild(restore);
+      }
+
+      ul.appendChild(li);
+    });
+  }
+
+  async function versionRestoreClickHandler(event, file, version) {
+    event.preventDefault();
+    const archivedAt = new Date(version.ArchivedAt).toLocaleString();
+    if (!confirm(`Do you want to restore "${file.Name}" from ${archivedAt}?`))
+      return;
+
+    try {
+      const res = await fetch(
+        state.config.Endpoints.VersionsRestore.replace(
+          ":filename",
+          encodeURIComponent(file.Name)
+        ).replace(":id", encodeURIComponent(version.ID)),
+        { method: "GET" }
+      );
+
+      if (res.ok) {
+        uiShowSuccess("Version restored: " + file.Name);
+      } else {
+        uiShowError("Restore failed");
+      }
+
+      fileListFetch();
+      usageFetch();
+    } catch (err) {
+      uiShowError("Restore failed");
+    }
+  }
+
   // ===== upload ===========================
 
   function uploadFinish(success) {
@@ -431,11 +1292,63 @@
     state.ui.overallStatus.textContent = "";
     state.ui.currentFileName.textContent = "";
     fileListFetch();
+    usageFetch();
     if (success) {
       uiShowSuccess("Upload successful");
+    } else {
+      uiShowError("Some files could not be uploaded");
     }
   }
 
+  // uploadGetResults returns the result of every file in an upload response.
+  // Responses without results, e.g. from a proxy, are turned into a single
+  // failed result for file.
+  function uploadGetResults(xhr, file) {
+    try {
+      const response = JSON.parse(xhr.responseText);
+      if (Array.isArray(response) && response.length > 0) {
+        return response;
+      }
+    } catch (err) {
+      console.error("uploadGetResults failed:", err);
+    }
+
+    const messages = {
+      409: "File already exists.",
+      413: "File too large.",
+      503: "Server unavailable, try again later.",
+      507: "Not enough space left.",
+    };
+    return [
+      {
+        Error: messages[xhr.status] || "Upload failed.",
+        Name: file.name,
+        Size: file.size,
+        Status: xhr.status === 200 ? "ok" : "error",
+        StatusCode: xhr.status,
+        StoredName: fileSanitizeName(file.name),
+      },
+    ];
+  }
+
+  function uploadResultRender(result) {
+    const li = document.createElement("li");
+    if (result.Status === "ok") {
+      li.className = "upload-result success";
+      li.textContent = `${result.Name}: stored as ${
+        result.StoredName
+      } (${uiFormatSize(result.Size)})`;
+      const title = [];
+      if (result.SHA256) title.push("SHA-256: " + result.SHA256);
+      if (result.BLAKE3) title.push("BLAKE3: " + result.BLAKE3);
+      li.title = title.join("\n");
+    } else {
+      li.className = "upload-result error";
+      li.textContent = `${result.Name}: ${result.Error}`;
+    }
+    state.ui.uploadResults.appendChild(li);
+  }
+
   function uploadStart(fileListLike) {
     const files = Array.from(fileListLike);
     if (files.length === 0) return;
@@ -450,6 +1363,9 @@
     const startTime = Date.now();
     let allSuccessful = true;
 
+    state.ui.uploadResults.innerHTML = "";
+    state.ui.uploadResults.style.display = "block";
+
     function uploadNext() {
       if (currentIndex >= files.length) {
         uploadFinish(allSuccessful);
@@ -470,27 +1386,33 @@
       });
 
       xhr.addEventListener("load", () => {
-        if (xhr.status === 200) {
-          uploadedBytes += file.size;
-        } else if (xhr.status === 409) {
-          uiShowError("File already exists: " + file.name);
-          allSuccessful = false;
-        } else {
-          uiShowError("Upload failed: " + file.name);
-          allSuccessful = false;
-        }
+        uploadedBytes += file.size;
+        uploadGetResults(xhr, file).forEach((result) => {
+          uploadResultRender(result);
+          if (result.Status !== "ok") allSuccessful = false;
+        });
         currentIndex++;
         uploadNext();
       });
 
       xhr.addEventListener("error", () => {
         uiShowError("Network or server error during upload.");
+        uploadResultRender({
+          Error: "Network or server error.",
+          Name: file.name,
+          Status: "error",
+        });
         allSuccessful = false;
         currentIndex++;
         uploadNext();
       });
 
-      xhr.open("POST", state.config.Endpoints.Upload);
+      let uploadURL = state.config.Endpoints.Upload;
+      if (state.ui.ttlSelect.value) {
+        uploadURL += "?ttl=" + encodeURIComponent(state.ui.ttlSelect.value);
+      }
+
+      xhr.open("POST", uploadURL);
       xhr.send(form);
     }
 
@@ -498,6 +1420,24 @@
     uploadNext();
   }
 
+  // ===== usage ===========================
+
+  async function usageFetch() {
+    try {
+      const res = await fetch(state.config.Endpoints.Usage, {
+        cache: "no-store",
+      });
+      if (!res.ok) {
+        throw new Error("HTTP " + res.status);
+      }
+      state.usage = await res.json();
+    } catch (err) {
+      console.error("usageFetch failed:", err);
+      state.usage = null;
+    }
+    uiUpdateUsage();
+  }
+
   // ===== init ============================
 
   document.addEventListener("DOMContentLoaded", appInit);
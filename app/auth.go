@@ -2,10 +2,11 @@ package app
 
 import "net/http"
 
-func basicAuthMiddleware(handler http.Handler, username, password string) http.Handler {
+func basicAuthMiddleware(handler http.Handler, username, password string, onFailure func(r *http.Request)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, pass, ok := r.BasicAuth()
 		if !ok || user != username || pass != password {
+			onFailure(r)
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
@@ -13,3 +14,26 @@ func basicAuthMiddleware(handler http.Handler, username, password string) http.H
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// publicEndpointsMiddleware serves the health endpoints without basic
+// authentication, so load balancers and orchestrators can probe them.
+func (a *App) publicEndpointsMiddleware(handler http.Handler) http.Handler {
+	healthz := a.instrument(httpPathHealthz, a.httpGetHealthz)
+	readyz := a.instrument(httpPathReadyz, a.httpGetReadyz)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case httpPathHealthz:
+			healthz(w, r, nil)
+		case httpPathReadyz:
+			readyz(w, r, nil)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+// errInvalidDigest is returned for expected hashes a client sent in a format
+// that can't be parsed.
+var errInvalidDigest = errors.New("invalid digest")
+
+// getExpectedSHA256 returns the SHA-256 hash, hex encoded, a client expects a
+// part of an upload to have. It is taken from the Repr-Digest or
+// Content-Digest header of the part (RFC 9530), which are the same for parts
+// that are not encoded. It returns "" if the client didn't send one.
+func getExpectedSHA256(header http.Header) (string, error) {
+	for _, name := range []string{"Repr-Digest", "Content-Digest"} {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		return parseDigestHeader(value)
+	}
+
+	return "", nil
+}
+
+// parseDigestHeader returns the sha-256 member of a Repr-Digest or
+// Content-Digest header like `sha-256=:base64:`, hex encoded. Digests with
+// other algorithms are skipped, "" is returned if there is no sha-256 member.
+func parseDigestHeader(value string) (string, error) {
+	for _, member := range strings.Split(value, ",") {
+		algorithm, digest, found := strings.Cut(strings.TrimSpace(member), "=")
+		if !found {
+			return "", errInvalidDigest
+		}
+
+		if strings.ToLower(algorithm) != "sha-256" {
+			continue
+		}
+
+		digest, isByteSequence := strings.CutPrefix(digest, ":")
+		digest, isByteSequenceEnd := strings.CutSuffix(digest, ":")
+		if !isByteSequence || !isByteSequenceEnd {
+			return "", errInvalidDigest
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil || len(sum) != 32 {
+			return "", errInvalidDigest
+		}
+
+		return hex.EncodeToString(sum), nil
+	}
+
+	return "", nil
+}
+
+// parseSHA256 normalizes a hex encoded SHA-256 hash, e.g. from the sha256
+// query parameter of an upload.
+func parseSHA256(value string) (string, error) {
+	sum, err := hex.DecodeString(value)
+	if err != nil || len(sum) != 32 {
+		return "", errInvalidDigest
+	}
+
+	return hex.EncodeToString(sum), nil
+}
+
+// setDigestHeaders announces the SHA-256 hash of a file recorded at upload,
+// so clients can verify downloads. Repr-Digest refers to the whole file, even
+// for range requests. Digest is its predecessor from RFC 3230.
+func setDigestHeaders(w http.ResponseWriter, metadata filesystem.Metadata) {
+	sum, err := hex.DecodeString(metadata.SHA256)
+	if err != nil || len(sum) != 32 {
+		return
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	w.Header().Set("Digest", "sha-256="+encoded)
+	w.Header().Set("Repr-Digest", "sha-256=:"+encoded+":")
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Changes come in bursts, e.g. a file and its metadata sidecar, so they are
+// collected for a moment before the data folder is looked at. Without inotify,
+// the data folder is polled, but only while someone is subscribed.
+const eventsDebounceInterval time.Duration = 100 * time.Millisecond
+const eventsKeepAliveInterval time.Duration = 30 * time.Second
+const eventsPollInterval time.Duration = 2 * time.Second
+const eventsSubscriberBufferSize int = 64
+const eventsWatchRetryInterval time.Duration = time.Minute
+
+// errEventsStopped is returned to subscribers that come in after the event
+// stream was stopped for a shutdown.
+var errEventsStopped = errors.New("events stopped")
+
+// events keeps track of the subscribers of /events/ and of the state of the
+// data folder they were last told about.
+type events struct {
+	modified    map[string]bool
+	mutex       sync.Mutex
+	snapshot    map[string]fileState
+	stopped     bool
+	subscribers map[chan fileEvent]struct{}
+	trigger     chan struct{}
+	watching    atomic.Bool
+}
+
+// fileEvent is sent to subscribers as a Server-Sent Event of the type kind,
+// which is add, modify or remove.
+type fileEvent struct {
+	data []byte
+	kind string
+}
+
+// fileState is what changes of a file are detected by.
+type fileState struct {
+	modifiedAt time.Time
+	size       int64
+}
+
+func newEvents() *events {
+	return &events{
+		modified:    map[string]bool{},
+		subscribers: map[chan fileEvent]struct{}{},
+		trigger:     make(chan struct{}, 1),
+	}
+}
+
+// StartEvents sends changes of the files in the data folder to the
+// subscribers of /events/ until stop is called, which also ends every event
+// stream, so they don't hold up a shutdown. Changes are noticed via inotify on
+// Linux and by polling otherwise. Calling stop more than once is safe.
+func (a *App) StartEvents() (stop func()) {
+	done := make(chan struct{})
+
+	go a.watchDataFolder(done)
+	go a.processFileChanges(done)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			a.stopEventSubscribers()
+		})
+	}
+}
+
+// notifyFileChanged makes changes show up right away, even if the data folder
+// is polled. A filename forces a modify event for that file, which is needed
+// for changes that only touch its metadata. In search mode, it also makes the
+// indexer look for changes.
+func (a *App) notifyFileChanged(filename string) {
+	if filename != "" {
+		a.events.mutex.Lock()
+		a.events.modified[filename] = true
+		a.events.mutex.Unlock()
+	}
+
+	select {
+	case a.events.trigger <- struct{}{}:
+	default:
+	}
+
+	select {
+	case a.indexTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (a *App) processFileChanges(done <-chan struct{}) {
+	ticker := time.NewTicker(eventsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-a.events.trigger:
+			select {
+			case <-done:
+				return
+			case <-time.After(eventsDebounceInterval):
+			}
+		case <-ticker.C:
+			if a.events.watching.Load() {
+				continue
+			}
+		}
+
+		a.publishFileChanges()
+	}
+}
+
+// publishFileChanges compares the data folder with the last snapshot and sends
+// an event for every file that was added, modified or removed in between.
+func (a *App) publishFileChanges() {
+	a.events.mutex.Lock()
+
+	if len(a.events.subscribers) == 0 {
+		a.events.snapshot = nil
+		clear(a.events.modified)
+		a.events.mutex.Unlock()
+		return
+	}
+
+	files, err := a.storage.GetFilesOfDataFolder()
+	if err != nil {
+		// The snapshot is kept while the data folder is unavailable, so the
+		// subscribers are told about what changed once it is back.
+		a.events.mutex.Unlock()
+		return
+	}
+
+	snapshot := make(map[string]fileState, len(files))
+	for _, file := range files {
+		snapshot[file.Name] = fileState{modifiedAt: file.ModifiedAt, size: file.Size}
+	}
+
+	removed := []string{}
+	for filename := range a.events.snapshot {
+		if _, exists := snapshot[filename]; !exists {
+			removed = append(removed, filename)
+		}
+	}
+
+	// Files are sorted by name, so the events are as well.
+	kinds := map[string]string{}
+	for _, file := range files {
+		previous, existed := a.events.snapshot[file.Name]
+		switch {
+		case !existed:
+			kinds[file.Name] = "add"
+		case previous != snapshot[file.Name] || a.events.modified[file.Name]:
+			kinds[file.Name] = "modify"
+		}
+	}
+
+	a.events.snapshot = snapshot
+	clear(a.events.modified)
+	a.events.mutex.Unlock()
+
+	slices.Sort(removed)
+
+	changes := []fileEvent{}
+	for _, filename := range removed {
+		data, _ := json.Marshal(struct {
+			Name string `json:"Name"`
+		}{Name: filename})
+		changes = append(changes, fileEvent{data: data, kind: "remove"})
+	}
+
+	for _, file := range files {
+		kind, changed := kinds[file.Name]
+		if !changed {
+			continue
+		}
+
+		data, _ := json.Marshal(a.getFileInfo(a.config.Logger, file))
+		changes = append(changes, fileEvent{data: data, kind: kind})
+	}
+
+	a.events.mutex.Lock()
+	defer a.events.mutex.Unlock()
+
+	for subscriber := range a.events.subscribers {
+		for _, change := range changes {
+			select {
+			case subscriber <- change:
+				continue
+			default:
+			}
+
+			// Subscribers that can't keep up are dropped. Their client
+			// reconnects and loads the whole list again.
+			delete(a.events.subscribers, subscriber)
+			close(subscriber)
+			break
+		}
+	}
+}
+
+func (a *App) stopEventSubscribers() {
+	a.events.mutex.Lock()
+	defer a.events.mutex.Unlock()
+
+	a.events.stopped = true
+	for subscriber := range a.events.subscribers {
+		delete(a.events.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// subscribeEvents returns a channel that receives every change from now on.
+// The first subscriber takes the snapshot later changes are compared with.
+func (a *App) subscribeEvents() (chan fileEvent, error) {
+	a.events.mutex.Lock()
+	defer a.events.mutex.Unlock()
+
+	if a.events.stopped {
+		return nil, errEventsStopped
+	}
+
+	if a.events.snapshot == nil {
+		files, err := a.storage.GetFilesOfDataFolder()
+		if err != nil {
+			return nil, err
+		}
+
+		a.events.snapshot = make(map[string]fileState, len(files))
+		for _, file := range files {
+			a.events.snapshot[file.Name] = fileState{modifiedAt: file.ModifiedAt, size: file.Size}
+		}
+	}
+
+	subscriber := make(chan fileEvent, eventsSubscriberBufferSize)
+	a.events.subscribers[subscriber] = struct{}{}
+
+	return subscriber, nil
+}
+
+func (a *App) unsubscribeEvents(subscriber chan fileEvent) {
+	a.events.mutex.Lock()
+	defer a.events.mutex.Unlock()
+
+	if _, subscribed := a.events.subscribers[subscriber]; subscribed {
+		delete(a.events.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// watchDataFolder makes the changes of the data folder trigger events, as
+// long as the platform supports it. While it can't be watched, e.g. because
+// it is unavailable, it is polled instead.
+func (a *App) watchDataFolder(done <-chan struct{}) {
+	for {
+		a.events.watching.Store(true)
+		err := a.storage.WatchDataFolder(done, func() { a.notifyFileChanged("") })
+		a.events.watching.Store(false)
+
+		if errors.Is(err, errors.ErrUnsupported) {
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		a.config.Logger.Warn("Could not watch the data folder, polling instead", "error", err)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(eventsWatchRetryInterval):
+		}
+	}
+}
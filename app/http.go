@@ -2,37 +2,71 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"mime"
 	"net/http"
-	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"git.0x0001f346.de/andreas/ablage/config"
 	"git.0x0001f346.de/andreas/ablage/filesystem"
+	"git.0x0001f346.de/andreas/ablage/search"
 	"github.com/julienschmidt/httprouter"
 )
 
 const httpPathRoot string = "/"
+const httpPathCACertificate string = "/ca.crt"
 const httpPathConfig string = "/config/"
+const httpPathEvents string = "/events/"
 const httpPathFaviconICO string = "/favicon.ico"
 const httpPathFaviconSVG string = "/favicon.svg"
 const httpPathFiles string = "/files/"
 const httpPathFilesDeleteFilename string = "/files/delete/:filename"
 const httpPathFilesGetFilename string = "/files/get/:filename"
+const httpPathFilesMetadataFilename string = "/files/metadata/:filename"
+const httpPathHealthz string = "/healthz"
+const httpPathMetrics string = "/metrics"
+const httpPathReadyz string = "/readyz"
 const httpPathScriptJS string = "/script.js"
+const httpPathSearch string = "/search/"
 const httpPathStyleCSS string = "/style.css"
+const httpPathTrash string = "/trash/"
+const httpPathTrashPurgeID string = "/trash/purge/:id"
+const httpPathTrashRestoreID string = "/trash/restore/:id"
 const httpPathUpload string = "/upload/"
+const httpPathUsage string = "/usage/"
+const httpPathVersionsFilename string = "/versions/:filename"
+const httpPathVersionsFilenameGetID string = "/versions/:filename/get/:id"
+const httpPathVersionsFilenameRestoreID string = "/versions/:filename/restore/:id"
+
+func (a *App) httpGetCACertificate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"ablage-ca.crt\"")
+	w.Write(a.config.GetCACertificate())
+}
 
-func httpGetConfig(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) httpGetConfig(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	type Endpoints struct {
-		Files       string `json:"Files"`
-		FilesDelete string `json:"FilesDelete"`
-		FilesGet    string `json:"FilesGet"`
-		Upload      string `json:"Upload"`
+		CACertificate   string `json:"CACertificate"`
+		Events          string `json:"Events"`
+		Files           string `json:"Files"`
+		FilesDelete     string `json:"FilesDelete"`
+		FilesGet        string `json:"FilesGet"`
+		FilesMetadata   string `json:"FilesMetadata"`
+		Search          string `json:"Search"`
+		Trash           string `json:"Trash"`
+		TrashPurge      string `json:"TrashPurge"`
+		TrashRestore    string `json:"TrashRestore"`
+		Upload          string `json:"Upload"`
+		Usage           string `json:"Usage"`
+		Versions        string `json:"Versions"`
+		VersionsGet     string `json:"VersionsGet"`
+		VersionsRestore string `json:"VersionsRestore"`
 	}
 
 	type Modes struct {
@@ -41,82 +75,186 @@ func httpGetConfig(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	}
 
 	type Config struct {
-		Endpoints Endpoints `json:"Endpoints"`
-		Modes     Modes     `json:"Modes"`
+		CollisionPolicy string    `json:"CollisionPolicy"`
+		Degraded        bool      `json:"Degraded"`
+		Endpoints       Endpoints `json:"Endpoints"`
+		FileTTL         int64     `json:"FileTTL"`
+		Modes           Modes     `json:"Modes"`
 	}
 
-	var config Config = Config{
+	var response Config = Config{
+		CollisionPolicy: a.config.CollisionPolicy,
+		Degraded:        a.storage.CheckAvailability() != nil,
+		FileTTL:         int64(a.config.FileTTL.Seconds()),
 		Endpoints: Endpoints{
-			Files:       httpPathFiles,
-			FilesDelete: httpPathFilesDeleteFilename,
-			FilesGet:    httpPathFilesGetFilename,
-			Upload:      httpPathUpload,
+			Events:        httpPathEvents,
+			Files:         httpPathFiles,
+			FilesDelete:   httpPathFilesDeleteFilename,
+			FilesGet:      httpPathFilesGetFilename,
+			FilesMetadata: httpPathFilesMetadataFilename,
+			Upload:        httpPathUpload,
+			Usage:         httpPathUsage,
 		},
 		Modes: Modes{
-			Readonly: config.GetReadonlyMode(),
-			Sinkhole: config.GetSinkholeMode(),
+			Readonly: a.config.ReadonlyMode,
+			Sinkhole: a.config.SinkholeMode,
 		},
 	}
 
+	if a.config.CAMode {
+		response.Endpoints.CACertificate = httpPathCACertificate
+	}
+
+	if a.config.SearchMode {
+		response.Endpoints.Search = httpPathSearch
+	}
+
+	if a.config.TrashMode {
+		response.Endpoints.Trash = httpPathTrash
+		response.Endpoints.TrashPurge = httpPathTrashPurgeID
+		response.Endpoints.TrashRestore = httpPathTrashRestoreID
+	}
+
+	if a.config.VersioningMode {
+		response.Endpoints.Versions = httpPathVersionsFilename
+		response.Endpoints.VersionsGet = httpPathVersionsFilenameGetID
+		response.Endpoints.VersionsRestore = httpPathVersionsFilenameRestoreID
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(config)
+	json.NewEncoder(w).Encode(response)
+}
+
+// httpGetEvents streams the changes of the files in the data folder as
+// Server-Sent Events. add and modify events carry the file as it is listed on
+// /files/, remove events only its name. Clients should load the list once the
+// stream is open and apply the events to it.
+func (a *App) httpGetEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.SinkholeMode {
+		http.Error(w, "404 File Not Found", http.StatusNotFound)
+		return
+	}
+
+	subscriber, err := a.subscribeEvents()
+	if errors.Is(err, errEventsStopped) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+	defer a.unsubscribeEvents(subscriber)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	controller := http.NewResponseController(w)
+
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if controller.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, subscribed := <-subscriber:
+			if !subscribed {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.kind, event.data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+
+		if controller.Flush() != nil {
+			return
+		}
+	}
 }
 
-func httpGetFaviconICO(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) httpGetFaviconICO(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	http.Redirect(w, r, "/favicon.svg", http.StatusSeeOther)
 }
 
-func httpGetFaviconSVG(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) httpGetFaviconSVG(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "image/svg+xml")
 	w.Write(assetFaviconSVG)
 }
 
-func httpGetFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	type FileInfo struct {
-		Name string `json:"Name"`
-		Size int64  `json:"Size"`
+// httpGetFiles lists the files in the data folder along with their metadata.
+// The query parameters of the list are described by parseFileListOptions. If
+// there are more files than the limit, the Link header points at the next
+// page.
+func (a *App) httpGetFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.SinkholeMode {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]fileInfo{})
+		return
 	}
 
-	if config.GetSinkholeMode() {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]FileInfo{})
+	options, err := parseFileListOptions(r.URL.Query())
+	if err != nil {
+		httpWriteError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	files, err := filesystem.GetFileListOfDataFolder()
+	files, err := a.storage.GetFilesOfDataFolder()
 	if err != nil {
-		log.Fatalf("[Error] %v", err)
+		httpWriteStorageError(w, err)
+		return
 	}
 
-	fileInfos := make([]FileInfo, 0, len(files))
+	// Files with the same content share their modification time in dedup
+	// mode, so sorting and filtering by time needs the metadata of every
+	// file. Otherwise metadata is only read for the files of the requested
+	// page, which keeps large folders fast.
+	if a.config.DedupMode && options.usesModifiedAt() {
+		a.storage.ResolveModifiedAt(files)
+	}
 
-	for filename, sizeInBytes := range files {
-		fileInfos = append(
-			fileInfos,
-			FileInfo{
-				Name: filename,
-				Size: sizeInBytes,
-			},
-		)
+	page, hasMore := getFileListPage(files, options)
+
+	fileInfos := make([]fileInfo, 0, len(page))
+	for _, file := range page {
+		fileInfos = append(fileInfos, a.getFileInfo(a.getLogger(r), file))
+	}
+
+	if hasMore {
+		query := r.URL.Query()
+		query.Set("cursor", options.getCursor(page[len(page)-1]))
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+query.Encode()+">; rel=\"next\"")
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(fileInfos)
 }
 
-func httpGetFilesDeleteFilename(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if config.GetReadonlyMode() {
+func (a *App) httpGetFilesDeleteFilename(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.ReadonlyMode {
 		http.Error(w, "403 Forbidden", http.StatusForbidden)
 		return
 	}
 
-	if config.GetSinkholeMode() {
+	if a.config.SinkholeMode {
 		http.Error(w, "404 File Not Found", http.StatusNotFound)
 		return
 	}
 
 	filename := ps.ByName("filename")
 
-	files, err := filesystem.GetFileListOfDataFolder()
+	files, err := a.storage.GetFileListOfDataFolder()
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
 
 	sizeInBytes, fileExists := files[filename]
 	if !fileExists {
@@ -125,29 +263,35 @@ func httpGetFilesDeleteFilename(w http.ResponseWriter, r *http.Request, ps httpr
 		return
 	}
 
-	err = filesystem.DeleteFile(filename)
+	if a.config.TrashMode {
+		_, err = a.storage.MoveFileToTrash(filename, a.getUploader(r))
+	} else {
+		err = a.storage.DeleteFile(filename)
+	}
 	if err != nil {
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		httpWriteStorageError(w, err)
 		return
 	}
 
-	log.Printf("| Delete   | %-21s | %-10s | %s\n", getClientIP(r), filesystem.GetHumanReadableSize(sizeInBytes), filename)
+	a.getLogger(r).Info("Delete", "client_ip", a.getClientIP(r), "size", sizeInBytes, "file", filename)
+	a.notifyFileChanged("")
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
-func httpGetFilesGetFilename(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if config.GetSinkholeMode() {
+func (a *App) httpGetFilesGetFilename(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.SinkholeMode {
 		http.Error(w, "404 File Not Found", http.StatusNotFound)
 		return
 	}
 
 	filename := ps.ByName("filename")
 
-	files, err := filesystem.GetFileListOfDataFolder()
+	files, err := a.storage.GetFileListOfDataFolder()
 	if err != nil {
-		log.Fatalf("[Error] %v", err)
+		httpWriteStorageError(w, err)
+		return
 	}
 
 	sizeInBytes, fileExists := files[filename]
@@ -156,7 +300,7 @@ func httpGetFilesGetFilename(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	log.Printf("| Download | %-21s | %-10s | %s\n", getClientIP(r), filesystem.GetHumanReadableSize(sizeInBytes), filename)
+	a.getLogger(r).Info("Download", "client_ip", a.getClientIP(r), "size", sizeInBytes, "file", filename)
 
 	extension := strings.ToLower(filepath.Ext(filename))
 	mimeType := mime.TypeByExtension(extension)
@@ -171,33 +315,559 @@ func httpGetFilesGetFilename(w http.ResponseWriter, r *http.Request, ps httprout
 		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
 	}
 
-	http.ServeFile(w, r, filepath.Join(config.GetPathDataFolder(), filename))
+	metadata, err := a.storage.GetMetadata(filename)
+	if err != nil {
+		a.getLogger(r).Warn("Could not read metadata", "file", filename, "error", err)
+	}
+	setDigestHeaders(w, metadata)
+
+	http.ServeFile(w, r, filepath.Join(a.config.PathDataFolder, filename))
+}
+
+// httpGetHealthz only reports that the process is able to serve requests.
+// Whether it should receive traffic is reported by httpGetReadyz.
+func (a *App) httpGetHealthz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"Status":"ok"}`))
 }
 
-func httpGetRoot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) httpGetMetrics(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	files, err := a.storage.GetFileListOfDataFolder()
+
+	var folderSize int64
+	for _, sizeInBytes := range files {
+		folderSize += sizeInBytes
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.metrics.writeTo(w, len(files), folderSize, err)
+}
+
+func (a *App) httpGetReadyz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type Check struct {
+		Error  string `json:"Error,omitempty"`
+		Name   string `json:"Name"`
+		Status string `json:"Status"`
+	}
+
+	type Readiness struct {
+		Checks []Check `json:"Checks"`
+		Status string  `json:"Status"`
+	}
+
+	var notDraining error
+	if a.draining.Load() {
+		notDraining = fmt.Errorf("Shutting down.")
+	}
+
+	results := []struct {
+		name string
+		err  error
+	}{
+		{name: "DataFolderReadable", err: a.storage.CheckAvailability()},
+		{name: "DataFolderWritable", err: a.storage.CheckDataFolderWritable()},
+		{name: "FreeDiskSpace", err: a.storage.CheckFreeDiskSpace(a.config.HealthMinFreeDiskSpace)},
+		{name: "NotDraining", err: notDraining},
+		{name: "UploadFolder", err: a.storage.CheckUploadFolder()},
+	}
+
+	var response Readiness = Readiness{
+		Checks: make([]Check, 0, len(results)),
+		Status: "ok",
+	}
+
+	for _, result := range results {
+		check := Check{Name: result.name, Status: "ok"}
+		if result.err != nil {
+			check.Error = result.err.Error()
+			check.Status = "fail"
+			response.Status = "fail"
+		}
+
+		response.Checks = append(response.Checks, check)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *App) httpGetRoot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write(assetIndexHTML)
 }
 
-func httpGetScriptJS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) httpGetScriptJS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "text/javascript")
 	w.Write(assetScriptJS)
 }
 
-func httpGetStyleCSS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// httpGetSearch answers with the files whose text contains every word of the
+// query parameter q, the best matches first. Every result has a snippet of
+// the text around the first match.
+func (a *App) httpGetSearch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type Result struct {
+		fileInfo
+		Score   float64 `json:"Score"`
+		Snippet string  `json:"Snippet,omitempty"`
+	}
+
+	if a.config.SinkholeMode {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]Result{})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			httpWriteError(w, http.StatusBadRequest, fmt.Sprintf("The limit must be between 1 and %d.", maxSearchLimit))
+			return
+		}
+	}
+
+	results, err := a.index.Search(r.URL.Query().Get("q"), limit)
+	if errors.Is(err, search.ErrEmptyQuery) {
+		httpWriteError(w, http.StatusBadRequest, "The query must contain at least one word of two or more letters or digits.")
+		return
+	}
+	if err != nil {
+		httpWriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := make([]Result, 0, len(results))
+	for _, result := range results {
+		file := filesystem.File{
+			ModifiedAt: result.ModifiedAt,
+			Name:       result.Name,
+			Size:       result.Size,
+		}
+
+		response = append(response, Result{
+			fileInfo: a.getFileInfo(a.getLogger(r), file),
+			Score:    result.Score,
+			Snippet:  result.Snippet,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *App) httpGetStyleCSS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "text/css")
 	w.Write(assetStyleCSS)
 }
 
-func httpPostUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if config.GetReadonlyMode() {
+func (a *App) httpGetTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type TrashEntry struct {
+		DeletedAt time.Time `json:"DeletedAt"`
+		ID        string    `json:"ID"`
+		Name      string    `json:"Name"`
+		PurgeIn   int64     `json:"PurgeIn"`
+		Size      int64     `json:"Size"`
+	}
+
+	if a.config.SinkholeMode {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]TrashEntry{})
+		return
+	}
+
+	trash, err := a.storage.GetTrash()
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	trashEntries := make([]TrashEntry, 0, len(trash))
+	for _, trashEntry := range trash {
+		purgeAt := trashEntry.DeletedAt.Add(a.config.TrashRetention)
+
+		trashEntries = append(
+			trashEntries,
+			TrashEntry{
+				DeletedAt: trashEntry.DeletedAt,
+				ID:        trashEntry.ID,
+				Name:      trashEntry.Name,
+				PurgeIn:   max(int64(time.Until(purgeAt).Seconds()), 1),
+				Size:      trashEntry.Size,
+			},
+		)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trashEntries)
+}
+
+func (a *App) httpGetTrashPurgeID(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.ReadonlyMode {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if a.config.SinkholeMode {
+		http.Error(w, "404 File Not Found", http.StatusNotFound)
+		return
+	}
+
+	trashEntry, err := a.storage.PurgeFromTrash(ps.ByName("id"))
+	if err != nil {
+		httpWriteTrashError(w, err)
+		return
+	}
+
+	a.getLogger(r).Info("Purge", "client_ip", a.getClientIP(r), "size", trashEntry.Size, "file", trashEntry.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+func (a *App) httpGetTrashRestoreID(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.ReadonlyMode {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if a.config.SinkholeMode {
+		http.Error(w, "404 File Not Found", http.StatusNotFound)
+		return
+	}
+
+	trashEntry, err := a.storage.RestoreFromTrash(ps.ByName("id"))
+	if err != nil {
+		httpWriteTrashError(w, err)
+		return
+	}
+
+	a.getLogger(r).Info("Restore", "client_ip", a.getClientIP(r), "size", trashEntry.Size, "file", trashEntry.Name)
+	a.notifyFileChanged(trashEntry.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// httpGetUsage reports the space used by the files in the data folder and
+// how much can still be uploaded. Limits that are not configured are 0,
+// unknown or unlimited values are -1. Sizes are the sizes of the files, the
+// space saved by dedup mode is reported on its own.
+func (a *App) httpGetUsage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type Usage struct {
+		Deduplicated  int64 `json:"Deduplicated"`
+		FreeDiskSpace int64 `json:"FreeDiskSpace"`
+		MaxFileSize   int64 `json:"MaxFileSize"`
+		MinFreeSpace  int64 `json:"MinFreeSpace"`
+		Quota         int64 `json:"Quota"`
+		QuotaPerUser  int64 `json:"QuotaPerUser"`
+		Remaining     int64 `json:"Remaining"`
+		Used          int64 `json:"Used"`
+		UsedByUser    int64 `json:"UsedByUser"`
+	}
+
+	uploader := ""
+	if a.config.QuotaPerUser > 0 {
+		uploader = a.getUploader(r)
+	}
+
+	usage, err := a.storage.GetUsage(uploader)
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	var deduplicated int64
+	if a.config.DedupMode {
+		deduplicated, err = a.storage.GetDeduplicationSavings()
+		if err != nil {
+			a.getLogger(r).Warn("Could not determine deduplication savings", "error", err)
+		}
+	}
+
+	var response Usage = Usage{
+		Deduplicated:  deduplicated,
+		FreeDiskSpace: a.getFreeDiskSpace(),
+		MaxFileSize:   a.config.QuotaMaxFileSize,
+		MinFreeSpace:  a.config.QuotaMinFreeDiskSpace,
+		Quota:         a.config.QuotaTotal,
+		QuotaPerUser:  a.config.QuotaPerUser,
+		Remaining:     -1,
+		Used:          usage.TotalBytes,
+		UsedByUser:    usage.UploaderBytes,
+	}
+
+	remaining := []int64{}
+	if a.config.QuotaTotal > 0 {
+		remaining = append(remaining, a.config.QuotaTotal-usage.TotalBytes)
+	}
+	if a.config.QuotaPerUser > 0 {
+		remaining = append(remaining, a.config.QuotaPerUser-usage.UploaderBytes)
+	}
+	if response.FreeDiskSpace >= 0 {
+		remaining = append(remaining, response.FreeDiskSpace-a.config.QuotaMinFreeDiskSpace)
+	}
+	if len(remaining) > 0 {
+		response.Remaining = max(slices.Min(remaining), 0)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *App) httpGetVersionsFilename(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type Version struct {
+		ArchivedAt time.Time `json:"ArchivedAt"`
+		ID         string    `json:"ID"`
+		Size       int64     `json:"Size"`
+	}
+
+	if a.config.SinkholeMode {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]Version{})
+		return
+	}
+
+	versions, err := a.storage.GetVersions(ps.ByName("filename"))
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	response := make([]Version, 0, len(versions))
+	for _, version := range versions {
+		response = append(
+			response,
+			Version{
+				ArchivedAt: version.ArchivedAt,
+				ID:         version.ID,
+				Size:       version.Size,
+			},
+		)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *App) httpGetVersionsFilenameGetID(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.SinkholeMode {
+		http.Error(w, "404 File Not Found", http.StatusNotFound)
+		return
+	}
+
+	filename := ps.ByName("filename")
+
+	version, err := a.storage.GetVersion(filename, ps.ByName("id"))
+	if err != nil {
+		httpWriteVersionError(w, err)
+		return
+	}
+
+	a.getLogger(r).Info("Download", "client_ip", a.getClientIP(r), "size", version.Size, "file", filename, "version", version.ID)
+
+	extension := strings.ToLower(filepath.Ext(filename))
+	mimeType := mime.TypeByExtension(extension)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimeType)
+
+	if isBrowserDisplayableFileType(extension) {
+		w.Header().Set("Content-Disposition", "inline; filename=\""+filename+"\"")
+	} else {
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	}
+
+	setDigestHeaders(w, version.Metadata)
+
+	http.ServeFile(w, r, a.storage.GetPathVersionFile(filename, version.ID))
+}
+
+func (a *App) httpGetVersionsFilenameRestoreID(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.ReadonlyMode {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if a.config.SinkholeMode {
+		http.Error(w, "404 File Not Found", http.StatusNotFound)
+		return
+	}
+
+	filename := ps.ByName("filename")
+
+	version, err := a.storage.RestoreVersion(filename, ps.ByName("id"))
+	if err != nil {
+		httpWriteVersionError(w, err)
+		return
+	}
+
+	a.getLogger(r).Info("Rollback", "client_ip", a.getClientIP(r), "size", version.Size, "file", filename, "version", version.ID)
+	a.notifyFileChanged(filename)
+
+	a.pruneVersions(r, filename)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// httpPostFilesMetadataFilename replaces the description and the tags of a
+// file. The rest of its metadata is recorded at upload and can't be changed.
+func (a *App) httpPostFilesMetadataFilename(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	type Request struct {
+		Description string   `json:"Description"`
+		Tags        []string `json:"Tags"`
+	}
+
+	if a.config.ReadonlyMode {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if a.config.SinkholeMode {
+		http.Error(w, "404 File Not Found", http.StatusNotFound)
+		return
+	}
+
+	filename := ps.ByName("filename")
+
+	files, err := a.storage.GetFileListOfDataFolder()
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	if _, fileExists := files[filename]; !fileExists {
+		httpWriteError(w, http.StatusNotFound, "The file does not exist.")
+		return
+	}
+
+	var request Request
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&request)
+	if err != nil {
+		httpWriteError(w, http.StatusBadRequest, "The request must be a JSON object with a Description and Tags.")
+		return
+	}
+
+	description, err := normalizeDescription(request.Description)
+	if err != nil {
+		httpWriteError(w, http.StatusBadRequest, fmt.Sprintf("The description must be at most %d characters long.", maxLenDescription))
+		return
+	}
+
+	tags, err := normalizeTags(request.Tags)
+	if err != nil {
+		httpWriteError(w, http.StatusBadRequest, fmt.Sprintf("At most %d tags of at most %d characters without commas are allowed.", maxNumberOfTags, maxLenTag))
+		return
+	}
+
+	metadata, err := a.storage.GetMetadata(filename)
+	if err != nil {
+		a.getLogger(r).Warn("Could not read metadata", "file", filename, "error", err)
+		httpWriteStorageError(w, a.getStorageError(err))
+		return
+	}
+
+	metadata.Description = description
+	metadata.Tags = tags
+
+	err = a.storage.SaveMetadata(filename, metadata)
+	if err != nil {
+		a.getLogger(r).Warn("Could not save metadata", "file", filename, "error", err)
+		httpWriteStorageError(w, a.getStorageError(err))
+		return
+	}
+
+	a.getLogger(r).Info("Edit", "client_ip", a.getClientIP(r), "file", filename)
+	a.notifyFileChanged(filename)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// httpPostUpload stores every file of a multipart request and responds with a
+// JSON array of the result of every file, see uploadResult. A file that can't
+// be stored doesn't keep the following files from being stored.
+func (a *App) httpPostUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if a.config.ReadonlyMode {
 		http.Error(w, "403 Forbidden", http.StatusForbidden)
 		return
 	}
 
-	_, err := filesystem.GetFileListOfDataFolder()
+	if a.draining.Load() {
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	_, err := a.storage.GetFileListOfDataFolder()
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	uploader := a.getUploader(r)
+
+	var expiresAt time.Time
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil || duration <= 0 {
+			httpWriteError(w, http.StatusBadRequest, "The ttl must be a positive duration like 1h or 168h.")
+			return
+		}
+		expiresAt = time.Now().Add(duration)
+	}
+
+	collisionPolicy := a.config.CollisionPolicy
+	if policy := r.URL.Query().Get("collision"); policy != "" {
+		if !config.IsValidCollisionPolicy(policy) {
+			httpWriteError(w, http.StatusBadRequest, "The collision policy must be one of reject, overwrite, auto-rename or keep-both.")
+			return
+		}
+		collisionPolicy = policy
+	}
+
+	var expectedSHA256 string
+	if sum := r.URL.Query().Get("sha256"); sum != "" {
+		expectedSHA256, err = parseSHA256(sum)
+		if err != nil {
+			httpWriteError(w, http.StatusBadRequest, "The sha256 must be a hex encoded SHA-256 hash.")
+			return
+		}
+	}
+
+	// Reject uploads that can't fit before receiving them. The body contains
+	// the multipart boundaries as well, but they are small compared to files.
+	usage, err := a.getUsageForQuota(uploader)
+	if err != nil {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	err = a.checkQuota(uploader, usage, a.getFreeDiskSpaceForQuota(), max(r.ContentLength, 1))
 	if err != nil {
-		log.Fatalf("[Error] %v", err)
+		httpWriteQuotaError(w, err)
+		return
+	}
+
+	a.metrics.activeUploads.Add(1)
+	defer a.metrics.activeUploads.Add(-1)
+
+	options := uploadOptions{
+		collisionPolicy: collisionPolicy,
+		expectedSHA256:  expectedSHA256,
+		expiresAt:       expiresAt,
+		uploader:        uploader,
 	}
 
 	reader, err := r.MultipartReader()
@@ -206,59 +876,148 @@ func httpPostUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		return
 	}
 
+	files := 0
+	results := []uploadResult{}
 	for {
 		part, err := reader.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error reading part: %v", err), http.StatusInternalServerError)
-			return
+			results = append(
+				results,
+				uploadResult{
+					Error:      fmt.Sprintf("Error reading part: %v", err),
+					Status:     "error",
+					StatusCode: http.StatusInternalServerError,
+				},
+			)
+			break
 		}
-		defer part.Close()
 
 		if part.FileName() == "" {
+			part.Close()
 			continue
 		}
 
-		safeFilename := filesystem.SanitizeFilename(part.FileName())
-		pathToFileInDataFolder := filepath.Join(config.GetPathDataFolder(), safeFilename)
-		pathToFileInUploadFolder := filepath.Join(config.GetPathUploadFolder(), safeFilename)
-
-		if _, err = os.Stat(pathToFileInDataFolder); err == nil {
-			http.Error(w, "File already exists", http.StatusConflict)
-			return
-		}
-		if _, err = os.Stat(pathToFileInUploadFolder); err == nil {
-			http.Error(w, "File already exists", http.StatusConflict)
-			return
+		files++
+		if options.expectedSHA256 != "" && files > 1 {
+			part.Close()
+			results = append(results, uploadResult{Name: part.FileName()}.withError(errAmbiguousSHA256))
+			continue
 		}
 
-		uploadFile, err := os.Create(pathToFileInUploadFolder)
-		if err != nil {
-			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		result := a.storeUploadedPart(r, part, options)
+		part.Close()
+		results = append(results, result)
 
-		bytesWritten, err := io.Copy(uploadFile, part)
-		uploadFile.Close()
-		if err != nil {
-			_ = os.Remove(pathToFileInUploadFolder)
-			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
-			return
+		// Without the data folder, none of the remaining files can be
+		// stored either.
+		if result.StatusCode == http.StatusServiceUnavailable {
+			break
 		}
+	}
 
-		if err = os.Rename(pathToFileInUploadFolder, pathToFileInDataFolder); err != nil {
-			_ = os.Remove(pathToFileInDataFolder)
-			_ = os.Remove(pathToFileInUploadFolder)
-			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
-			return
+	httpWriteUploadResults(w, results)
+}
+
+// pruneVersions deletes the versions of filename beyond VersionsToKeep. A
+// failure is only logged, as the upload or rollback itself succeeded.
+func (a *App) pruneVersions(r *http.Request, filename string) {
+	pruned, err := a.storage.PruneVersions(filename, a.config.VersionsToKeep)
+	for _, version := range pruned {
+		a.getLogger(r).Debug("Prune version", "size", version.Size, "file", filename, "version", version.ID)
+	}
+	if err != nil {
+		a.getLogger(r).Warn("Could not prune versions", "file", filename, "error", err)
+	}
+}
+
+func httpWriteError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message, "status": "error"})
+}
+
+// httpWriteQuotaError responds with the status code of a *quotaError and
+// treats every other error as an interrupted upload. The connection is closed,
+// as the rest of the request body won't be read.
+func httpWriteQuotaError(w http.ResponseWriter, err error) {
+	w.Header().Set("Connection", "close")
+
+	if errors.Is(err, filesystem.ErrDataFolderUnavailable) {
+		httpWriteStorageError(w, err)
+		return
+	}
+
+	statusCode, message := getUploadErrorResponse(err)
+	httpWriteError(w, statusCode, message)
+}
+
+func httpWriteTrashError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, filesystem.ErrFileExists):
+		httpWriteError(w, http.StatusConflict, "A file with this name exists, delete it first.")
+	case errors.Is(err, filesystem.ErrTrashEntryNotFound):
+		httpWriteError(w, http.StatusNotFound, "The file is not in the trash.")
+	default:
+		httpWriteStorageError(w, err)
+	}
+}
+
+// httpWriteUploadResults responds with the result of every file as a JSON
+// array. The status code is 200 if every file was stored and 207 if only some
+// were. If none were, it is the status code of the first failure, as if that
+// file was uploaded on its own.
+func httpWriteUploadResults(w http.ResponseWriter, results []uploadResult) {
+	statusCode := http.StatusOK
+
+	failed := []uploadResult{}
+	for _, result := range results {
+		if result.Status != "ok" {
+			failed = append(failed, result)
 		}
+	}
 
-		log.Printf("| Upload   | %-21s | %-10s | %s\n",
-			getClientIP(r), filesystem.GetHumanReadableSize(bytesWritten), safeFilename)
+	switch {
+	case len(failed) == 0:
+	case len(failed) == len(results):
+		statusCode = failed[0].StatusCode
+	default:
+		statusCode = http.StatusMultiStatus
+	}
+
+	// The rest of the request body might not have been read after a
+	// failure.
+	if len(failed) > 0 {
+		w.Header().Set("Connection", "close")
+	}
+	if statusCode == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "30")
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"ok"}`))
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(results)
+}
+
+func httpWriteVersionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, filesystem.ErrVersionNotFound) {
+		httpWriteError(w, http.StatusNotFound, "The version does not exist.")
+		return
+	}
+
+	httpWriteStorageError(w, err)
+}
+
+// httpWriteStorageError responds with 503 if the data folder is unavailable,
+// so clients retry once it is back, and with 500 for every other error.
+func httpWriteStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, filesystem.ErrDataFolderUnavailable) {
+		w.Header().Set("Retry-After", "30")
+		httpWriteError(w, http.StatusServiceUnavailable, "The data folder is unavailable, please try again later.")
+		return
+	}
+
+	httpWriteError(w, http.StatusInternalServerError, "Internal Server Error")
 }
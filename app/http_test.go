@@ -0,0 +1,2451 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+type testFileInfo struct {
+	Name string `json:"Name"`
+	Size int64  `json:"Size"`
+}
+
+func newTestApp(t *testing.T, configure func(c *config.Config)) (*App, *httptest.Server) {
+	t.Helper()
+
+	c := config.New()
+	c.HttpMode = true
+	c.PathDataFolder = filepath.Join(t.TempDir(), "data")
+	if configure != nil {
+		configure(c)
+	}
+
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("config.Init() failed: %v", err)
+	}
+
+	storage, err := filesystem.New(c)
+	if err != nil {
+		t.Fatalf("filesystem.New() failed: %v", err)
+	}
+
+	a := New(c, storage)
+	server := httptest.NewServer(a.Handler())
+	t.Cleanup(server.Close)
+
+	return a, server
+}
+
+func newTestRequest(t *testing.T, method string, url string, body io.Reader) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("http.NewRequest() failed: %v", err)
+	}
+
+	return req
+}
+
+func newTestUploadRequest(t *testing.T, url string, files map[string]string) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for filename, content := range files {
+		part, err := writer.CreateFormFile("uploadfile", filename)
+		if err != nil {
+			t.Fatalf("CreateFormFile() failed: %v", err)
+		}
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := newTestRequest(t, http.MethodPost, url+httpPathUpload, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return req
+}
+
+func doTestRequest(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", req.Method, req.URL, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Reading body of %s %s failed: %v", req.Method, req.URL, err)
+	}
+
+	return res, string(body)
+}
+
+func writeTestFile(t *testing.T, a *App, filename string, content string) {
+	t.Helper()
+
+	err := os.WriteFile(filepath.Join(a.config.PathDataFolder, filename), []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile() failed: %v", err)
+	}
+}
+
+func Test_httpPostUpload(t *testing.T) {
+	a, server := newTestApp(t, nil)
+
+	res, _ := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"my file.txt": "hello"}))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\nupload\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	content, err := os.ReadFile(filepath.Join(a.config.PathDataFolder, "my_file.txt"))
+	if err != nil || string(content) != "hello" {
+		t.Errorf("\nupload\nwant: %q stored as my_file.txt\ngot:  %q (%v)", "hello", content, err)
+	}
+
+	entries, _ := os.ReadDir(a.config.GetPathUploadFolder())
+	if len(entries) != 0 {
+		t.Errorf("\nupload\nwant: empty upload folder\ngot:  %d entries", len(entries))
+	}
+
+	res, _ = doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"my file.txt": "again"}))
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("\nconflict\nwant: %d\ngot:  %d", http.StatusConflict, res.StatusCode)
+	}
+
+	content, _ = os.ReadFile(filepath.Join(a.config.PathDataFolder, "my_file.txt"))
+	if string(content) != "hello" {
+		t.Errorf("\nconflict\nwant: %q\ngot:  %q", "hello", content)
+	}
+
+	res, _ = doTestRequest(t, newTestRequest(t, http.MethodPost, server.URL+httpPathUpload, nil))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("\nno multipart body\nwant: %d\ngot:  %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func Test_httpGetFiles(t *testing.T) {
+	a, server := newTestApp(t, nil)
+	writeTestFile(t, a, "a.txt", "a")
+	writeTestFile(t, a, "b.bin", "bbb")
+
+	res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\nlisting\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	var files []testFileInfo
+	err := json.Unmarshal([]byte(body), &files)
+	if err != nil {
+		t.Fatalf("\nlisting\nwant: valid JSON\ngot:  %q (%v)", body, err)
+	}
+
+	got := map[string]int64{}
+	for _, file := range files {
+		got[file.Name] = file.Size
+	}
+
+	if len(got) != 2 || got["a.txt"] != 1 || got["b.bin"] != 3 {
+		t.Errorf("\nlisting\nwant: a.txt (1), b.bin (3)\ngot:  %v", got)
+	}
+}
+
+func Test_httpGetFilesGetFilename(t *testing.T) {
+	a, server := newTestApp(t, nil)
+	writeTestFile(t, a, "notes.txt", "hello")
+	writeTestFile(t, a, "archive.zip", "zip")
+
+	tests := []struct {
+		name            string
+		filename        string
+		wantStatus      int
+		wantType        string
+		wantDisposition string
+		wantBody        string
+	}{
+		{
+			name:            "1",
+			filename:        "notes.txt",
+			wantStatus:      http.StatusOK,
+			wantType:        "text/plain; charset=utf-8",
+			wantDisposition: `inline; filename="notes.txt"`,
+			wantBody:        "hello",
+		},
+		{
+			name:            "2",
+			filename:        "archive.zip",
+			wantStatus:      http.StatusOK,
+			wantType:        "application/zip",
+			wantDisposition: `attachment; filename="archive.zip"`,
+			wantBody:        "zip",
+		},
+		{
+			name:       "3",
+			filename:   "missing.txt",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "4",
+			filename:   ".upload",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/get/"+tt.filename, nil))
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("\nstatus\nname: %v\nwant: %d\ngot:  %d", tt.name, tt.wantStatus, res.StatusCode)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := res.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("\nContent-Type\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantType, got)
+			}
+			if got := res.Header.Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("\nContent-Disposition\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantDisposition, got)
+			}
+			if body != tt.wantBody {
+				t.Errorf("\nbody\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func Test_httpGetFilesDeleteFilename(t *testing.T) {
+	a, server := newTestApp(t, nil)
+	writeTestFile(t, a, "delete_me.txt", "bye")
+
+	res, _ := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/delete_me.txt", nil))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\ndelete\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	_, err := os.Stat(filepath.Join(a.config.PathDataFolder, "delete_me.txt"))
+	if !os.IsNotExist(err) {
+		t.Errorf("\ndelete\nwant: file removed\ngot:  %v", err)
+	}
+
+	res, _ = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/delete_me.txt", nil))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("\ndelete missing\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+}
+
+func Test_readonlyMode(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.ReadonlyMode = true
+	})
+	writeTestFile(t, a, "keep.txt", "keep")
+
+	res, _ := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"new.txt": "new"}))
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("\nupload\nwant: %d\ngot:  %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	res, _ = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/keep.txt", nil))
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("\ndelete\nwant: %d\ngot:  %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/get/keep.txt", nil))
+	if res.StatusCode != http.StatusOK || body != "keep" {
+		t.Errorf("\ndownload\nwant: %d %q\ngot:  %d %q", http.StatusOK, "keep", res.StatusCode, body)
+	}
+
+	_, body = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathConfig, nil))
+	var response struct {
+		Modes struct {
+			Readonly bool
+			Sinkhole bool
+		}
+	}
+	json.Unmarshal([]byte(body), &response)
+	if !response.Modes.Readonly || response.Modes.Sinkhole {
+		t.Errorf("\nconfig\nwant: Readonly true, Sinkhole false\ngot:  %s", body)
+	}
+}
+
+func Test_sinkholeMode(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.SinkholeMode = true
+	})
+	writeTestFile(t, a, "hidden.txt", "secret")
+
+	res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil))
+	var files []testFileInfo
+	err := json.Unmarshal([]byte(body), &files)
+	if res.StatusCode != http.StatusOK || err != nil || len(files) != 0 {
+		t.Errorf("\nlisting\nwant: %d []\ngot:  %d %q (%v)", http.StatusOK, res.StatusCode, body, err)
+	}
+
+	res, _ = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/get/hidden.txt", nil))
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("\ndownload\nwant: %d\ngot:  %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	res, _ = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/hidden.txt", nil))
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("\ndelete\nwant: %d\ngot:  %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	res, _ = doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"drop.txt": "drop"}))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("\nupload\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	_, err = os.Stat(filepath.Join(a.config.PathDataFolder, "hidden.txt"))
+	if err != nil {
+		t.Errorf("\nhidden file\nwant: still on disk\ngot:  %v", err)
+	}
+}
+
+func Test_basicAuthMiddleware(t *testing.T) {
+	_, server := newTestApp(t, func(c *config.Config) {
+		c.BasicAuthMode = true
+		c.BasicAuthPassword = "secret"
+	})
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		withAuth   bool
+		wantStatus int
+	}{
+		{
+			name:       "1",
+			withAuth:   false,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "2",
+			username:   config.DefaultBasicAuthUsername,
+			password:   "wrong",
+			withAuth:   true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "3",
+			username:   "someone",
+			password:   "secret",
+			withAuth:   true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "4",
+			username:   config.DefaultBasicAuthUsername,
+			password:   "secret",
+			withAuth:   true,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil)
+			if tt.withAuth {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+
+			res, _ := doTestRequest(t, req)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("\nstatus\nname: %v\nwant: %d\ngot:  %d", tt.name, tt.wantStatus, res.StatusCode)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("\nWWW-Authenticate\nname: %v\nwant: header set\ngot:  none", tt.name)
+			}
+		})
+	}
+}
+
+func Test_draining(t *testing.T) {
+	a, server := newTestApp(t, nil)
+	a.StartDraining()
+
+	res, _ := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"late.txt": "late"}))
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("\nupload\nwant: %d\ngot:  %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func Test_httpGetMetrics(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.BasicAuthMode = true
+		c.BasicAuthPassword = "secret"
+		c.MetricsMode = true
+	})
+
+	writeTestFile(t, a, "existing.txt", "0123456789")
+
+	req := newTestUploadRequest(t, server.URL, map[string]string{"upload.txt": "hello"})
+	req.SetBasicAuth(config.DefaultBasicAuthUsername, "secret")
+	doTestRequest(t, req)
+
+	req = newTestRequest(t, http.MethodGet, server.URL+"/files/get/existing.txt", nil)
+	req.SetBasicAuth(config.DefaultBasicAuthUsername, "secret")
+	doTestRequest(t, req)
+
+	doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil))
+
+	req = newTestRequest(t, http.MethodGet, server.URL+httpPathMetrics, nil)
+	req.SetBasicAuth(config.DefaultBasicAuthUsername, "secret")
+	res, body := doTestRequest(t, req)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\nstatus\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{
+			name: "1",
+			want: `ablage_http_requests_total{route="/upload/",method="POST",code="200"} 1`,
+		},
+		{
+			name: "2",
+			want: `ablage_http_requests_total{route="/files/get/:filename",method="GET",code="200"} 1`,
+		},
+		{
+			name: "3",
+			want: "ablage_uploaded_bytes_total 5\n",
+		},
+		{
+			name: "4",
+			want: "ablage_downloaded_bytes_total 10\n",
+		},
+		{
+			name: "5",
+			want: "ablage_auth_failures_total 1\n",
+		},
+		{
+			name: "6",
+			want: "ablage_data_folder_files 2\n",
+		},
+		{
+			name: "7",
+			want: "ablage_data_folder_bytes 15\n",
+		},
+		{
+			name: "8",
+			want: "ablage_active_uploads 0\n",
+		},
+		{
+			name: "9",
+			want: `ablage_http_request_duration_seconds_count{route="/upload/"} 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("\n/metrics\nname: %v\nwant: %v\ngot:  %s", tt.name, tt.want, body)
+			}
+		})
+	}
+}
+
+func Test_httpGetHealthz(t *testing.T) {
+	_, server := newTestApp(t, func(c *config.Config) {
+		c.BasicAuthMode = true
+		c.BasicAuthPassword = "secret"
+	})
+
+	res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathHealthz, nil))
+	if res.StatusCode != http.StatusOK || body != `{"Status":"ok"}` {
+		t.Errorf("\n/healthz\nwant: %d %s\ngot:  %d %s", http.StatusOK, `{"Status":"ok"}`, res.StatusCode, body)
+	}
+}
+
+func Test_httpGetReadyz(t *testing.T) {
+	type readiness struct {
+		Checks []struct {
+			Name   string `json:"Name"`
+			Status string `json:"Status"`
+		} `json:"Checks"`
+		Status string `json:"Status"`
+	}
+
+	tests := []struct {
+		name       string
+		configure  func(c *config.Config)
+		prepare    func(a *App)
+		wantStatus int
+		wantFailed string
+	}{
+		{
+			name:       "1",
+			configure:  func(c *config.Config) { c.BasicAuthMode = true },
+			prepare:    func(a *App) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:      "2",
+			configure: func(c *config.Config) {},
+			prepare: func(a *App) {
+				os.RemoveAll(a.config.GetPathUploadFolder())
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: "UploadFolder",
+		},
+		{
+			name:       "3",
+			configure:  func(c *config.Config) {},
+			prepare:    func(a *App) { a.StartDraining() },
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: "NotDraining",
+		},
+		{
+			name:       "4",
+			configure:  func(c *config.Config) { c.HealthMinFreeDiskSpace = 1 << 62 },
+			prepare:    func(a *App) {},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: "FreeDiskSpace",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, server := newTestApp(t, tt.configure)
+			tt.prepare(a)
+
+			res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathReadyz, nil))
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("\n/readyz\nname: %v\nwant: %d\ngot:  %d %s", tt.name, tt.wantStatus, res.StatusCode, body)
+			}
+
+			var got readiness
+			err := json.Unmarshal([]byte(body), &got)
+			if err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v", err)
+			}
+
+			for _, check := range got.Checks {
+				wantCheckStatus := "ok"
+				if check.Name == tt.wantFailed {
+					wantCheckStatus = "fail"
+				}
+
+				if check.Status != wantCheckStatus {
+					t.Errorf("\n/readyz\nname: %v\nwant: %s %s\ngot:  %s %s", tt.name, check.Name, wantCheckStatus, check.Name, check.Status)
+				}
+			}
+		})
+	}
+}
+
+func Test_degradedMode(t *testing.T) {
+	a, server := newTestApp(t, nil)
+	writeTestFile(t, a, "a.txt", "a")
+
+	pathDataFolder := a.config.PathDataFolder
+	pathUnmounted := pathDataFolder + ".unmounted"
+
+	err := os.Rename(pathDataFolder, pathUnmounted)
+	if err != nil {
+		t.Fatalf("os.Rename() failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "1",
+			req:        newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `"status":"error"`,
+		},
+		{
+			name:       "2",
+			req:        newTestRequest(t, http.MethodGet, server.URL+"/files/get/a.txt", nil),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `"status":"error"`,
+		},
+		{
+			name:       "3",
+			req:        newTestUploadRequest(t, server.URL, map[string]string{"b.txt": "b"}),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `"status":"error"`,
+		},
+		{
+			name:       "4",
+			req:        newTestRequest(t, http.MethodGet, server.URL+httpPathConfig, nil),
+			wantStatus: http.StatusOK,
+			wantBody:   `"Degraded":true`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, tt.req)
+			if res.StatusCode != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("\ndegraded\nname: %v\nwant: %d %s\ngot:  %d %s", tt.name, tt.wantStatus, tt.wantBody, res.StatusCode, body)
+			}
+		})
+	}
+
+	// A remounted share comes back without the upload folder.
+	os.RemoveAll(filepath.Join(pathUnmounted, config.DefaultNameUploadFolder))
+	err = os.Rename(pathUnmounted, pathDataFolder)
+	if err != nil {
+		t.Fatalf("os.Rename() failed: %v", err)
+	}
+
+	res, _ := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("\nrecovered\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	if a.storage.IsDegraded() {
+		t.Errorf("\nrecovered\nwant: not degraded\ngot:  degraded")
+	}
+
+	res, _ = doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"b.txt": "b"}))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("\nrecovered upload\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+}
+
+func Test_requestLoggingMiddleware(t *testing.T) {
+	logs := &bytes.Buffer{}
+	_, server := newTestApp(t, func(c *config.Config) {
+		c.Logger = slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	})
+
+	tests := []struct {
+		name          string
+		requestID     string
+		wantRequestID string
+	}{
+		{
+			name:          "1",
+			requestID:     "proxy-4711",
+			wantRequestID: "proxy-4711",
+		},
+		{
+			name:          "2",
+			requestID:     "",
+			wantRequestID: "",
+		},
+		{
+			name:          "3",
+			requestID:     "not valid",
+			wantRequestID: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestUploadRequest(t, server.URL, map[string]string{"log" + tt.name + ".txt": "log"})
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+
+			res, _ := doTestRequest(t, req)
+
+			got := res.Header.Get("X-Request-ID")
+			if tt.wantRequestID != "" && got != tt.wantRequestID {
+				t.Errorf("\nX-Request-ID\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantRequestID, got)
+			}
+			if tt.wantRequestID == "" && len(got) != 16 {
+				t.Errorf("\nX-Request-ID\nname: %v\nwant: generated ID\ngot:  %v", tt.name, got)
+			}
+
+			for _, want := range []string{
+				`"msg":"Upload","request_id":"` + got + `"`,
+				`"msg":"Request","request_id":"` + got + `","method":"POST","path":"/upload/","proto":"HTTP/1.1","status":200`,
+			} {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("\nlogs\nname: %v\nwant: %v\ngot:  %s", tt.name, want, logs)
+				}
+			}
+		})
+	}
+}
+
+func Test_quota(t *testing.T) {
+	tests := []struct {
+		name          string
+		configure     func(c *config.Config)
+		existingFile  string
+		existingOwner string
+		clientIP      string
+		content       string
+		chunked       bool
+		needsDiskInfo bool
+		want          int
+	}{
+		{
+			name:      "1",
+			configure: func(c *config.Config) { c.QuotaMaxFileSize = 10 },
+			clientIP:  "192.0.2.1",
+			content:   "0123456789",
+			want:      http.StatusOK,
+		},
+		{
+			name:      "2",
+			configure: func(c *config.Config) { c.QuotaMaxFileSize = 10 },
+			clientIP:  "192.0.2.1",
+			content:   "0123456789a",
+			want:      http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "3",
+			configure:    func(c *config.Config) { c.QuotaTotal = 30 },
+			existingFile: "0123456789012345678901234",
+			clientIP:     "192.0.2.1",
+			content:      "0123456789",
+			want:         http.StatusInsufficientStorage,
+		},
+		{
+			name:         "4",
+			configure:    func(c *config.Config) { c.QuotaTotal = 30 },
+			existingFile: "0123456789012345678901234",
+			clientIP:     "192.0.2.1",
+			content:      "0123456789",
+			chunked:      true,
+			want:         http.StatusInsufficientStorage,
+		},
+		{
+			name: "5",
+			configure: func(c *config.Config) {
+				c.QuotaPerUser = 10
+				c.TrustedProxies = []string{"127.0.0.1"}
+			},
+			existingFile:  "01234567",
+			existingOwner: "192.0.2.1",
+			clientIP:      "192.0.2.1",
+			content:       "01234",
+			chunked:       true,
+			want:          http.StatusInsufficientStorage,
+		},
+		{
+			name: "6",
+			configure: func(c *config.Config) {
+				c.QuotaPerUser = 10
+				c.TrustedProxies = []string{"127.0.0.1"}
+			},
+			existingFile:  "01234567",
+			existingOwner: "192.0.2.1",
+			clientIP:      "192.0.2.2",
+			content:       "01234",
+			chunked:       true,
+			want:          http.StatusOK,
+		},
+		{
+			name:          "7",
+			configure:     func(c *config.Config) { c.QuotaMinFreeDiskSpace = 1 << 60 },
+			clientIP:      "192.0.2.1",
+			content:       "0123456789",
+			needsDiskInfo: true,
+			want:          http.StatusInsufficientStorage,
+		},
+		{
+			name:          "8",
+			configure:     func(c *config.Config) { c.QuotaPerUser = 10 },
+			existingFile:  "01234567",
+			existingOwner: "127.0.0.1",
+			clientIP:      "192.0.2.2",
+			content:       "01234",
+			chunked:       true,
+			want:          http.StatusInsufficientStorage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, server := newTestApp(t, tt.configure)
+			if tt.needsDiskInfo {
+				if _, err := filesystem.GetFreeDiskSpace(a.config.PathDataFolder); err != nil {
+					t.Skipf("free disk space is unknown: %v", err)
+				}
+			}
+
+			if tt.existingFile != "" {
+				writeTestFile(t, a, "existing.txt", tt.existingFile)
+				err := a.storage.SaveMetadata("existing.txt", filesystem.Metadata{Uploader: tt.existingOwner})
+				if err != nil {
+					t.Fatalf("SaveMetadata() failed: %v", err)
+				}
+			}
+
+			req := newTestUploadRequest(t, server.URL, map[string]string{"upload.txt": tt.content})
+			req.Header.Set("X-Forwarded-For", tt.clientIP)
+			if tt.chunked {
+				req.Body = io.NopCloser(io.MultiReader(req.Body))
+				req.ContentLength = -1
+			}
+
+			res, body := doTestRequest(t, req)
+			if res.StatusCode != tt.want {
+				t.Errorf("\nupload\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, tt.want, res.StatusCode, body)
+			}
+
+			_, err := os.Stat(filepath.Join(a.config.PathDataFolder, "upload.txt"))
+			if (err == nil) != (tt.want == http.StatusOK) {
+				t.Errorf("\nupload\nname: %v\nwant: stored %v\ngot:  %v", tt.name, tt.want == http.StatusOK, err)
+			}
+
+			entries, _ := os.ReadDir(a.config.GetPathUploadFolder())
+			if len(entries) != 0 {
+				t.Errorf("\nupload\nname: %v\nwant: empty upload folder\ngot:  %d entries", tt.name, len(entries))
+			}
+
+			if a.quota.reservedBytes != 0 {
+				t.Errorf("\nreservedBytes\nname: %v\nwant: 0\ngot:  %v", tt.name, a.quota.reservedBytes)
+			}
+		})
+	}
+}
+
+func Test_App_getClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   []string
+		want           string
+	}{
+		{
+			name:       "1",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:         "2",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.1"},
+			want:         "192.0.2.1",
+		},
+		{
+			name:           "3",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.2:1234",
+			forwardedFor:   []string{"198.51.100.1, 192.0.2.1"},
+			want:           "192.0.2.1",
+		},
+		{
+			name:           "4",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.2:1234",
+			forwardedFor:   []string{"198.51.100.1", "192.0.2.1, 10.0.0.3"},
+			want:           "192.0.2.1",
+		},
+		{
+			name:           "5",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "[::ffff:10.0.0.2]:1234",
+			forwardedFor:   []string{"2001:db8::1"},
+			want:           "2001:db8::1",
+		},
+		{
+			name:           "6",
+			trustedProxies: []string{"unix"},
+			remoteAddr:     "@",
+			forwardedFor:   []string{"192.0.2.1"},
+			want:           "192.0.2.1",
+		},
+		{
+			name:           "7",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "@",
+			forwardedFor:   []string{"192.0.2.1"},
+			want:           "@",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestApp(t, func(c *config.Config) {
+				c.TrustedProxies = tt.trustedProxies
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, forwardedFor := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+
+			if got := a.getClientIP(req); got != tt.want {
+				t.Errorf("\ngetClientIP()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_httpGetUsage(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.QuotaMaxFileSize = 50
+		c.QuotaPerUser = 40
+		c.QuotaTotal = 100
+		c.TrustedProxies = []string{"127.0.0.1"}
+	})
+
+	writeTestFile(t, a, "mine.txt", "0123456789")
+	writeTestFile(t, a, "other.txt", "0123456789012345")
+	a.storage.SaveMetadata("mine.txt", filesystem.Metadata{Uploader: "192.0.2.1"})
+	a.storage.SaveMetadata("other.txt", filesystem.Metadata{Uploader: "192.0.2.2"})
+
+	req := newTestRequest(t, http.MethodGet, server.URL+httpPathUsage, nil)
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	_, body := doTestRequest(t, req)
+
+	var got struct {
+		MaxFileSize  int64
+		Quota        int64
+		QuotaPerUser int64
+		Remaining    int64
+		Used         int64
+		UsedByUser   int64
+	}
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+	}
+
+	if got.MaxFileSize != 50 || got.Quota != 100 || got.QuotaPerUser != 40 {
+		t.Errorf("\nlimits\nwant: 50 100 40\ngot:  %v %v %v", got.MaxFileSize, got.Quota, got.QuotaPerUser)
+	}
+
+	if got.Used != 26 || got.UsedByUser != 10 {
+		t.Errorf("\nused\nwant: 26 10\ngot:  %v %v", got.Used, got.UsedByUser)
+	}
+
+	if got.Remaining != 30 {
+		t.Errorf("\nremaining\nwant: 30\ngot:  %v", got.Remaining)
+	}
+}
+
+func Test_deleteExpiredFiles(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		fileTTL     time.Duration
+		modTime     time.Time
+		metadata    *filesystem.Metadata
+		wantDeleted bool
+	}{
+		{
+			name:        "1",
+			fileTTL:     0,
+			modTime:     now.Add(-365 * 24 * time.Hour),
+			metadata:    nil,
+			wantDeleted: false,
+		},
+		{
+			name:        "2",
+			fileTTL:     time.Hour,
+			modTime:     now.Add(-2 * time.Hour),
+			metadata:    nil,
+			wantDeleted: true,
+		},
+		{
+			name:        "3",
+			fileTTL:     time.Hour,
+			modTime:     now.Add(-30 * time.Minute),
+			metadata:    nil,
+			wantDeleted: false,
+		},
+		{
+			name:        "4",
+			fileTTL:     time.Hour,
+			modTime:     now.Add(-2 * time.Hour),
+			metadata:    &filesystem.Metadata{UploadedAt: now.Add(-30 * time.Minute)},
+			wantDeleted: false,
+		},
+		{
+			name:        "5",
+			fileTTL:     0,
+			modTime:     now,
+			metadata:    &filesystem.Metadata{ExpiresAt: now.Add(-time.Second), UploadedAt: now.Add(-time.Minute)},
+			wantDeleted: true,
+		},
+		{
+			name:        "6",
+			fileTTL:     24 * time.Hour,
+			modTime:     now,
+			metadata:    &filesystem.Metadata{ExpiresAt: now.Add(time.Minute), UploadedAt: now},
+			wantDeleted: false,
+		},
+		{
+			name:        "7",
+			fileTTL:     time.Hour,
+			modTime:     now.Add(-2 * time.Hour),
+			metadata:    &filesystem.Metadata{RestoredAt: now.Add(-30 * time.Minute), UploadedAt: now.Add(-2 * time.Hour)},
+			wantDeleted: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestApp(t, func(c *config.Config) { c.FileTTL = tt.fileTTL })
+
+			writeTestFile(t, a, "file.txt", "content")
+			err := os.Chtimes(filepath.Join(a.config.PathDataFolder, "file.txt"), tt.modTime, tt.modTime)
+			if err != nil {
+				t.Fatalf("os.Chtimes() failed: %v", err)
+			}
+
+			if tt.metadata != nil {
+				err = a.storage.SaveMetadata("file.txt", *tt.metadata)
+				if err != nil {
+					t.Fatalf("SaveMetadata() failed: %v", err)
+				}
+			}
+
+			a.deleteExpiredFiles(now)
+
+			_, err = os.Stat(filepath.Join(a.config.PathDataFolder, "file.txt"))
+			if gotDeleted := os.IsNotExist(err); gotDeleted != tt.wantDeleted {
+				t.Errorf("\ndeleteExpiredFiles()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantDeleted, gotDeleted)
+			}
+
+			_, err = os.Stat(filepath.Join(a.config.GetPathMetadataFolder(), "file.txt.json"))
+			if tt.wantDeleted && !os.IsNotExist(err) {
+				t.Errorf("\ndeleteExpiredFiles()\nname: %v\nwant: metadata deleted\ngot:  %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func Test_httpPostUpload_ttl(t *testing.T) {
+	tests := []struct {
+		name          string
+		ttl           string
+		want          int
+		wantExpiresIn int64
+	}{
+		{
+			name:          "1",
+			ttl:           "",
+			want:          http.StatusOK,
+			wantExpiresIn: 0,
+		},
+		{
+			name:          "2",
+			ttl:           "1h",
+			want:          http.StatusOK,
+			wantExpiresIn: 3600,
+		},
+		{
+			name:          "3",
+			ttl:           "1d",
+			want:          http.StatusBadRequest,
+			wantExpiresIn: 0,
+		},
+		{
+			name:          "4",
+			ttl:           "-1h",
+			want:          http.StatusBadRequest,
+			wantExpiresIn: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, server := newTestApp(t, nil)
+
+			req := newTestUploadRequest(t, server.URL, map[string]string{"ttl.txt": "ttl"})
+			req.URL.RawQuery = url.Values{"ttl": {tt.ttl}}.Encode()
+
+			res, body := doTestRequest(t, req)
+			if res.StatusCode != tt.want {
+				t.Fatalf("\nupload\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, tt.want, res.StatusCode, body)
+			}
+
+			_, body = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathFiles, nil))
+
+			var files []struct {
+				ExpiresIn int64
+				Name      string
+			}
+			if err := json.Unmarshal([]byte(body), &files); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+			}
+
+			var got int64
+			for _, file := range files {
+				got = file.ExpiresIn
+			}
+
+			// Allow for a slow test run.
+			if got > tt.wantExpiresIn || got < tt.wantExpiresIn-5 {
+				t.Errorf("\nExpiresIn\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantExpiresIn, got)
+			}
+		})
+	}
+}
+
+func Test_trash(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) { c.TrashMode = true })
+
+	getTrash := func() []struct {
+		ID   string
+		Name string
+		Size int64
+	} {
+		t.Helper()
+
+		_, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+httpPathTrash, nil))
+
+		var trash []struct {
+			ID   string
+			Name string
+			Size int64
+		}
+		if err := json.Unmarshal([]byte(body), &trash); err != nil {
+			t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+		}
+
+		return trash
+	}
+
+	// The file expires while it is in the trash.
+	uploadedAt := time.Now().Add(-2 * time.Hour)
+	writeTestFile(t, a, "file.txt", "first")
+	a.storage.SaveMetadata("file.txt", filesystem.Metadata{ExpiresAt: uploadedAt.Add(time.Hour), UploadedAt: uploadedAt, Uploader: "alice"})
+	doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/file.txt", nil))
+
+	trash := getTrash()
+	if len(trash) != 1 || trash[0].Name != "file.txt" || trash[0].Size != 5 {
+		t.Fatalf("\ndelete\nwant: file.txt in trash\ngot:  %v", trash)
+	}
+
+	if _, err := os.Stat(filepath.Join(a.config.PathDataFolder, "file.txt")); !os.IsNotExist(err) {
+		t.Errorf("\ndelete\nwant: file.txt removed from data folder\ngot:  %v", err)
+	}
+
+	writeTestFile(t, a, "file.txt", "second")
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{
+			name: "1",
+			path: "/trash/restore/" + trash[0].ID,
+			want: http.StatusConflict,
+		},
+		{
+			name: "2",
+			path: "/trash/restore/unknown",
+			want: http.StatusNotFound,
+		},
+		{
+			name: "3",
+			path: "/trash/restore/.trash.json",
+			want: http.StatusNotFound,
+		},
+		{
+			name: "4",
+			path: "/files/delete/file.txt",
+			want: http.StatusOK,
+		},
+		{
+			name: "5",
+			path: "/trash/restore/" + trash[0].ID,
+			want: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+tt.path, nil))
+			if res.StatusCode != tt.want {
+				t.Errorf("\n%s\nname: %v\nwant: %v\ngot:  %v (%s)", tt.path, tt.name, tt.want, res.StatusCode, body)
+			}
+		})
+	}
+
+	content, _ := os.ReadFile(filepath.Join(a.config.PathDataFolder, "file.txt"))
+	if string(content) != "first" {
+		t.Errorf("\nrestore\nwant: first\ngot:  %s", content)
+	}
+
+	metadata, _ := a.storage.GetMetadata("file.txt")
+	if metadata.Uploader != "alice" || !metadata.UploadedAt.Equal(uploadedAt) {
+		t.Errorf("\nrestore\nwant: metadata restored\ngot:  %v", metadata)
+	}
+	if lifetime := metadata.ExpiresAt.Sub(metadata.RestoredAt); lifetime != time.Hour {
+		t.Errorf("\nrestore\nwant: lifetime of %v restarted\ngot:  %v", time.Hour, lifetime)
+	}
+
+	a.deleteExpiredFiles(time.Now())
+	if _, err := os.Stat(filepath.Join(a.config.PathDataFolder, "file.txt")); err != nil {
+		t.Errorf("\ndeleteExpiredFiles()\nwant: restored file kept\ngot:  %v", err)
+	}
+
+	trash = getTrash()
+	if len(trash) != 1 {
+		t.Fatalf("\ntrash\nwant: second file.txt in trash\ngot:  %v", trash)
+	}
+
+	a.purgeExpiredTrash(time.Now())
+	if len(getTrash()) != 1 {
+		t.Errorf("\npurgeExpiredTrash()\nwant: recently deleted file kept\ngot:  purged")
+	}
+
+	a.purgeExpiredTrash(time.Now().Add(a.config.TrashRetention + time.Minute))
+	if trash := getTrash(); len(trash) != 0 {
+		t.Errorf("\npurgeExpiredTrash()\nwant: empty trash\ngot:  %v", trash)
+	}
+
+	entries, _ := os.ReadDir(a.config.GetPathTrashFolder())
+	if len(entries) != 0 {
+		t.Errorf("\npurgeExpiredTrash()\nwant: empty trash folder\ngot:  %d entries", len(entries))
+	}
+}
+
+func Test_versions(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.VersioningMode = true
+		c.VersionsToKeep = 2
+	})
+
+	getVersions := func() []struct {
+		ID   string
+		Size int64
+	} {
+		t.Helper()
+
+		_, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/versions/file.txt", nil))
+
+		var versions []struct {
+			ID   string
+			Size int64
+		}
+		if err := json.Unmarshal([]byte(body), &versions); err != nil {
+			t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+		}
+
+		return versions
+	}
+
+	for _, content := range []string{"1", "22", "333", "4444"} {
+		res, body := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"file.txt": content}))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("\nupload\nwant: %v\ngot:  %v (%s)", http.StatusOK, res.StatusCode, body)
+		}
+	}
+
+	versions := getVersions()
+	if len(versions) != 2 || versions[0].Size != 3 || versions[1].Size != 2 {
+		t.Fatalf("\nupload\nwant: two newest versions\ngot:  %v", versions)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		want     int
+		wantBody string
+	}{
+		{
+			name:     "1",
+			path:     "/versions/file.txt/get/" + versions[0].ID,
+			want:     http.StatusOK,
+			wantBody: "333",
+		},
+		{
+			name: "2",
+			path: "/versions/file.txt/get/unknown",
+			want: http.StatusNotFound,
+		},
+		{
+			name: "3",
+			path: "/versions/other.txt/restore/" + versions[0].ID,
+			want: http.StatusNotFound,
+		},
+		{
+			name: "4",
+			path: "/versions/file.txt/restore/" + versions[1].ID,
+			want: http.StatusOK,
+		},
+		{
+			name:     "5",
+			path:     "/files/get/file.txt",
+			want:     http.StatusOK,
+			wantBody: "22",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+tt.path, nil))
+			if res.StatusCode != tt.want {
+				t.Errorf("\n%s\nname: %v\nwant: %v\ngot:  %v (%s)", tt.path, tt.name, tt.want, res.StatusCode, body)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("\n%s\nname: %v\nwant: %v\ngot:  %v", tt.path, tt.name, tt.wantBody, body)
+			}
+		})
+	}
+
+	versions = getVersions()
+	if len(versions) != 2 || versions[0].Size != 4 || versions[1].Size != 3 {
+		t.Errorf("\nrestore\nwant: replaced file kept as newest version\ngot:  %v", versions)
+	}
+
+	metadata, _ := a.storage.GetMetadata("file.txt")
+	if metadata.RestoredAt.IsZero() || metadata.RestoredAt.Before(metadata.UploadedAt) {
+		t.Errorf("\nrestore\nwant: lifetime restarted\ngot:  %+v", metadata)
+	}
+
+	a.storage.DeleteFile("file.txt")
+	if _, err := os.Stat(filepath.Join(a.config.GetPathVersionsFolder(), "file.txt")); !os.IsNotExist(err) {
+		t.Errorf("\nDeleteFile()\nwant: versions deleted\ngot:  %v", err)
+	}
+}
+
+func Test_httpPostUpload_collision(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         string
+		query          string
+		wantStatus     int
+		wantStoredName string
+		wantFiles      map[string]string
+	}{
+		{
+			name:       "1",
+			policy:     config.CollisionPolicyReject,
+			wantStatus: http.StatusConflict,
+			wantFiles:  map[string]string{"file.txt": "old"},
+		},
+		{
+			name:           "2",
+			policy:         config.CollisionPolicyOverwrite,
+			wantStatus:     http.StatusOK,
+			wantStoredName: "file.txt",
+			wantFiles:      map[string]string{"file.txt": "new"},
+		},
+		{
+			name:           "3",
+			policy:         config.CollisionPolicyAutoRename,
+			wantStatus:     http.StatusOK,
+			wantStoredName: "file (2).txt",
+			wantFiles:      map[string]string{"file.txt": "old", "file (1).txt": "older", "file (2).txt": "new"},
+		},
+		{
+			name:           "4",
+			policy:         config.CollisionPolicyKeepBoth,
+			wantStatus:     http.StatusOK,
+			wantStoredName: "file.txt",
+			wantFiles:      map[string]string{"file.txt": "new", "file (1).txt": "older", "file (2).txt": "old"},
+		},
+		{
+			name:           "5",
+			policy:         config.CollisionPolicyReject,
+			query:          "?collision=auto-rename",
+			wantStatus:     http.StatusOK,
+			wantStoredName: "file (2).txt",
+			wantFiles:      map[string]string{"file.txt": "old", "file (2).txt": "new"},
+		},
+		{
+			name:       "6",
+			policy:     config.CollisionPolicyReject,
+			query:      "?collision=ignore",
+			wantStatus: http.StatusBadRequest,
+			wantFiles:  map[string]string{"file.txt": "old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, server := newTestApp(t, func(c *config.Config) { c.CollisionPolicy = tt.policy })
+			writeTestFile(t, a, "file.txt", "old")
+			writeTestFile(t, a, "file (1).txt", "older")
+
+			req := newTestUploadRequest(t, server.URL, map[string]string{"file.txt": "new"})
+			req.URL.RawQuery = strings.TrimPrefix(tt.query, "?")
+
+			res, body := doTestRequest(t, req)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("\nhttpPostUpload()\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, tt.wantStatus, res.StatusCode, body)
+			}
+
+			if tt.wantStoredName != "" {
+				var results []struct {
+					Name       string
+					StoredName string
+				}
+				if err := json.Unmarshal([]byte(body), &results); err != nil {
+					t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+				}
+
+				if len(results) != 1 || results[0].Name != "file.txt" || results[0].StoredName != tt.wantStoredName {
+					t.Errorf("\nhttpPostUpload()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantStoredName, results)
+				}
+			}
+
+			for filename, want := range tt.wantFiles {
+				content, err := os.ReadFile(filepath.Join(a.config.PathDataFolder, filename))
+				if err != nil || string(content) != want {
+					t.Errorf("\nhttpPostUpload()\nname: %v\nwant: %s = %q\ngot:  %q (%v)", tt.name, filename, want, content, err)
+				}
+			}
+		})
+	}
+}
+
+func Test_httpPostUpload_collision_versions(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) { c.VersioningMode = true })
+
+	for _, upload := range []struct{ content, query string }{
+		{content: "v1"},
+		{content: "v2", query: "collision=overwrite"},
+		{content: "v3", query: "collision=keep-both"},
+	} {
+		req := newTestUploadRequest(t, server.URL, map[string]string{"file.txt": upload.content})
+		req.URL.RawQuery = upload.query
+
+		res, body := doTestRequest(t, req)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("\nhttpPostUpload()\nwant: %v\ngot:  %v (%s)", http.StatusOK, res.StatusCode, body)
+		}
+	}
+
+	// The versions belong to the file that was renamed by keep-both, not to
+	// the new upload.
+	tests := []struct {
+		name         string
+		filename     string
+		wantVersions int
+	}{
+		{
+			name:         "1",
+			filename:     "file.txt",
+			wantVersions: 0,
+		},
+		{
+			name:         "2",
+			filename:     "file (1).txt",
+			wantVersions: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versions, err := a.storage.GetVersions(tt.filename)
+			if err != nil {
+				t.Fatalf("GetVersions() failed: %v", err)
+			}
+
+			if len(versions) != tt.wantVersions {
+				t.Fatalf("\nGetVersions()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantVersions, len(versions))
+			}
+
+			for _, version := range versions {
+				if version.Name != tt.filename {
+					t.Errorf("\nGetVersions()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.filename, version.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_httpPostUpload_concurrent(t *testing.T) {
+	const uploads int = 4
+
+	a, server := newTestApp(t, func(c *config.Config) { c.CollisionPolicy = config.CollisionPolicyAutoRename })
+
+	type response []struct {
+		StatusCode int
+		StoredName string
+	}
+
+	// Every upload of the same name is held back until all of them were
+	// received partially, so they are staged at the same time.
+	release := make(chan struct{})
+	responses := make(chan response, uploads)
+	contents := map[string]bool{}
+	for i := range uploads {
+		content := strings.Repeat(fmt.Sprintf("upload %d ", i), 8192)
+		contents[content] = true
+
+		bodyReader, bodyWriter := io.Pipe()
+		writer := multipart.NewWriter(bodyWriter)
+
+		req := newTestRequest(t, http.MethodPost, server.URL+httpPathUpload, bodyReader)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		go func() {
+			part, _ := writer.CreateFormFile("uploadfile", "file.txt")
+			part.Write([]byte(content[:1024]))
+			<-release
+			part.Write([]byte(content[1024:]))
+			writer.Close()
+			bodyWriter.Close()
+		}()
+
+		go func() {
+			var got response
+			res, err := http.DefaultClient.Do(req)
+			if err == nil {
+				json.NewDecoder(res.Body).Decode(&got)
+				res.Body.Close()
+			}
+			responses <- got
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ := os.ReadDir(a.config.GetPathUploadFolder())
+		if len(entries) == uploads {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+
+	storedNames := []string{}
+	for range uploads {
+		got := <-responses
+		if len(got) != 1 || got[0].StatusCode != http.StatusOK {
+			t.Fatalf("\nhttpPostUpload()\nwant: %v\ngot:  %+v", http.StatusOK, got)
+		}
+		storedNames = append(storedNames, got[0].StoredName)
+	}
+
+	for _, storedName := range storedNames {
+		content, err := os.ReadFile(filepath.Join(a.config.PathDataFolder, storedName))
+		if err != nil || !contents[string(content)] {
+			t.Errorf("\nhttpPostUpload()\nwant: content of one upload in %v\ngot:  %d bytes, %v", storedName, len(content), err)
+		}
+		delete(contents, string(content))
+	}
+}
+
+func Test_httpPostUpload_partial(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) { c.QuotaMaxFileSize = 5 })
+	writeTestFile(t, a, "b.txt", "old")
+
+	files := map[string]string{
+		"a.txt": "hello",
+		"b.txt": "new",
+		"c.txt": "too large",
+		"d.txt": "",
+	}
+
+	res, body := doTestRequest(t, newTestUploadRequest(t, server.URL, files))
+	if res.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("\nhttpPostUpload()\nwant: %v\ngot:  %v (%s)", http.StatusMultiStatus, res.StatusCode, body)
+	}
+
+	var response []uploadResult
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+	}
+
+	if len(response) != len(files) {
+		t.Fatalf("\nhttpPostUpload()\nwant: %d results\ngot:  %s", len(files), body)
+	}
+
+	results := map[string]uploadResult{}
+	for _, result := range response {
+		results[result.Name] = result
+	}
+
+	tests := []struct {
+		name           string
+		filename       string
+		wantStatusCode int
+		wantSHA256     string
+	}{
+		{
+			name:           "1",
+			filename:       "a.txt",
+			wantStatusCode: http.StatusOK,
+			wantSHA256:     "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:           "2",
+			filename:       "b.txt",
+			wantStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "3",
+			filename:       "c.txt",
+			wantStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "4",
+			filename:       "d.txt",
+			wantStatusCode: http.StatusOK,
+			wantSHA256:     "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := results[tt.filename]
+			if result.StatusCode != tt.wantStatusCode || result.SHA256 != tt.wantSHA256 {
+				t.Errorf("\nhttpPostUpload()\nname: %v\nwant: %v %v\ngot:  %+v", tt.name, tt.wantStatusCode, tt.wantSHA256, result)
+			}
+
+			content, err := os.ReadFile(filepath.Join(a.config.PathDataFolder, tt.filename))
+			if tt.wantStatusCode == http.StatusOK && (err != nil || string(content) != files[tt.filename]) {
+				t.Errorf("\nhttpPostUpload()\nname: %v\nwant: %q stored\ngot:  %q (%v)", tt.name, files[tt.filename], content, err)
+			}
+		})
+	}
+
+	content, _ := os.ReadFile(filepath.Join(a.config.PathDataFolder, "b.txt"))
+	if string(content) != "old" {
+		t.Errorf("\nhttpPostUpload()\nwant: b.txt unchanged\ngot:  %q", content)
+	}
+}
+
+func Test_parseDigestHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "1",
+			value: "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:",
+			want:  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:  "2",
+			value: "sha-512=:YWJj:, sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:",
+			want:  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:  "3",
+			value: "sha-512=:YWJj:",
+			want:  "",
+		},
+		{
+			name:    "4",
+			value:   "sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=",
+			wantErr: true,
+		},
+		{
+			name:    "5",
+			value:   "sha-256=:YWJj:",
+			wantErr: true,
+		},
+		{
+			name:    "6",
+			value:   "sha-256",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDigestHeader(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("\nparseDigestHeader()\nname: %v\nwant: %v (error %v)\ngot:  %v (%v)", tt.name, tt.want, tt.wantErr, got, err)
+			}
+		})
+	}
+}
+
+func Test_httpPostUpload_sha256(t *testing.T) {
+	const sumOfHello string = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	const blake3SumOfHello string = "ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f"
+
+	tests := []struct {
+		name       string
+		blake3Mode bool
+		query      string
+		partDigest string
+		want       int
+	}{
+		{
+			name:  "1",
+			query: "sha256=" + sumOfHello,
+			want:  http.StatusOK,
+		},
+		{
+			name:  "2",
+			query: "sha256=" + strings.ToUpper(sumOfHello),
+			want:  http.StatusOK,
+		},
+		{
+			name:  "3",
+			query: "sha256=e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			want:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:  "4",
+			query: "sha256=hello",
+			want:  http.StatusBadRequest,
+		},
+		{
+			name:       "5",
+			partDigest: "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "6",
+			partDigest: "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:",
+			want:       http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "7",
+			query:      "sha256=e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			partDigest: "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:",
+			want:       http.StatusOK,
+		},
+		{
+			name:       "8",
+			blake3Mode: true,
+			want:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, server := newTestApp(t, func(c *config.Config) { c.BLAKE3Mode = tt.blake3Mode })
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="uploadfile"; filename="hello.txt"`)
+			if tt.partDigest != "" {
+				header.Set("Repr-Digest", tt.partDigest)
+			}
+			part, err := writer.CreatePart(header)
+			if err != nil {
+				t.Fatalf("CreatePart() failed: %v", err)
+			}
+			part.Write([]byte("hello"))
+			writer.Close()
+
+			req := newTestRequest(t, http.MethodPost, server.URL+httpPathUpload+"?"+tt.query, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			res, resBody := doTestRequest(t, req)
+			if res.StatusCode != tt.want {
+				t.Fatalf("\nhttpPostUpload()\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, tt.want, res.StatusCode, resBody)
+			}
+
+			_, err = os.Stat(filepath.Join(a.config.PathDataFolder, "hello.txt"))
+			if (err == nil) != (tt.want == http.StatusOK) {
+				t.Fatalf("\nhttpPostUpload()\nname: %v\nwant: stored %v\ngot:  %v", tt.name, tt.want == http.StatusOK, err)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			res, _ = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/get/hello.txt", nil))
+			if got := res.Header.Get("Repr-Digest"); got != "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:" {
+				t.Errorf("\nRepr-Digest\nname: %v\nwant: %v\ngot:  %v", tt.name, "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:", got)
+			}
+
+			metadata, _ := a.storage.GetMetadata("hello.txt")
+			if metadata.SHA256 != sumOfHello {
+				t.Errorf("\nmetadata\nname: %v\nwant: %v\ngot:  %v", tt.name, sumOfHello, metadata.SHA256)
+			}
+
+			wantBLAKE3 := ""
+			if tt.blake3Mode {
+				wantBLAKE3 = blake3SumOfHello
+			}
+			if metadata.BLAKE3 != wantBLAKE3 {
+				t.Errorf("\nmetadata\nname: %v\nwant: %v\ngot:  %v", tt.name, wantBLAKE3, metadata.BLAKE3)
+			}
+		})
+	}
+}
+
+func Test_httpPostUpload_sha256_multipleFiles(t *testing.T) {
+	const sumOfHello string = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	_, server := newTestApp(t, nil)
+
+	req := newTestUploadRequest(t, server.URL, map[string]string{"a.txt": "hello", "b.txt": "hello"})
+	req.URL.RawQuery = "sha256=" + sumOfHello
+
+	res, body := doTestRequest(t, req)
+	if res.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("\nhttpPostUpload()\nwant: %v\ngot:  %v (%s)", http.StatusMultiStatus, res.StatusCode, body)
+	}
+
+	var results []struct {
+		StatusCode int
+	}
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+	}
+
+	// The expected hash only applies to the first file.
+	want := []int{http.StatusOK, http.StatusBadRequest}
+	if len(results) != len(want) || results[0].StatusCode != want[0] || results[1].StatusCode != want[1] {
+		t.Errorf("\nhttpPostUpload()\nwant: %v\ngot:  %v", want, results)
+	}
+}
+
+func Test_dedup(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.CollisionPolicy = config.CollisionPolicyOverwrite
+		c.DedupMode = true
+	})
+
+	const sumOfHello string = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	pathObject := filepath.Join(a.config.GetPathObjectsFolder(), sumOfHello[:2], sumOfHello)
+
+	tests := []struct {
+		name             string
+		upload           string
+		content          string
+		delete           string
+		wantDeduplicated int64
+		wantObject       bool
+	}{
+		{
+			name:             "1",
+			upload:           "a.txt",
+			wantDeduplicated: 0,
+			wantObject:       true,
+		},
+		{
+			name:             "2",
+			upload:           "b.txt",
+			wantDeduplicated: 5,
+			wantObject:       true,
+		},
+		{
+			name:             "3",
+			upload:           "c.txt",
+			wantDeduplicated: 10,
+			wantObject:       true,
+		},
+		{
+			name:             "4",
+			delete:           "a.txt",
+			wantDeduplicated: 5,
+			wantObject:       true,
+		},
+		{
+			name:             "5",
+			delete:           "b.txt",
+			wantDeduplicated: 0,
+			wantObject:       true,
+		},
+		{
+			name:             "6",
+			delete:           "c.txt",
+			wantDeduplicated: 0,
+			wantObject:       false,
+		},
+		{
+			name:             "7",
+			upload:           "a.txt",
+			wantDeduplicated: 0,
+			wantObject:       true,
+		},
+		{
+			name:             "8",
+			upload:           "a.txt",
+			wantDeduplicated: 0,
+			wantObject:       true,
+		},
+		{
+			name:             "9",
+			upload:           "a.txt",
+			content:          "world",
+			wantDeduplicated: 0,
+			wantObject:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.upload != "" {
+				content := tt.content
+				if content == "" {
+					content = "hello"
+				}
+
+				res, body := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{tt.upload: content}))
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("\nhttpPostUpload()\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, http.StatusOK, res.StatusCode, body)
+				}
+			}
+
+			if tt.delete != "" {
+				res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/"+tt.delete, nil))
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("\nhttpGetFilesDeleteFilename()\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, http.StatusOK, res.StatusCode, body)
+				}
+			}
+
+			_, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/usage/", nil))
+
+			var usage struct {
+				Deduplicated int64
+			}
+			if err := json.Unmarshal([]byte(body), &usage); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+			}
+
+			if usage.Deduplicated != tt.wantDeduplicated {
+				t.Errorf("\nhttpGetUsage()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantDeduplicated, usage.Deduplicated)
+			}
+
+			_, err := os.Stat(pathObject)
+			if (err == nil) != tt.wantObject {
+				t.Errorf("\nobject\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantObject, err)
+			}
+
+			// Overwriting a file with the same content must not leave the
+			// received file behind.
+			entries, err := os.ReadDir(a.config.GetPathUploadFolder())
+			if err != nil || len(entries) != 0 {
+				t.Errorf("\nupload folder\nname: %v\nwant: empty\ngot:  %v (%v)", tt.name, entries, err)
+			}
+		})
+	}
+}
+
+func Test_httpGetFiles_dedup(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) { c.DedupMode = true })
+
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		res, body := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{filename: "hello"}))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("\nhttpPostUpload()\nwant: %v\ngot:  %v (%s)", http.StatusOK, res.StatusCode, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Both files are the same file on disk, so they share any modification
+	// time.
+	modifiedAt := time.Now().AddDate(0, 0, -30)
+	err := os.Chtimes(filepath.Join(a.config.PathDataFolder, "a.txt"), modifiedAt, modifiedAt)
+	if err != nil {
+		t.Fatalf("os.Chtimes() failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "1",
+			query: "?sort=mtime&order=desc",
+			want:  []string{"b.txt", "a.txt"},
+		},
+		{
+			name:  "2",
+			query: "?since=" + url.QueryEscape(time.Now().AddDate(0, 0, -1).Format(time.RFC3339)),
+			want:  []string{"a.txt", "b.txt"},
+		},
+		{
+			name:  "3",
+			query: "?until=" + url.QueryEscape(time.Now().AddDate(0, 0, -1).Format(time.RFC3339)),
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/"+tt.query, nil))
+
+			var files []struct {
+				ModifiedAt time.Time
+				Name       string
+				UploadedAt time.Time
+			}
+			if err := json.Unmarshal([]byte(body), &files); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+			}
+
+			got := []string{}
+			for _, file := range files {
+				got = append(got, file.Name)
+
+				if file.ModifiedAt.Before(file.UploadedAt) {
+					t.Errorf("\nhttpGetFiles()\nname: %v\nwant: ModifiedAt of %v not before %v\ngot:  %v", tt.name, file.Name, file.UploadedAt, file.ModifiedAt)
+				}
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("\nhttpGetFiles()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_httpPostFilesMetadataFilename(t *testing.T) {
+	a, server := newTestApp(t, nil)
+
+	// Without --trusted-proxy, the client can't choose its recorded address.
+	req := newTestUploadRequest(t, server.URL, map[string]string{"notes.txt": "hello"})
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	res, body := doTestRequest(t, req)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\nhttpPostUpload()\nwant: %v\ngot:  %v (%s)", http.StatusOK, res.StatusCode, body)
+	}
+
+	tests := []struct {
+		name            string
+		filename        string
+		body            string
+		want            int
+		wantDescription string
+		wantTags        []string
+	}{
+		{
+			name:            "1",
+			filename:        "notes.txt",
+			body:            `{"Description":" Meeting notes ","Tags":["work"," 2026 ","","work"]}`,
+			want:            http.StatusOK,
+			wantDescription: "Meeting notes",
+			wantTags:        []string{"2026", "work"},
+		},
+		{
+			name:            "2",
+			filename:        "notes.txt",
+			body:            `{"Description":"","Tags":[]}`,
+			want:            http.StatusOK,
+			wantDescription: "",
+			wantTags:        nil,
+		},
+		{
+			name:     "3",
+			filename: "missing.txt",
+			body:     `{"Description":"x","Tags":[]}`,
+			want:     http.StatusNotFound,
+		},
+		{
+			name:     "4",
+			filename: "notes.txt",
+			body:     `not json`,
+			want:     http.StatusBadRequest,
+		},
+		{
+			name:     "5",
+			filename: "notes.txt",
+			body:     `{"Description":"` + strings.Repeat("x", 1025) + `","Tags":[]}`,
+			want:     http.StatusBadRequest,
+		},
+		{
+			name:     "6",
+			filename: "notes.txt",
+			body:     `{"Description":"","Tags":["a,b"]}`,
+			want:     http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(t, http.MethodPost, server.URL+"/files/metadata/"+tt.filename, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			res, body := doTestRequest(t, req)
+			if res.StatusCode != tt.want {
+				t.Fatalf("\nhttpPostFilesMetadataFilename()\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, tt.want, res.StatusCode, body)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			_, body = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/", nil))
+
+			var files []struct {
+				ClientIP    string
+				Description string
+				MIMEType    string
+				Name        string
+				Tags        []string
+				Uploader    string
+			}
+			if err := json.Unmarshal([]byte(body), &files); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+			}
+
+			if len(files) != 1 {
+				t.Fatalf("\nhttpGetFiles()\nname: %v\nwant: 1 file\ngot:  %v", tt.name, files)
+			}
+			if files[0].Description != tt.wantDescription {
+				t.Errorf("\nDescription\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantDescription, files[0].Description)
+			}
+			if !slices.Equal(files[0].Tags, tt.wantTags) {
+				t.Errorf("\nTags\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantTags, files[0].Tags)
+			}
+			if files[0].MIMEType != "text/plain; charset=utf-8" {
+				t.Errorf("\nMIMEType\nname: %v\nwant: %v\ngot:  %v", tt.name, "text/plain; charset=utf-8", files[0].MIMEType)
+			}
+			if files[0].ClientIP != "127.0.0.1" || files[0].Uploader != "127.0.0.1" {
+				t.Errorf("\nClientIP, Uploader\nname: %v\nwant: %v\ngot:  %v, %v", tt.name, "127.0.0.1", files[0].ClientIP, files[0].Uploader)
+			}
+		})
+	}
+
+	metadata, _ := a.storage.GetMetadata("notes.txt")
+	if metadata.SHA256 == "" || metadata.UploadedAt.IsZero() {
+		t.Errorf("\nmetadata\nwant: hash and upload time kept\ngot:  %+v", metadata)
+	}
+}
+
+func Test_normalizeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "1",
+			tags: nil,
+			want: []string{},
+		},
+		{
+			name: "2",
+			tags: []string{" b", "a ", "b", " "},
+			want: []string{"a", "b"},
+		},
+		{
+			name:    "3",
+			tags:    []string{"a,b"},
+			wantErr: true,
+		},
+		{
+			name:    "4",
+			tags:    []string{"a\tb"},
+			wantErr: true,
+		},
+		{
+			name:    "5",
+			tags:    []string{strings.Repeat("x", 65)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTags(tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("\nnormalizeTags()\nname: %v\nwant: error %v\ngot:  %v", tt.name, tt.wantErr, err)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("\nnormalizeTags()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_httpGetFiles_listing(t *testing.T) {
+	a, server := newTestApp(t, nil)
+
+	for i, filename := range []string{"b.txt", "a.log", "c.pdf", "d.TXT"} {
+		writeTestFile(t, a, filename, strings.Repeat("x", i+1))
+
+		modifiedAt := time.Date(2026, 1, i+1, 12, 0, 0, 0, time.UTC)
+		err := os.Chtimes(filepath.Join(a.config.PathDataFolder, filename), modifiedAt, modifiedAt)
+		if err != nil {
+			t.Fatalf("os.Chtimes() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		want      []string
+		wantNext  bool
+		wantError bool
+	}{
+		{
+			name:  "1",
+			query: "",
+			want:  []string{"a.log", "b.txt", "c.pdf", "d.TXT"},
+		},
+		{
+			name:  "2",
+			query: "sort=size&order=desc",
+			want:  []string{"d.TXT", "c.pdf", "a.log", "b.txt"},
+		},
+		{
+			name:  "3",
+			query: "sort=mtime",
+			want:  []string{"b.txt", "a.log", "c.pdf", "d.TXT"},
+		},
+		{
+			name:  "4",
+			query: "glob=*.txt",
+			want:  []string{"b.txt"},
+		},
+		{
+			name:  "5",
+			query: "ext=txt,.pdf",
+			want:  []string{"b.txt", "c.pdf", "d.TXT"},
+		},
+		{
+			name:  "6",
+			query: "since=2026-01-02&until=2026-01-03",
+			want:  []string{"a.log", "c.pdf"},
+		},
+		{
+			name:     "7",
+			query:    "limit=3",
+			want:     []string{"a.log", "b.txt", "c.pdf"},
+			wantNext: true,
+		},
+		{
+			name:      "8",
+			query:     "sort=owner",
+			wantError: true,
+		},
+		{
+			name:      "9",
+			query:     "limit=0",
+			wantError: true,
+		},
+		{
+			name:      "10",
+			query:     "glob=[",
+			wantError: true,
+		},
+		{
+			name:      "11",
+			query:     "since=yesterday",
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/?"+tt.query, nil))
+
+			if tt.wantError {
+				if res.StatusCode != http.StatusBadRequest {
+					t.Errorf("\nhttpGetFiles()\nname: %v\nwant: %v\ngot:  %v (%s)", tt.name, http.StatusBadRequest, res.StatusCode, body)
+				}
+				return
+			}
+
+			var files []struct{ Name string }
+			if err := json.Unmarshal([]byte(body), &files); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+			}
+
+			got := []string{}
+			for _, file := range files {
+				got = append(got, file.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("\nhttpGetFiles()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+
+			if gotNext := res.Header.Get("Link") != ""; gotNext != tt.wantNext {
+				t.Errorf("\nLink\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantNext, res.Header.Get("Link"))
+			}
+		})
+	}
+}
+
+func Test_httpGetFiles_pagination(t *testing.T) {
+	a, server := newTestApp(t, nil)
+
+	want := []string{}
+	for i := range 7 {
+		filename := fmt.Sprintf("file-%d.txt", i)
+		writeTestFile(t, a, filename, strings.Repeat("x", 7-i))
+		want = append([]string{filename}, want...)
+	}
+
+	got := []string{}
+	next := "/files/?sort=size&limit=3"
+	for pages := 1; next != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("\nhttpGetFiles()\nwant: 3 pages\ngot:  more, next %v", next)
+		}
+
+		res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+next, nil))
+
+		var files []struct{ Name string }
+		if err := json.Unmarshal([]byte(body), &files); err != nil {
+			t.Fatalf("json.Unmarshal() failed: %v (%s)", err, body)
+		}
+		for _, file := range files {
+			got = append(got, file.Name)
+		}
+
+		next = ""
+		if link := res.Header.Get("Link"); link != "" {
+			next = strings.TrimPrefix(strings.TrimSuffix(link, `>; rel="next"`), "<")
+		}
+
+		// A file deleted in between must not shift the following pages.
+		if pages == 1 {
+			os.Remove(filepath.Join(a.config.PathDataFolder, want[0]))
+		}
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("\nhttpGetFiles()\nwant: %v\ngot:  %v", want, got)
+	}
+}
+
+func Test_httpGetEvents(t *testing.T) {
+	a, server := newTestApp(t, nil)
+	stop := a.StartEvents()
+	t.Cleanup(stop)
+
+	res, err := http.Get(server.URL + httpPathEvents)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", httpPathEvents, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\nevents\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("\nevents content type\nwant: %v\ngot:  %v", "text/event-stream", got)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	readEvent := func(kind string, filename string) {
+		t.Helper()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("\nevents %s\nwant: %s\ngot:  end of stream", kind, filename)
+				}
+				if line != "event: "+kind {
+					continue
+				}
+
+				data := <-lines
+				if !strings.Contains(data, `"Name":"`+filename+`"`) {
+					t.Errorf("\nevents %s\nwant: %s\ngot:  %v", kind, filename, data)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("\nevents %s\nwant: %s\ngot:  timeout", kind, filename)
+			}
+		}
+	}
+
+	res2, _ := doTestRequest(t, newTestUploadRequest(t, server.URL, map[string]string{"live.txt": "hello"}))
+	if res2.StatusCode != http.StatusOK {
+		t.Fatalf("\nupload\nwant: %d\ngot:  %d", http.StatusOK, res2.StatusCode)
+	}
+	readEvent("add", "live.txt")
+
+	res2, _ = doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/live.txt", nil))
+	if res2.StatusCode != http.StatusOK {
+		t.Fatalf("\ndelete\nwant: %d\ngot:  %d", http.StatusOK, res2.StatusCode)
+	}
+	readEvent("remove", "live.txt")
+
+	stop()
+	for range lines {
+	}
+}
+
+func Test_httpGetSearch(t *testing.T) {
+	a, server := newTestApp(t, func(c *config.Config) {
+		c.SearchMode = true
+	})
+	writeTestFile(t, a, "todo.md", "# Shopping\n\nBuy milk and bread.")
+	writeTestFile(t, a, "notes.txt", "Nothing to buy today.")
+
+	stop := a.StartIndexer()
+	t.Cleanup(stop)
+
+	type Result struct {
+		Name    string  `json:"Name"`
+		Score   float64 `json:"Score"`
+		Size    int64   `json:"Size"`
+		Snippet string  `json:"Snippet"`
+	}
+
+	var results []Result
+	deadline := time.Now().Add(5 * time.Second)
+	for len(results) == 0 && time.Now().Before(deadline) {
+		res, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/search/?q=milk", nil))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("\nsearch\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+		}
+
+		err := json.Unmarshal([]byte(body), &results)
+		if err != nil {
+			t.Fatalf("json.Unmarshal() failed: %v", err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if len(results) != 1 || results[0].Name != "todo.md" || results[0].Score <= 0 {
+		t.Fatalf("\nsearch results\nwant: todo.md\ngot:  %+v", results)
+	}
+	if want := "# Shopping Buy milk and bread."; results[0].Snippet != want {
+		t.Errorf("\nsearch snippet\nwant: %v\ngot:  %v", want, results[0].Snippet)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "1", query: "q=buy", want: http.StatusOK},
+		{name: "2", query: "q=", want: http.StatusBadRequest},
+		{name: "3", query: "q=a", want: http.StatusBadRequest},
+		{name: "4", query: "q=buy&limit=0", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _ := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/search/?"+tt.query, nil))
+			if res.StatusCode != tt.want {
+				t.Errorf("\nsearch\nname: %v\nwant: %d\ngot:  %d", tt.name, tt.want, res.StatusCode)
+			}
+		})
+	}
+
+	// Deleted files disappear from the results once they are indexed again.
+	res, _ := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/files/delete/todo.md", nil))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("\ndelete\nwant: %d\ngot:  %d", http.StatusOK, res.StatusCode)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for len(results) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+
+		_, body := doTestRequest(t, newTestRequest(t, http.MethodGet, server.URL+"/search/?q=milk", nil))
+		results = nil
+		err := json.Unmarshal([]byte(body), &results)
+		if err != nil {
+			t.Fatalf("json.Unmarshal() failed: %v", err)
+		}
+	}
+
+	if len(results) != 0 {
+		t.Errorf("\nsearch after delete\nwant: []\ngot:  %+v", results)
+	}
+}
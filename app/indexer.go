@@ -0,0 +1,83 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+// Files are indexed a moment after they changed, so a burst of uploads is
+// indexed at once. The data folder is looked at once per minute regardless,
+// for changes that weren't noticed.
+const indexerDebounceInterval time.Duration = time.Second
+const indexerInterval time.Duration = time.Minute
+
+const defaultSearchLimit int = 50
+const maxSearchLimit int = 1000
+
+// StartIndexer keeps the full-text index up to date in search mode until stop
+// is called. The index is loaded and brought up to date right away and then
+// whenever files change. stop waits until what was indexed so far is saved.
+// Calling stop more than once is safe.
+func (a *App) StartIndexer() (stop func()) {
+	if a.index == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		err := a.index.Load()
+		if err != nil {
+			a.config.Logger.Warn("Could not load index", "error", err)
+		}
+
+		ticker := time.NewTicker(indexerInterval)
+		defer ticker.Stop()
+
+		for {
+			a.updateIndex(done)
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			case <-a.indexTrigger:
+				select {
+				case <-done:
+					return
+				case <-time.After(indexerDebounceInterval):
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func (a *App) updateIndex(done <-chan struct{}) {
+	files, err := a.storage.GetFilesOfDataFolder()
+	if errors.Is(err, filesystem.ErrDataFolderUnavailable) {
+		return
+	}
+	if err != nil {
+		a.config.Logger.Warn("Could not list files for indexing", "error", err)
+		return
+	}
+
+	err = a.index.Sync(files, done)
+	if err != nil {
+		a.config.Logger.Warn("Could not update index", "error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+const janitorInterval time.Duration = time.Minute
+
+// StartJanitor deletes expired files right away and then once per minute
+// until stop is called. Files expire if FileTTL is set or if the uploader
+// chose a lifetime. In trash mode, it also purges files that were deleted
+// more than TrashRetention ago, in versioning mode, it deletes the versions
+// of files that are gone, and in dedup mode, it deletes the content no file
+// refers to anymore. Calling stop more than once is safe.
+func (a *App) StartJanitor() (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(janitorInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		a.deleteExpiredFiles(time.Now())
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				a.deleteExpiredFiles(now)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (a *App) deleteExpiredFiles(now time.Time) {
+	files, err := a.storage.GetFileListOfDataFolder()
+	if errors.Is(err, filesystem.ErrDataFolderUnavailable) {
+		return
+	}
+	if err != nil {
+		a.config.Logger.Warn("Could not list files for expiry", "error", err)
+		return
+	}
+
+	for filename, sizeInBytes := range files {
+		expiresAt, err := a.storage.GetExpiresAt(filename)
+		if err != nil {
+			a.config.Logger.Warn("Could not determine expiry", "file", filename, "error", err)
+			continue
+		}
+
+		if expiresAt.IsZero() || now.Before(expiresAt) {
+			continue
+		}
+
+		err = a.storage.DeleteFile(filename)
+		if err != nil {
+			a.config.Logger.Warn("Could not delete expired file", "file", filename, "error", err)
+			continue
+		}
+
+		a.config.Logger.Info("Expire", "size", sizeInBytes, "file", filename)
+		a.notifyFileChanged("")
+	}
+
+	err = a.storage.DeleteOrphanedMetadata()
+	if err != nil {
+		a.config.Logger.Warn("Could not delete orphaned metadata", "error", err)
+	}
+
+	if a.config.TrashMode {
+		a.purgeExpiredTrash(now)
+	}
+
+	if a.config.VersioningMode {
+		err = a.storage.DeleteOrphanedVersions()
+		if err != nil {
+			a.config.Logger.Warn("Could not delete orphaned versions", "error", err)
+		}
+	}
+
+	if a.config.DedupMode {
+		err = a.storage.DeleteUnusedObjects()
+		if err != nil {
+			a.config.Logger.Warn("Could not delete unused objects", "error", err)
+		}
+	}
+}
+
+func (a *App) purgeExpiredTrash(now time.Time) {
+	purged, err := a.storage.PurgeExpiredTrash(now.Add(-a.config.TrashRetention))
+	for _, trashEntry := range purged {
+		a.config.Logger.Info("Purge", "size", trashEntry.Size, "file", trashEntry.Name)
+	}
+	if err != nil {
+		a.config.Logger.Warn("Could not purge trash", "error", err)
+	}
+}
@@ -0,0 +1,303 @@
+package app
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+const maxFileListLimit int = 10000
+
+// fileInfo is a file as it is listed on /files/ and sent to /events/.
+type fileInfo struct {
+	BLAKE3      string    `json:"BLAKE3,omitempty"`
+	ClientIP    string    `json:"ClientIP,omitempty"`
+	Description string    `json:"Description,omitempty"`
+	ExpiresAt   time.Time `json:"ExpiresAt,omitzero"`
+	ExpiresIn   int64     `json:"ExpiresIn,omitempty"`
+	MIMEType    string    `json:"MIMEType,omitempty"`
+	ModifiedAt  time.Time `json:"ModifiedAt"`
+	Name        string    `json:"Name"`
+	SHA256      string    `json:"SHA256,omitempty"`
+	Size        int64     `json:"Size"`
+	Tags        []string  `json:"Tags,omitempty"`
+	UploadedAt  time.Time `json:"UploadedAt,omitzero"`
+	Uploader    string    `json:"Uploader,omitempty"`
+	Versions    int       `json:"Versions,omitempty"`
+}
+
+// fileListCursor points at the last file of a page of the file list. The next
+// page starts right after it, so files that are added or deleted in between
+// don't shift the pages like an offset would.
+type fileListCursor struct {
+	ModifiedAt time.Time `json:"ModifiedAt"`
+	Name       string    `json:"Name"`
+	Order      string    `json:"Order"`
+	Size       int64     `json:"Size"`
+	Sort       string    `json:"Sort"`
+}
+
+// fileListOptions are the query parameters of the file list. The zero value
+// lists every file sorted by name.
+type fileListOptions struct {
+	cursor     *fileListCursor
+	extensions []string
+	glob       string
+	limit      int
+	order      string
+	since      time.Time
+	sort       string
+	until      time.Time
+}
+
+// getFileInfo adds the metadata, the expiry and the number of versions to
+// file. Errors are logged, the affected fields are left empty.
+func (a *App) getFileInfo(logger *slog.Logger, file filesystem.File) fileInfo {
+	filename := file.Name
+	info := fileInfo{
+		ModifiedAt: file.ModifiedAt.UTC(),
+		Name:       filename,
+		Size:       file.Size,
+	}
+
+	metadata, err := a.storage.GetMetadata(filename)
+	if err != nil {
+		logger.Warn("Could not read metadata", "file", filename, "error", err)
+	}
+	if a.config.DedupMode {
+		info.ModifiedAt = metadata.GetModifiedAt(file.ModifiedAt).UTC()
+	}
+	info.BLAKE3 = metadata.BLAKE3
+	info.ClientIP = metadata.ClientIP
+	info.Description = metadata.Description
+	info.MIMEType = metadata.MIMEType
+	info.SHA256 = metadata.SHA256
+	info.Tags = metadata.Tags
+	info.UploadedAt = metadata.UploadedAt.UTC()
+	info.Uploader = metadata.Uploader
+
+	expiresAt, err := a.storage.GetExpiresAt(filename)
+	if err != nil {
+		logger.Warn("Could not determine expiry", "file", filename, "error", err)
+	} else if !expiresAt.IsZero() {
+		// Expired files are shown with one second left until the janitor
+		// deletes them.
+		info.ExpiresAt = expiresAt.UTC()
+		info.ExpiresIn = max(int64(time.Until(expiresAt).Seconds()), 1)
+	}
+
+	if a.config.VersioningMode {
+		versions, err := a.storage.GetVersions(filename)
+		if err != nil {
+			logger.Warn("Could not read versions", "file", filename, "error", err)
+		}
+		info.Versions = len(versions)
+	}
+
+	return info
+}
+
+// compare orders files by the sort key and then by name, so the order is
+// total and cursors are unambiguous.
+func (options fileListOptions) compare(a, b filesystem.File) int {
+	var c int
+	switch options.sort {
+	case "mtime":
+		c = a.ModifiedAt.Compare(b.ModifiedAt)
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+
+	if options.order == "desc" {
+		return -c
+	}
+
+	return c
+}
+
+// getCursor returns the cursor of the page that follows file.
+func (options fileListOptions) getCursor(file filesystem.File) string {
+	data, _ := json.Marshal(fileListCursor{
+		ModifiedAt: file.ModifiedAt,
+		Name:       file.Name,
+		Order:      options.order,
+		Size:       file.Size,
+		Sort:       options.sort,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (options fileListOptions) matches(file filesystem.File) bool {
+	if options.glob != "" {
+		matched, _ := path.Match(options.glob, file.Name)
+		if !matched {
+			return false
+		}
+	}
+
+	if len(options.extensions) > 0 && !slices.Contains(options.extensions, strings.ToLower(filepath.Ext(file.Name))) {
+		return false
+	}
+
+	if !options.since.IsZero() && file.ModifiedAt.Before(options.since) {
+		return false
+	}
+
+	if !options.until.IsZero() && !file.ModifiedAt.Before(options.until) {
+		return false
+	}
+
+	return true
+}
+
+// usesModifiedAt reports whether the list is sorted or filtered by the
+// modification time of the files.
+func (options fileListOptions) usesModifiedAt() bool {
+	return options.sort == "mtime" || !options.since.IsZero() || !options.until.IsZero()
+}
+
+// getFileListPage filters and sorts files and returns the page selected by
+// options, along with whether there are more files after it.
+func getFileListPage(files []filesystem.File, options fileListOptions) ([]filesystem.File, bool) {
+	selected := []filesystem.File{}
+	for _, file := range files {
+		if options.matches(file) {
+			selected = append(selected, file)
+		}
+	}
+
+	slices.SortFunc(selected, options.compare)
+
+	if options.cursor != nil {
+		cursorFile := filesystem.File{
+			ModifiedAt: options.cursor.ModifiedAt,
+			Name:       options.cursor.Name,
+			Size:       options.cursor.Size,
+		}
+
+		start, found := slices.BinarySearchFunc(selected, cursorFile, options.compare)
+		if found {
+			start++
+		}
+		selected = selected[start:]
+	}
+
+	if options.limit > 0 && len(selected) > options.limit {
+		return selected[:options.limit], true
+	}
+
+	return selected, false
+}
+
+// parseFileListOptions reads the query parameters of the file list. Its
+// errors are meant to be shown to the client.
+func parseFileListOptions(query url.Values) (fileListOptions, error) {
+	options := fileListOptions{
+		glob:  query.Get("glob"),
+		order: cmp.Or(query.Get("order"), "asc"),
+		sort:  cmp.Or(query.Get("sort"), "name"),
+	}
+
+	if !slices.Contains([]string{"mtime", "name", "size"}, options.sort) {
+		return options, fmt.Errorf("The sort must be one of name, size or mtime.")
+	}
+
+	if options.order != "asc" && options.order != "desc" {
+		return options, fmt.Errorf("The order must be either asc or desc.")
+	}
+
+	if _, err := path.Match(options.glob, ""); err != nil {
+		return options, fmt.Errorf("The glob is not a valid pattern.")
+	}
+
+	for extension := range strings.SplitSeq(query.Get("ext"), ",") {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+
+		options.extensions = append(options.extensions, "."+strings.TrimPrefix(extension, "."))
+	}
+
+	var err error
+
+	options.since, err = parseFileListTime(query.Get("since"), false)
+	if err != nil {
+		return options, fmt.Errorf("The since parameter must be a date like 2006-01-02 or a time like 2006-01-02T15:04:05Z.")
+	}
+
+	options.until, err = parseFileListTime(query.Get("until"), true)
+	if err != nil {
+		return options, fmt.Errorf("The until parameter must be a date like 2006-01-02 or a time like 2006-01-02T15:04:05Z.")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		options.limit, err = strconv.Atoi(limit)
+		if err != nil || options.limit < 1 || options.limit > maxFileListLimit {
+			return options, fmt.Errorf("The limit must be between 1 and %d.", maxFileListLimit)
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		options.cursor, err = parseFileListCursor(cursor)
+		if err != nil || options.cursor.Sort != options.sort || options.cursor.Order != options.order {
+			return options, fmt.Errorf("The cursor is invalid or belongs to a different sort order.")
+		}
+	}
+
+	return options, nil
+}
+
+func parseFileListCursor(value string) (*fileListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor fileListCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
+
+// parseFileListTime parses an RFC 3339 time or a date in UTC. A date as the
+// end of a range includes the whole day.
+func parseFileListTime(value string, isEnd bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if isEnd {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const maxLengthRequestID int = 64
+
+type requestIDContextKey struct{}
+
+// getLogger returns the logger of the app with the ID of r attached, so all
+// log entries of a request can be correlated.
+func (a *App) getLogger(r *http.Request) *slog.Logger {
+	requestID, _ := r.Context().Value(requestIDContextKey{}).(string)
+	if requestID == "" {
+		return a.config.Logger
+	}
+
+	return a.config.Logger.With("request_id", requestID)
+}
+
+// requestLoggingMiddleware assigns every request an ID, which is returned in
+// the X-Request-ID header, and logs every request at debug level. IDs set by
+// a reverse proxy are kept.
+func (a *App) requestLoggingMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if !isValidRequestID(requestID) {
+			requestID = generateRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID))
+
+		recorder := &recordingResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+
+		a.getLogger(r).Debug(
+			"Request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"proto", r.Proto,
+			"status", recorder.getStatusCode(),
+			"bytes", recorder.bytesWritten,
+			"duration", time.Since(start),
+			"client_ip", a.getClientIP(r),
+		)
+	})
+}
+
+func generateRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxLengthRequestID {
+		return false
+	}
+
+	for _, r := range requestID {
+		isAlphanumeric := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if !isAlphanumeric && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxLenDescription int = 1024
+const maxLenTag int = 64
+const maxNumberOfTags int = 32
+
+// errInvalidMetadata is returned for descriptions and tags that are too long
+// or contain characters that can't be displayed.
+var errInvalidMetadata = errors.New("invalid metadata")
+
+// getMIMEType returns the MIME type of a file the same way downloads do,
+// from its extension, and falls back to sniffing the start of its content.
+func getMIMEType(filename string, head []byte) string {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
+	if mimeType != "" {
+		return mimeType
+	}
+
+	return http.DetectContentType(head)
+}
+
+func normalizeDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+
+	if utf8.RuneCountInString(description) > maxLenDescription || strings.ContainsFunc(description, isInvalidMetadataRune) {
+		return "", errInvalidMetadata
+	}
+
+	return description, nil
+}
+
+// normalizeTags trims every tag, drops empty and duplicate tags and sorts the
+// rest, so the same set of tags is always stored the same way.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		if utf8.RuneCountInString(tag) > maxLenTag || strings.ContainsFunc(tag, isInvalidMetadataRune) || strings.Contains(tag, ",") {
+			return nil, errInvalidMetadata
+		}
+
+		normalized = append(normalized, tag)
+	}
+
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+
+	if len(normalized) > maxNumberOfTags {
+		return nil, errInvalidMetadata
+	}
+
+	return normalized, nil
+}
+
+// isInvalidMetadataRune rejects control characters except for line breaks,
+// which are allowed in descriptions.
+func isInvalidMetadataRune(r rune) bool {
+	return r == utf8.RuneError || (unicode.IsControl(r) && r != '\n')
+}
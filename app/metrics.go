@@ -0,0 +1,248 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/config"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The buckets of the request duration histogram in seconds. Uploads and
+// downloads of large files take minutes, so they go well beyond the defaults
+// of the Prometheus client libraries.
+var metricsDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metrics collects the counters exposed in the Prometheus text format on
+// /metrics. It is written by hand to keep ablage free of dependencies.
+type metrics struct {
+	activeUploads    atomic.Int64
+	authFailures     atomic.Uint64
+	bytesDownloaded  atomic.Uint64
+	bytesUploaded    atomic.Uint64
+	mutex            sync.Mutex
+	requestCounts    map[metricsRequestKey]uint64
+	requestDurations map[string]*metricsHistogram
+}
+
+type metricsHistogram struct {
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+type metricsRequestKey struct {
+	code   int
+	method string
+	route  string
+}
+
+// recordingResponseWriter records the status code and the number of bytes of a
+// response for metrics and logs. It implements io.ReaderFrom, so
+// http.ServeFile can still use sendfile for downloads.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	bytesWritten int64
+	statusCode   int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requestCounts:    map[metricsRequestKey]uint64{},
+		requestDurations: map[string]*metricsHistogram{},
+	}
+}
+
+// MetricsHandler returns a handler that only serves /metrics, without basic
+// authentication, to be served on a separate listener.
+func (a *App) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != httpPathMetrics {
+			http.Error(w, "404 Not Found", http.StatusNotFound)
+			return
+		}
+
+		a.httpGetMetrics(w, r, nil)
+	})
+}
+
+// instrument wraps handle to record the number and duration of requests to
+// route, as well as the bytes sent for downloads.
+func (a *App) instrument(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		recorder := &recordingResponseWriter{ResponseWriter: w}
+
+		handle(recorder, r, ps)
+
+		a.metrics.observeRequest(route, r.Method, recorder.getStatusCode(), time.Since(start))
+
+		if route == httpPathFilesGetFilename {
+			a.metrics.bytesDownloaded.Add(uint64(recorder.bytesWritten))
+		}
+	}
+}
+
+func (m *metrics) observeRequest(route string, method string, code int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requestCounts[metricsRequestKey{code: code, method: method, route: route}]++
+
+	histogram, ok := m.requestDurations[route]
+	if !ok {
+		histogram = &metricsHistogram{bucketCounts: make([]uint64, len(metricsDurationBuckets))}
+		m.requestDurations[route] = histogram
+	}
+
+	seconds := duration.Seconds()
+	for i, upperBound := range metricsDurationBuckets {
+		if seconds <= upperBound {
+			histogram.bucketCounts[i]++
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+func (m *metrics) writeTo(w io.Writer, fileCount int, folderSize int64, folderErr error) {
+	writeMetricsHeader(w, "ablage_build_info", "gauge", "Version of ablage.")
+	fmt.Fprintf(w, "ablage_build_info{version=\"%s\"} 1\n", escapeMetricsLabelValue(config.VersionString))
+
+	writeMetricsHeader(w, "ablage_active_uploads", "gauge", "Number of uploads in progress.")
+	fmt.Fprintf(w, "ablage_active_uploads %d\n", m.activeUploads.Load())
+
+	writeMetricsHeader(w, "ablage_auth_failures_total", "counter", "Number of requests rejected by basic authentication.")
+	fmt.Fprintf(w, "ablage_auth_failures_total %d\n", m.authFailures.Load())
+
+	writeMetricsHeader(w, "ablage_downloaded_bytes_total", "counter", "Number of bytes sent for file downloads.")
+	fmt.Fprintf(w, "ablage_downloaded_bytes_total %d\n", m.bytesDownloaded.Load())
+
+	writeMetricsHeader(w, "ablage_uploaded_bytes_total", "counter", "Number of bytes received for completed file uploads.")
+	fmt.Fprintf(w, "ablage_uploaded_bytes_total %d\n", m.bytesUploaded.Load())
+
+	dataFolderAvailable := 1
+	if folderErr != nil {
+		dataFolderAvailable = 0
+	}
+
+	writeMetricsHeader(w, "ablage_data_folder_available", "gauge", "Whether the data folder is available (1) or ablage runs in degraded mode (0).")
+	fmt.Fprintf(w, "ablage_data_folder_available %d\n", dataFolderAvailable)
+
+	if folderErr == nil {
+		writeMetricsHeader(w, "ablage_data_folder_files", "gauge", "Number of files in the data folder.")
+		fmt.Fprintf(w, "ablage_data_folder_files %d\n", fileCount)
+
+		writeMetricsHeader(w, "ablage_data_folder_bytes", "gauge", "Total size of the files in the data folder.")
+		fmt.Fprintf(w, "ablage_data_folder_bytes %d\n", folderSize)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	requestKeys := make([]metricsRequestKey, 0, len(m.requestCounts))
+	for key := range m.requestCounts {
+		requestKeys = append(requestKeys, key)
+	}
+	slices.SortFunc(requestKeys, func(a, b metricsRequestKey) int {
+		return strings.Compare(
+			fmt.Sprintf("%s %s %03d", a.route, a.method, a.code),
+			fmt.Sprintf("%s %s %03d", b.route, b.method, b.code),
+		)
+	})
+
+	writeMetricsHeader(w, "ablage_http_requests_total", "counter", "Number of HTTP requests by route, method and status code.")
+	for _, key := range requestKeys {
+		fmt.Fprintf(
+			w,
+			"ablage_http_requests_total{route=\"%s\",method=\"%s\",code=\"%d\"} %d\n",
+			escapeMetricsLabelValue(key.route),
+			escapeMetricsLabelValue(key.method),
+			key.code,
+			m.requestCounts[key],
+		)
+	}
+
+	routes := make([]string, 0, len(m.requestDurations))
+	for route := range m.requestDurations {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+
+	writeMetricsHeader(w, "ablage_http_request_duration_seconds", "histogram", "Duration of HTTP requests by route.")
+	for _, route := range routes {
+		histogram := m.requestDurations[route]
+		label := escapeMetricsLabelValue(route)
+
+		for i, upperBound := range metricsDurationBuckets {
+			fmt.Fprintf(
+				w,
+				"ablage_http_request_duration_seconds_bucket{route=\"%s\",le=\"%s\"} %d\n",
+				label,
+				strconv.FormatFloat(upperBound, 'g', -1, 64),
+				histogram.bucketCounts[i],
+			)
+		}
+		fmt.Fprintf(w, "ablage_http_request_duration_seconds_bucket{route=\"%s\",le=\"+Inf\"} %d\n", label, histogram.count)
+		fmt.Fprintf(w, "ablage_http_request_duration_seconds_sum{route=\"%s\"} %s\n", label, strconv.FormatFloat(histogram.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "ablage_http_request_duration_seconds_count{route=\"%s\"} %d\n", label, histogram.count)
+	}
+}
+
+func (w *recordingResponseWriter) ReadFrom(reader io.Reader) (int64, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	n, err := io.Copy(w.ResponseWriter, reader)
+	w.bytesWritten += n
+
+	return n, err
+}
+
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytesWritten += int64(n)
+
+	return n, err
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingResponseWriter) getStatusCode() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+
+	return w.statusCode
+}
+
+func escapeMetricsLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func writeMetricsHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
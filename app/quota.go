@@ -0,0 +1,219 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"syscall"
+
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+// How often a running upload looks at the free disk space and at the usage of
+// the data folder again. Looking up the usage of a single uploader requires
+// reading every metadata sidecar, so it is done less often. Overshooting in
+// between is prevented by reserving the bytes of every upload in flight.
+const quotaDiskSpaceCheckInterval int64 = 1024 * 1024
+const quotaUsageCheckInterval int64 = 64 * 1024 * 1024
+
+// quota keeps track of the bytes of uploads in flight, which are not part of
+// the usage of the data folder until the upload is complete, so concurrent
+// uploads can't exceed a quota together.
+type quota struct {
+	mutex                   sync.Mutex
+	reservedBytes           int64
+	reservedBytesByUploader map[string]int64
+}
+
+type quotaError struct {
+	message    string
+	statusCode int
+}
+
+// quotaWriter enforces the quotas while a single file is streamed into the
+// upload folder.
+type quotaWriter struct {
+	app                *App
+	bytesWritten       int64
+	file               io.Writer
+	freeBytes          int64
+	freeBytesCheckedAt int64
+	uploader           string
+	usage              filesystem.Usage
+	usageCheckedAt     int64
+}
+
+func newQuota() *quota {
+	return &quota{reservedBytesByUploader: map[string]int64{}}
+}
+
+func newQuotaWriter(a *App, file io.Writer, uploader string) (*quotaWriter, error) {
+	w := &quotaWriter{
+		app:       a,
+		file:      file,
+		freeBytes: a.getFreeDiskSpaceForQuota(),
+		uploader:  uploader,
+	}
+
+	usage, err := a.getUsageForQuota(uploader)
+	if err != nil {
+		return nil, err
+	}
+	w.usage = usage
+
+	return w, nil
+}
+
+// checkQuota returns a *quotaError if storing additional bytes would exceed a
+// quota or the disk space reserve. freeBytes is negative if the free disk
+// space is unknown.
+func (a *App) checkQuota(uploader string, usage filesystem.Usage, freeBytes int64, additionalBytes int64) error {
+	a.quota.mutex.Lock()
+	defer a.quota.mutex.Unlock()
+
+	return a.checkQuotaLocked(uploader, usage, freeBytes, additionalBytes)
+}
+
+func (a *App) checkQuotaLocked(uploader string, usage filesystem.Usage, freeBytes int64, additionalBytes int64) error {
+	if a.config.QuotaTotal > 0 && usage.TotalBytes+a.quota.reservedBytes+additionalBytes > a.config.QuotaTotal {
+		return &quotaError{
+			message:    fmt.Sprintf("The quota of %s is exhausted.", filesystem.GetHumanReadableSize(a.config.QuotaTotal)),
+			statusCode: http.StatusInsufficientStorage,
+		}
+	}
+
+	if a.config.QuotaPerUser > 0 && usage.UploaderBytes+a.quota.reservedBytesByUploader[uploader]+additionalBytes > a.config.QuotaPerUser {
+		return &quotaError{
+			message:    fmt.Sprintf("Your quota of %s is exhausted.", filesystem.GetHumanReadableSize(a.config.QuotaPerUser)),
+			statusCode: http.StatusInsufficientStorage,
+		}
+	}
+
+	if a.config.QuotaMinFreeDiskSpace > 0 && freeBytes >= 0 && freeBytes-additionalBytes < a.config.QuotaMinFreeDiskSpace {
+		return &quotaError{
+			message:    "Not enough disk space left.",
+			statusCode: http.StatusInsufficientStorage,
+		}
+	}
+
+	return nil
+}
+
+// getFreeDiskSpace returns the free disk space of the data folder, or -1 if
+// it can't be determined.
+func (a *App) getFreeDiskSpace() int64 {
+	freeBytes, err := filesystem.GetFreeDiskSpace(a.config.PathDataFolder)
+	if err != nil {
+		return -1
+	}
+
+	return int64(freeBytes)
+}
+
+func (a *App) getFreeDiskSpaceForQuota() int64 {
+	if a.config.QuotaMinFreeDiskSpace == 0 {
+		return -1
+	}
+
+	return a.getFreeDiskSpace()
+}
+
+// getUsageForQuota only looks at the data folder if a quota is enabled.
+func (a *App) getUsageForQuota(uploader string) (filesystem.Usage, error) {
+	if a.config.QuotaPerUser > 0 {
+		return a.storage.GetUsage(uploader)
+	}
+
+	if a.config.QuotaTotal > 0 {
+		return a.storage.GetUsage("")
+	}
+
+	return filesystem.Usage{}, nil
+}
+
+func (a *App) releaseQuota(uploader string, bytes int64) {
+	a.quota.mutex.Lock()
+	defer a.quota.mutex.Unlock()
+
+	a.quota.reservedBytes -= bytes
+	a.quota.reservedBytesByUploader[uploader] -= bytes
+	if a.quota.reservedBytesByUploader[uploader] <= 0 {
+		delete(a.quota.reservedBytesByUploader, uploader)
+	}
+}
+
+func (a *App) reserveQuota(uploader string, usage filesystem.Usage, freeBytes int64, bytes int64) error {
+	a.quota.mutex.Lock()
+	defer a.quota.mutex.Unlock()
+
+	err := a.checkQuotaLocked(uploader, usage, freeBytes, bytes)
+	if err != nil {
+		return err
+	}
+
+	a.quota.reservedBytes += bytes
+	a.quota.reservedBytesByUploader[uploader] += bytes
+
+	return nil
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	n := int64(len(p))
+
+	if w.app.config.QuotaMaxFileSize > 0 && w.bytesWritten+n > w.app.config.QuotaMaxFileSize {
+		return 0, &quotaError{
+			message:    fmt.Sprintf("The file exceeds the maximum file size of %s.", filesystem.GetHumanReadableSize(w.app.config.QuotaMaxFileSize)),
+			statusCode: http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	if w.bytesWritten-w.freeBytesCheckedAt >= quotaDiskSpaceCheckInterval {
+		w.freeBytes = w.app.getFreeDiskSpaceForQuota()
+		w.freeBytesCheckedAt = w.bytesWritten
+	}
+
+	if w.bytesWritten-w.usageCheckedAt >= quotaUsageCheckInterval {
+		usage, err := w.app.getUsageForQuota(w.uploader)
+		if err != nil {
+			return 0, err
+		}
+		w.usage = usage
+		w.usageCheckedAt = w.bytesWritten
+	}
+
+	// The bytes this writer wrote since the last look at the disk are not
+	// reflected in freeBytes yet.
+	freeBytes := w.freeBytes
+	if freeBytes >= 0 {
+		freeBytes -= w.bytesWritten - w.freeBytesCheckedAt
+	}
+
+	err := w.app.reserveQuota(w.uploader, w.usage, freeBytes, n)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := w.file.Write(p)
+	w.bytesWritten += int64(written)
+	if int64(written) < n {
+		w.app.releaseQuota(w.uploader, n-int64(written))
+	}
+
+	if errors.Is(err, syscall.ENOSPC) {
+		return written, &quotaError{message: "Not enough disk space left.", statusCode: http.StatusInsufficientStorage}
+	}
+
+	return written, err
+}
+
+// release gives back the bytes reserved by this writer. It has to be called
+// once the file was moved into the data folder or deleted.
+func (w *quotaWriter) release() {
+	w.app.releaseQuota(w.uploader, w.bytesWritten)
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+	"lukechampine.com/blake3"
+)
+
+// errAmbiguousSHA256 is returned for every file but the first of an upload
+// with an expected hash in its query, which only applies to a single file.
+var errAmbiguousSHA256 = errors.New("sha256 query parameter with multiple files")
+
+// errHashMismatch is returned for files that don't have the hash the client
+// expected, e.g. because they were corrupted in transit.
+var errHashMismatch = errors.New("hash mismatch")
+
+// uploadOptions applies to every file of an upload request.
+type uploadOptions struct {
+	collisionPolicy string
+	expectedSHA256  string
+	expiresAt       time.Time
+	uploader        string
+}
+
+// uploadResult describes the outcome of a single file of an upload request.
+// Files are stored independently of each other, so a request can partially
+// succeed.
+type uploadResult struct {
+	BLAKE3     string `json:"BLAKE3,omitempty"`
+	Error      string `json:"Error,omitempty"`
+	Name       string `json:"Name"`
+	SHA256     string `json:"SHA256,omitempty"`
+	Size       int64  `json:"Size"`
+	Status     string `json:"Status"`
+	StatusCode int    `json:"StatusCode"`
+	StoredName string `json:"StoredName,omitempty"`
+}
+
+// headWriter keeps the first bytes written to it, which are enough to sniff
+// the MIME type of a file.
+type headWriter struct {
+	data []byte
+}
+
+// storeUploadedPart streams part into a staging file in the upload folder and
+// moves it into the data folder once it is complete. Failures are reported in
+// the result, so the remaining files of the request can still be stored.
+func (a *App) storeUploadedPart(r *http.Request, part *multipart.Part, options uploadOptions) uploadResult {
+	result := uploadResult{Name: part.FileName()}
+
+	expectedSHA256, err := getExpectedSHA256(http.Header(part.Header))
+	if err != nil {
+		return result.withError(err)
+	}
+	if expectedSHA256 == "" {
+		expectedSHA256 = options.expectedSHA256
+	}
+
+	safeFilename := filesystem.SanitizeFilename(part.FileName())
+	pathToFileInDataFolder := filepath.Join(a.config.PathDataFolder, safeFilename)
+
+	// Rejected files are not received at all. StoreFile checks again once the
+	// file was received, as the same name may be uploaded at the same time,
+	// and resolves collisions with the other policies.
+	if info, err := os.Stat(pathToFileInDataFolder); err == nil && (options.collisionPolicy == config.CollisionPolicyReject || info.IsDir()) {
+		return result.withError(filesystem.ErrFileExists)
+	}
+
+	uploadFile, err := a.storage.CreateStagingFile()
+	if err != nil {
+		return result.withError(a.getStorageError(err))
+	}
+	pathToFileInUploadFolder := uploadFile.Name()
+
+	writer, err := newQuotaWriter(a, uploadFile, options.uploader)
+	if err != nil {
+		uploadFile.Close()
+		_ = os.Remove(pathToFileInUploadFolder)
+		return result.withError(err)
+	}
+
+	head := &headWriter{}
+	hashSHA256 := sha256.New()
+	writers := []io.Writer{writer, head, hashSHA256}
+
+	var hashBLAKE3 hash.Hash
+	if a.config.BLAKE3Mode {
+		hashBLAKE3 = blake3.New(32, nil)
+		writers = append(writers, hashBLAKE3)
+	}
+
+	bytesWritten, err := io.Copy(io.MultiWriter(writers...), part)
+	uploadFile.Close()
+	result.Size = bytesWritten
+	if err != nil {
+		_ = os.Remove(pathToFileInUploadFolder)
+		writer.release()
+		return result.withError(err)
+	}
+
+	result.SHA256 = hex.EncodeToString(hashSHA256.Sum(nil))
+	if hashBLAKE3 != nil {
+		result.BLAKE3 = hex.EncodeToString(hashBLAKE3.Sum(nil))
+	}
+
+	if expectedSHA256 != "" && expectedSHA256 != result.SHA256 {
+		_ = os.Remove(pathToFileInUploadFolder)
+		writer.release()
+		a.getLogger(r).Warn("Upload does not match the expected hash", "file", safeFilename, "sha256", result.SHA256, "expected_sha256", expectedSHA256)
+		return result.withError(errHashMismatch)
+	}
+
+	// A file that could not be deduplicated is still stored, just with a copy
+	// of its own.
+	if a.config.DedupMode {
+		deduplicated, err := a.storage.DeduplicateFile(pathToFileInUploadFolder, result.SHA256)
+		if err != nil {
+			a.getLogger(r).Warn("Could not deduplicate file", "file", safeFilename, "error", err)
+		}
+		if deduplicated {
+			a.getLogger(r).Debug("Deduplicated file", "file", safeFilename, "sha256", result.SHA256)
+		}
+	}
+
+	storedFilename, err := a.storage.StoreFile(pathToFileInUploadFolder, safeFilename, options.collisionPolicy)
+	writer.release()
+	if err != nil {
+		_ = os.Remove(pathToFileInUploadFolder)
+		if a.config.DedupMode {
+			errObject := a.storage.DeleteObjectIfUnused(result.SHA256)
+			if errObject != nil {
+				a.getLogger(r).Warn("Could not delete object", "file", safeFilename, "error", errObject)
+			}
+		}
+		if !errors.Is(err, filesystem.ErrFileExists) {
+			a.getLogger(r).Warn("Could not store file", "file", safeFilename, "error", err)
+			err = a.getStorageError(err)
+		}
+		return result.withError(err)
+	}
+
+	metadata := filesystem.Metadata{
+		BLAKE3:     result.BLAKE3,
+		ClientIP:   a.getClientIP(r),
+		ExpiresAt:  options.expiresAt,
+		MIMEType:   getMIMEType(storedFilename, head.data),
+		SHA256:     result.SHA256,
+		UploadedAt: time.Now(),
+		Uploader:   options.uploader,
+	}
+
+	err = a.storage.SaveMetadata(storedFilename, metadata)
+	if err != nil {
+		a.getLogger(r).Warn("Could not save metadata", "file", storedFilename, "error", err)
+	}
+
+	if a.config.VersioningMode {
+		a.pruneVersions(r, storedFilename)
+	}
+
+	a.metrics.bytesUploaded.Add(uint64(bytesWritten))
+
+	a.getLogger(r).Info("Upload", "client_ip", a.getClientIP(r), "size", bytesWritten, "file", storedFilename)
+	a.notifyFileChanged(storedFilename)
+
+	result.Status = "ok"
+	result.StatusCode = http.StatusOK
+	result.StoredName = storedFilename
+
+	return result
+}
+
+// getStorageError returns the error of the data folder if it became
+// unavailable and err otherwise.
+func (a *App) getStorageError(err error) error {
+	availabilityErr := a.storage.CheckAvailability()
+	if availabilityErr != nil {
+		return availabilityErr
+	}
+
+	return err
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	// http.DetectContentType considers at most 512 bytes.
+	if missing := 512 - len(h.data); missing > 0 {
+		h.data = append(h.data, p[:min(missing, len(p))]...)
+	}
+
+	return len(p), nil
+}
+
+// withError marks the result as failed with the status code and message the
+// client would have gotten for err if the file was uploaded on its own.
+func (result uploadResult) withError(err error) uploadResult {
+	result.Status = "error"
+	result.StatusCode, result.Error = getUploadErrorResponse(err)
+	return result
+}
+
+// getUploadErrorResponse maps an error of an upload to a status code and a
+// message. Unknown errors are treated as an interrupted upload.
+func getUploadErrorResponse(err error) (int, string) {
+	var quotaErr *quotaError
+
+	switch {
+	case errors.As(err, &quotaErr):
+		return quotaErr.statusCode, quotaErr.message
+	case errors.Is(err, filesystem.ErrFileExists):
+		return http.StatusConflict, "File already exists."
+	case errors.Is(err, errHashMismatch):
+		return http.StatusUnprocessableEntity, "The file does not match the expected hash."
+	case errors.Is(err, errAmbiguousSHA256):
+		return http.StatusBadRequest, "The sha256 query parameter only applies to a single file, send a Repr-Digest header with every file instead."
+	case errors.Is(err, errInvalidDigest):
+		return http.StatusBadRequest, "The expected hash must be a SHA-256 hash."
+	case errors.Is(err, filesystem.ErrDataFolderUnavailable):
+		return http.StatusServiceUnavailable, "The data folder is unavailable, please try again later."
+	default:
+		return http.StatusInternalServerError, "Upload was interrupted."
+	}
+}
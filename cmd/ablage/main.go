@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage"
+	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/systemd"
+)
+
+func main() {
+	options, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] %v\n", err)
+		os.Exit(1)
+	}
+
+	server, err := ablage.New(*options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] %v\n", err)
+		os.Exit(1)
+	}
+
+	slog.SetDefault(server.Logger())
+
+	systemdListeners, err := systemd.GetListeners()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] %v\n", err)
+		os.Exit(1)
+	}
+
+	var listeningOn []string
+	var serveFuncs []func() error
+	if len(systemdListeners) > 0 {
+		listeningOn, serveFuncs, err = adoptSystemdListeners(server, options, systemdListeners)
+	} else {
+		listeningOn, serveFuncs, err = openListenAddresses(server, options)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] %v\n", err)
+		os.Exit(1)
+	}
+
+	if options.MetricsListenAddress != "" {
+		metricsListenAddress, err := options.GetMetricsListenAddress()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[Error] %v\n", err)
+			os.Exit(1)
+		}
+
+		metricsListener, err := listen(metricsListenAddress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[Error] Could not listen on '%s': %v\n", metricsListenAddress, err)
+			os.Exit(1)
+		}
+
+		serveFuncs = append(serveFuncs, func() error { return server.ServeMetrics(metricsListener) })
+	}
+
+	server.PrintStartupBanner(listeningOn)
+
+	err = systemd.Notify("READY=1")
+	if err != nil {
+		slog.Warn("Could not notify systemd", "error", err)
+	}
+
+	stopWatchdog := systemd.StartWatchdog(func() error {
+		err := server.CheckLiveness()
+		if err != nil {
+			slog.Error("Skipping watchdog notification", "error", err)
+		}
+		return err
+	})
+	defer stopWatchdog()
+
+	err = serveUntilSignal(server, serveFuncs, options.DrainTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// adoptSystemdListeners serves the sockets passed via systemd socket
+// activation instead of --listen. Stream sockets named "http" serve plain
+// HTTP, "https" serves HTTPS, "acme-http" answers ACME HTTP-01 challenges and
+// all others follow --http. Datagram sockets serve HTTP/3.
+func adoptSystemdListeners(server *ablage.Server, options *config.Config, listeners []systemd.Listener) ([]string, []func() error, error) {
+	listeningOn := []string{}
+	serveFuncs := []func() error{}
+
+	for _, listener := range listeners {
+		if listener.PacketConn != nil {
+			if !options.HTTP3Mode {
+				return nil, nil, fmt.Errorf("Datagram socket '%s' passed by systemd requires --http3.", listener.Name)
+			}
+
+			serveFuncs = append(serveFuncs, func() error { return server.ServeHTTP3(listener.PacketConn) })
+			continue
+		}
+
+		listenAddress := config.ListenAddress{
+			Address: listener.Listener.Addr().String(),
+			Network: listener.Listener.Addr().Network(),
+			Scheme:  "https",
+		}
+		if listener.Name == "http" || (options.HttpMode && listener.Name != "https") {
+			listenAddress.Scheme = "http"
+		}
+
+		switch {
+		case listener.Name == "acme-http":
+			serveFuncs = append(serveFuncs, func() error { return server.ServeACMEHTTP(listener.Listener) })
+			continue
+		case listenAddress.IsTLS():
+			serveFuncs = append(serveFuncs, func() error { return server.ServeTLS(listener.Listener) })
+		default:
+			serveFuncs = append(serveFuncs, func() error { return server.ServeInsecure(listener.Listener) })
+		}
+
+		listeningOn = append(listeningOn, listenAddress.GetURLs(listener.Listener.Addr())...)
+	}
+
+	return listeningOn, serveFuncs, nil
+}
+
+// listen opens a listener for listenAddress. A Unix domain socket left behind
+// by a previous run is removed first, unless another process still accepts
+// connections on it.
+func listen(listenAddress config.ListenAddress) (net.Listener, error) {
+	if listenAddress.Network == "unix" {
+		info, err := os.Stat(listenAddress.Address)
+		if err == nil && info.Mode()&os.ModeSocket != 0 {
+			conn, err := net.Dial("unix", listenAddress.Address)
+			if err != nil {
+				_ = os.Remove(listenAddress.Address)
+			} else {
+				conn.Close()
+			}
+		}
+	}
+
+	return net.Listen(listenAddress.Network, listenAddress.Address)
+}
+
+// openListenAddresses opens a listener for every --listen address, plus the
+// UDP sockets for HTTP/3 and the ACME HTTP-01 listener if enabled.
+func openListenAddresses(server *ablage.Server, options *config.Config) ([]string, []func() error, error) {
+	listenAddresses, err := options.GetListenAddresses()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listeningOn := []string{}
+	serveFuncs := []func() error{}
+
+	for _, listenAddress := range listenAddresses {
+		listener, err := listen(listenAddress)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not listen on '%s': %v", listenAddress, err)
+		}
+
+		listeningOn = append(listeningOn, listenAddress.GetURLs(listener.Addr())...)
+
+		if !listenAddress.IsTLS() {
+			serveFuncs = append(serveFuncs, func() error { return server.ServeInsecure(listener) })
+			continue
+		}
+
+		serveFuncs = append(serveFuncs, func() error { return server.ServeTLS(listener) })
+
+		if options.HTTP3Mode {
+			network := strings.Replace(listenAddress.Network, "tcp", "udp", 1)
+			packetConn, err := net.ListenPacket(network, listener.Addr().String())
+			if err != nil {
+				return nil, nil, err
+			}
+
+			serveFuncs = append(serveFuncs, func() error { return server.ServeHTTP3(packetConn) })
+		}
+	}
+
+	if options.GetACMEMode() && options.ACMEHTTPPort > 0 {
+		acmeListener, err := net.Listen("tcp", fmt.Sprintf(":%d", options.ACMEHTTPPort))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		serveFuncs = append(serveFuncs, func() error { return server.ServeACMEHTTP(acmeListener) })
+	}
+
+	return listeningOn, serveFuncs, nil
+}
+
+func serveUntilSignal(server *ablage.Server, serveFuncs []func() error, drainTimeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	serverErrors := make(chan error, len(serveFuncs))
+	for _, serve := range serveFuncs {
+		go func() {
+			serverErrors <- serve()
+		}()
+	}
+
+	select {
+	case err := <-serverErrors:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("Webserver exited with error: %v", err)
+	case sig := <-signals:
+		slog.Info("Draining in-flight uploads", "signal", sig.String(), "timeout", drainTimeout)
+	}
+
+	err := systemd.Notify("STOPPING=1")
+	if err != nil {
+		slog.Warn("Could not notify systemd", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Info("Shutting down immediately", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		slog.Warn("Could not drain all connections, closed them", "error", err)
+	} else if err != nil {
+		return err
+	}
+
+	slog.Info("Shutdown complete")
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const acmeOrderLifetime time.Duration = time.Hour
+
+// acmeOrderLocationTransport remembers the URL of every ACME order it sees and
+// adds it as Location header to finalize responses that lack one. Boulder sends
+// the header, Pebble does not, but x/crypto/acme needs it to poll orders that
+// are still processing. It is only used for directories other than Let's
+// Encrypt.
+type acmeOrderLocationTransport struct {
+	mutex     sync.Mutex
+	orders    map[string]acmeOrder
+	transport http.RoundTripper
+}
+
+type acmeOrder struct {
+	createdAt time.Time
+	url       string
+}
+
+func (t *acmeOrderLocationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost {
+		return res, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	order, isFinalizeURL := t.orders[req.URL.String()]
+	if isFinalizeURL {
+		delete(t.orders, req.URL.String())
+		if res.Header.Get("Location") == "" {
+			res.Header.Set("Location", order.url)
+		}
+		return res, nil
+	}
+
+	if res.Header.Get("Location") == "" {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return res, nil
+	}
+
+	var newOrder struct {
+		Finalize string `json:"finalize"`
+	}
+	if json.Unmarshal(body, &newOrder) == nil && newOrder.Finalize != "" {
+		// Orders whose authorizations failed are never finalized.
+		now := time.Now()
+		for finalizeURL, order := range t.orders {
+			if now.Sub(order.createdAt) > acmeOrderLifetime {
+				delete(t.orders, finalizeURL)
+			}
+		}
+
+		t.orders[newOrder.Finalize] = acmeOrder{createdAt: now, url: res.Header.Get("Location")}
+	}
+
+	return res, nil
+}
+
+func (c *Config) GetACMEManager() *autocert.Manager {
+	return c.acmeManager
+}
+
+func (c *Config) initACME() error {
+	if !c.GetACMEMode() {
+		return nil
+	}
+
+	if c.HttpMode {
+		return fmt.Errorf("Cannot enable both http mode and ACME at the same time.")
+	}
+
+	if c.PathTLSCertFile != "" || c.PathTLSKeyFile != "" {
+		return fmt.Errorf("Cannot use a custom certificate and ACME at the same time.")
+	}
+
+	for i, domain := range c.ACMEDomains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			return fmt.Errorf("ACME domains must not be empty.")
+		}
+		c.ACMEDomains[i] = domain
+	}
+
+	if c.ACMEDirectoryURL == "" {
+		c.ACMEDirectoryURL = DefaultACMEDirectoryURL
+	}
+
+	if c.PathACMECacheFolder == "" {
+		c.PathACMECacheFolder = filepath.Join(c.PathStateFolder, DefaultNameACMECacheFolder)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.PathACMECARootFile != "" {
+		rootsPEM, err := os.ReadFile(c.PathACMECARootFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read ACME CA root file: %v", err)
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(rootsPEM) {
+			return fmt.Errorf("ACME CA root file '%s' contains no PEM encoded certificates", c.PathACMECARootFile)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if c.ACMEDirectoryURL != DefaultACMEDirectoryURL {
+		roundTripper = &acmeOrderLocationTransport{
+			orders:    map[string]acmeOrder{},
+			transport: transport,
+		}
+	}
+
+	c.acmeManager = &autocert.Manager{
+		Cache:      autocert.DirCache(c.PathACMECacheFolder),
+		Email:      c.ACMEEmail,
+		HostPolicy: autocert.HostWhitelist(c.ACMEDomains...),
+		Prompt:     autocert.AcceptTOS,
+		Client: &acme.Client{
+			DirectoryURL: c.ACMEDirectoryURL,
+			HTTPClient:   &http.Client{Transport: roundTripper},
+		},
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_acmeOrderLocationTransport(t *testing.T) {
+	responses := map[string]*http.Response{}
+	transport := &acmeOrderLocationTransport{
+		orders: map[string]acmeOrder{
+			"https://ca.test/finalize/stale": {createdAt: time.Now().Add(-2 * acmeOrderLifetime), url: "https://ca.test/order/stale"},
+		},
+		transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return responses[req.URL.String()], nil
+		}),
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		location     string
+		body         string
+		wantLocation string
+		wantOrders   int
+	}{
+		{
+			name:         "1",
+			url:          "https://ca.test/new-order",
+			location:     "https://ca.test/order/1",
+			body:         `{"status":"pending","finalize":"https://ca.test/finalize/1"}`,
+			wantLocation: "https://ca.test/order/1",
+			wantOrders:   1,
+		},
+		{
+			name:         "2",
+			url:          "https://ca.test/order/1",
+			body:         `{"status":"ready","finalize":"https://ca.test/finalize/1"}`,
+			wantLocation: "",
+			wantOrders:   1,
+		},
+		{
+			name:         "3",
+			url:          "https://ca.test/finalize/1",
+			body:         `{"status":"processing"}`,
+			wantLocation: "https://ca.test/order/1",
+			wantOrders:   0,
+		},
+		{
+			name:         "4",
+			url:          "https://ca.test/finalize/1",
+			body:         `{"status":"processing"}`,
+			wantLocation: "",
+			wantOrders:   0,
+		},
+		{
+			name:         "5",
+			url:          "https://ca.test/new-order",
+			location:     "https://ca.test/order/2",
+			body:         `{"status":"pending","finalize":"https://ca.test/finalize/2"}`,
+			wantLocation: "https://ca.test/order/2",
+			wantOrders:   1,
+		},
+		{
+			name:         "6",
+			url:          "https://ca.test/finalize/2",
+			location:     "https://ca.test/order/2",
+			body:         `{"status":"valid"}`,
+			wantLocation: "https://ca.test/order/2",
+			wantOrders:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+				Header:     http.Header{},
+				StatusCode: http.StatusOK,
+			}
+			if tt.location != "" {
+				res.Header.Set("Location", tt.location)
+			}
+			responses[tt.url] = res
+
+			req, err := http.NewRequest(http.MethodPost, tt.url, nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest() failed: %v", err)
+			}
+
+			got, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() failed: %v", err)
+			}
+
+			if location := got.Header.Get("Location"); location != tt.wantLocation {
+				t.Errorf("\nRoundTrip() Location\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantLocation, location)
+			}
+
+			body, _ := io.ReadAll(got.Body)
+			if string(body) != tt.body {
+				t.Errorf("\nRoundTrip() body\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.body, string(body))
+			}
+
+			if len(transport.orders) != tt.wantOrders {
+				t.Errorf("\nRoundTrip() orders\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantOrders, transport.orders)
+			}
+		})
+	}
+}
@@ -7,31 +7,75 @@ import (
 	"unicode/utf8"
 )
 
-func PrintStartupBanner() {
+// PrintStartupBanner prints the effective configuration and every URL in
+// listeningOn, the URLs of the listeners that were actually opened.
+func (c *Config) PrintStartupBanner(listeningOn []string) {
 	fmt.Println(getBanner() + "\n")
-	fmt.Printf("Basic Auth mode: %v\n", GetBasicAuthMode())
-	fmt.Printf("HTTP mode      : %v\n", GetHttpMode())
-	fmt.Printf("Readonly mode  : %v\n", GetReadonlyMode())
-	fmt.Printf("Sinkhole mode  : %v\n", GetSinkholeMode())
-	fmt.Printf("Path           : %s\n", GetPathDataFolder())
+	fmt.Printf("Basic Auth mode: %v\n", c.BasicAuthMode)
+	fmt.Printf("Dedup mode     : %v\n", c.DedupMode)
+	fmt.Printf("HTTP mode      : %v\n", c.HttpMode)
+	fmt.Printf("HTTP/3 mode    : %v\n", c.HTTP3Mode)
+	fmt.Printf("Metrics mode   : %v\n", c.MetricsMode)
+	fmt.Printf("Readonly mode  : %v\n", c.ReadonlyMode)
+	fmt.Printf("Search mode    : %v\n", c.SearchMode)
+	fmt.Printf("Sinkhole mode  : %v\n", c.SinkholeMode)
+	fmt.Printf("Trash mode     : %v\n", c.TrashMode)
+	fmt.Printf("Versioning mode: %v\n", c.VersioningMode)
+	fmt.Printf("Path           : %s\n", c.PathDataFolder)
+	fmt.Printf("On collision   : %s\n", c.CollisionPolicy)
 
-	if GetBasicAuthMode() {
-		fmt.Printf("Username       : %s\n", GetBasicAuthUsername())
-		fmt.Printf("Password       : %s\n", GetBasicAuthPassword())
+	if c.FileTTL > 0 {
+		fmt.Printf("File TTL       : %s\n", c.FileTTL)
 	}
 
-	if GetHttpMode() {
-		fmt.Printf("Listening on   : http://0.0.0.0:%d\n", GetPortToListenOn())
-	} else {
-		if pathTLSCertFile == "" || pathTLSKeyFile == "" {
-			fmt.Printf("TLS cert       : self-signed\n")
-			fmt.Printf("TLS key        : self-signed\n")
+	if c.BasicAuthMode {
+		fmt.Printf("Username       : %s\n", c.BasicAuthUsername)
+		fmt.Printf("Password       : %s\n", c.BasicAuthPassword)
+	}
+
+	if !c.HttpMode {
+		if c.GetACMEMode() {
+			fmt.Printf("TLS cert       : ACME (%s)\n", c.ACMEDirectoryURL)
+			fmt.Printf("ACME domains   : %s\n", strings.Join(c.ACMEDomains, ", "))
+			fmt.Printf("ACME cache     : %s\n", c.PathACMECacheFolder)
+			if c.ACMEHTTPPort > 0 {
+				fmt.Printf("ACME HTTP-01   : port %d on all interfaces\n", c.ACMEHTTPPort)
+			}
+		} else if c.CAMode {
+			fmt.Printf("TLS cert       : local CA (%s)\n", c.getPathCACertFile())
+			fmt.Printf("CA SHA-256     : %s\n", c.GetCAFingerprint())
+			fmt.Printf("CA key         : %s (keep it private)\n", c.getPathCAKeyFile())
+			if c.GetCAScope() != "" {
+				fmt.Printf("CA scope       : %s\n", c.GetCAScope())
+			}
+			for _, url := range listeningOn {
+				if strings.HasPrefix(url, "https://") {
+					fmt.Printf("CA download    : %s/ca.crt\n", url)
+					break
+				}
+			}
+		} else if c.PathTLSCertFile == "" || c.PathTLSKeyFile == "" {
+			fmt.Printf("TLS cert       : self-signed (%s)\n", c.getPathSelfSignedTLSCertFile())
+			fmt.Printf("TLS key        : self-signed (%s)\n", c.getPathSelfSignedTLSKeyFile())
+			fmt.Printf("TLS SHA-256    : %s\n", c.GetTLSFingerprint())
 		} else {
-			fmt.Printf("TLS cert       : %s\n", pathTLSCertFile)
-			fmt.Printf("TLS key        : %s\n", pathTLSKeyFile)
+			fmt.Printf("TLS cert       : %s\n", c.PathTLSCertFile)
+			fmt.Printf("TLS key        : %s\n", c.PathTLSKeyFile)
+			fmt.Printf("TLS SHA-256    : %s\n", c.GetTLSFingerprint())
 		}
+	}
+
+	if c.RedirectHTTPToHTTPS {
+		fmt.Printf("HTTP redirect  : to https on port %d\n", c.GetHTTPSPort())
+	}
+
+	metricsListenAddress, err := c.GetMetricsListenAddress()
+	if c.MetricsListenAddress != "" && err == nil {
+		fmt.Printf("Metrics on     : %s\n", metricsListenAddress)
+	}
 
-		fmt.Printf("Listening on   : https://0.0.0.0:%d\n", GetPortToListenOn())
+	for _, url := range listeningOn {
+		fmt.Printf("Listening on   : %s\n", url)
 	}
 
 	fmt.Println("")
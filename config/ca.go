@@ -0,0 +1,334 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certificateAuthority issues short-lived leaf certificates for every name
+// and address ablage is reached by, signed by a root CA that is persisted in
+// the state folder. The leaf keys never leave memory. A root CA created with
+// --ca-domain is name constrained to those domains and localhost, so its key
+// can't be abused to issue certificates for other domains.
+type certificateAuthority struct {
+	certificate    *x509.Certificate
+	certificatePEM []byte
+	dnsNames       []string
+	ipAddresses    []net.IP
+	key            *ecdsa.PrivateKey
+	leafs          map[string]*tls.Certificate
+	mutex          sync.Mutex
+}
+
+func (c *Config) GetCACertificate() []byte {
+	if c.ca == nil {
+		return nil
+	}
+
+	return c.ca.certificatePEM
+}
+
+// GetCAFingerprint returns the SHA-256 fingerprint of the root CA or an empty
+// string if CA mode is disabled.
+func (c *Config) GetCAFingerprint() string {
+	if c.ca == nil {
+		return ""
+	}
+
+	return getFingerprint(c.ca.certificate.Raw)
+}
+
+// GetCAScope returns the domains the root CA may issue certificates for or an
+// empty string if CA mode is disabled or the CA is not name constrained.
+func (c *Config) GetCAScope() string {
+	if c.ca == nil {
+		return ""
+	}
+
+	return strings.Join(c.ca.certificate.PermittedDNSDomains, ", ")
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Clients that connect
+// via IP address don't send a server name, so the local address of the
+// connection is used instead.
+func (c *Config) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c.ca == nil {
+		return nil, fmt.Errorf("CA mode is not enabled")
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" && hello.Conn != nil {
+		host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String())
+		if err == nil {
+			name = host
+		}
+	}
+
+	return c.ca.getLeafCertificate(name)
+}
+
+func (c *Config) getPathCACertFile() string {
+	return filepath.Join(c.PathStateFolder, DefaultNameCACertFile)
+}
+
+func (c *Config) getPathCAKeyFile() string {
+	return filepath.Join(c.PathStateFolder, DefaultNameCAKeyFile)
+}
+
+func (c *Config) initCA() error {
+	if !c.CAMode {
+		return nil
+	}
+
+	if c.HttpMode {
+		return fmt.Errorf("Cannot enable both http mode and CA mode at the same time.")
+	}
+
+	if c.GetACMEMode() {
+		return fmt.Errorf("Cannot enable both ACME and CA mode at the same time.")
+	}
+
+	if c.PathTLSCertFile != "" || c.PathTLSKeyFile != "" {
+		return fmt.Errorf("Cannot use a custom certificate and CA mode at the same time.")
+	}
+
+	caDomains := []string{}
+	for _, domain := range c.CADomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			caDomains = append(caDomains, domain)
+		}
+	}
+
+	certData, errCert := os.ReadFile(c.getPathCACertFile())
+	keyData, errKey := os.ReadFile(c.getPathCAKeyFile())
+	if errCert != nil || errKey != nil {
+		var err error
+
+		certData, keyData, err = generateCACertificate(caDomains)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(c.PathStateFolder, 0700)
+		if err != nil {
+			return fmt.Errorf("Could not create state folder '%s': %v", c.PathStateFolder, err)
+		}
+
+		err = os.WriteFile(c.getPathCAKeyFile(), keyData, 0600)
+		if err != nil {
+			return fmt.Errorf("Could not persist CA key: %v", err)
+		}
+
+		err = os.WriteFile(c.getPathCACertFile(), certData, 0644)
+		if err != nil {
+			return fmt.Errorf("Could not persist CA certificate: %v", err)
+		}
+	}
+
+	keyPair, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		return fmt.Errorf("Failed to load CA certificate or key from '%s': %v", c.PathStateFolder, err)
+	}
+
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("Failed to parse CA certificate: %v", err)
+	}
+
+	key, ok := keyPair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok || !certificate.IsCA {
+		return fmt.Errorf("'%s' is not an ablage CA certificate", c.getPathCACertFile())
+	}
+
+	dnsNames, ipAddresses := getLocalHostnamesAndIPs()
+
+	// Clients would reject certificates for names outside of the name
+	// constraints. Those are only set if the CA was created with --ca-domain,
+	// so hostnames outside of these domains are only served by address.
+	if len(certificate.PermittedDNSDomains) > 0 {
+		dnsNames = slices.DeleteFunc(dnsNames, func(dnsName string) bool {
+			return !isPermittedDNSName(certificate, dnsName)
+		})
+	}
+
+	for _, domain := range caDomains {
+		if len(certificate.PermittedDNSDomains) > 0 && !isPermittedDNSName(certificate, domain) {
+			c.Logger.Warn("The CA is not permitted to issue certificates for this domain, delete the CA to create a new one", "domain", domain, "path", c.getPathCACertFile())
+			continue
+		}
+
+		dnsNames = append(dnsNames, domain)
+	}
+
+	c.ca = &certificateAuthority{
+		certificate:    certificate,
+		certificatePEM: certData,
+		dnsNames:       dnsNames,
+		ipAddresses:    ipAddresses,
+		key:            key,
+		leafs:          map[string]*tls.Certificate{},
+	}
+
+	return nil
+}
+
+func (ca *certificateAuthority) getLeafCertificate(name string) (*tls.Certificate, error) {
+	if !ca.isServedName(name) {
+		name = "localhost"
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	leaf, ok := ca.leafs[name]
+	if ok && time.Now().Add(CALeafCertificateRenewBefore).Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+
+	leaf, err := ca.issueLeafCertificate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.leafs[name] = leaf
+
+	return leaf, nil
+}
+
+func (ca *certificateAuthority) isServedName(name string) bool {
+	ip := net.ParseIP(name)
+	if ip != nil {
+		return slices.ContainsFunc(ca.ipAddresses, ip.Equal)
+	}
+
+	return slices.Contains(ca.dnsNames, name)
+}
+
+func (ca *certificateAuthority) issueLeafCertificate(name string) (*tls.Certificate, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: name,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(CALeafCertificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	ip := net.ParseIP(name)
+	if ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, ca.certificate, &privateKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to issue leaf certificate for '%s': %v", name, err)
+	}
+
+	leaf, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{derBytes, ca.certificate.Raw},
+		Leaf:        leaf,
+		PrivateKey:  privateKey,
+	}, nil
+}
+
+// generateCACertificate creates a root CA. If domains are given, it is name
+// constrained to them and localhost. Addresses are never constrained, as the
+// addresses of a machine change too often.
+func generateCACertificate(domains []string) ([]byte, []byte, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commonName := "ablage local CA"
+	hostname, err := os.Hostname()
+	if err == nil && hostname != "" {
+		commonName += " (" + hostname + ")"
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{"ablage"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(CACertificateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}
+
+	if len(domains) > 0 {
+		template.PermittedDNSDomainsCritical = true
+		template.PermittedDNSDomains = append([]string{"localhost"}, domains...)
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create CA certificate: %v", err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	key, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to marshal EC private key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+
+	return cert, keyPEM, nil
+}
+
+// isPermittedDNSName reports whether the name constraints of the CA permit
+// name.
+func isPermittedDNSName(certificate *x509.Certificate, name string) bool {
+	for _, domain := range certificate.PermittedDNSDomains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func Test_certificateAuthority(t *testing.T) {
+	c := New()
+	c.CAMode = true
+	c.CADomains = []string{"files.example.internal"}
+	c.PathDataFolder = filepath.Join(t.TempDir(), "data")
+
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(c.GetCACertificate())
+
+	tests := []struct {
+		name       string
+		serverName string
+		wantName   string
+	}{
+		{
+			name:       "1",
+			serverName: "localhost",
+			wantName:   "localhost",
+		},
+		{
+			name:       "2",
+			serverName: "Files.Example.Internal.",
+			wantName:   "files.example.internal",
+		},
+		{
+			name:       "3",
+			serverName: "127.0.0.1",
+			wantName:   "127.0.0.1",
+		},
+		{
+			name:       "4",
+			serverName: "evil.example.com",
+			wantName:   "localhost",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if err != nil {
+				t.Fatalf("\nGetCertificate()\nname: %v\nerr:  %v", tt.name, err)
+			}
+
+			_, err = leaf.Leaf.Verify(x509.VerifyOptions{DNSName: tt.wantName, Roots: roots})
+			if err != nil {
+				t.Errorf("\nGetCertificate()\nname: %v\nwant: valid certificate for %v\ngot:  %v", tt.name, tt.wantName, err)
+			}
+		})
+	}
+
+	// The CA key can't be abused for names outside of the name constraints.
+	leaf, err := c.ca.issueLeafCertificate("evil.example.com")
+	if err != nil {
+		t.Fatalf("issueLeafCertificate() failed: %v", err)
+	}
+
+	_, err = leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "evil.example.com", Roots: roots})
+	if err == nil {
+		t.Errorf("\nVerify()\nwant: name constraint error\ngot:  valid certificate for evil.example.com")
+	}
+
+	again := New()
+	again.CAMode = true
+	again.CADomains = []string{"files.example.internal", "other.example.internal"}
+	again.Logger = slog.New(slog.DiscardHandler)
+	again.PathDataFolder = c.PathDataFolder
+
+	err = again.Init()
+	if err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+
+	if again.GetCAFingerprint() != c.GetCAFingerprint() {
+		t.Errorf("\nGetCAFingerprint()\nwant: %v\ngot:  %v", c.GetCAFingerprint(), again.GetCAFingerprint())
+	}
+
+	if again.ca.isServedName("other.example.internal") {
+		t.Errorf("\nisServedName()\nwant: false for a name outside of the name constraints\ngot:  true")
+	}
+
+	if got := again.GetCAScope(); got != "localhost, files.example.internal" {
+		t.Errorf("\nGetCAScope()\nwant: %v\ngot:  %v", "localhost, files.example.internal", got)
+	}
+
+	// Without --ca-domain, the CA is not name constrained, so it keeps working
+	// on other machines sharing the state folder.
+	unconstrained := New()
+	unconstrained.CAMode = true
+	unconstrained.Logger = slog.New(slog.DiscardHandler)
+	unconstrained.PathDataFolder = filepath.Join(t.TempDir(), "data")
+
+	err = unconstrained.Init()
+	if err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+
+	if got := unconstrained.GetCAScope(); got != "" {
+		t.Errorf("\nGetCAScope()\nwant: %v\ngot:  %v", "", got)
+	}
+
+	roots = x509.NewCertPool()
+	roots.AppendCertsFromPEM(unconstrained.GetCACertificate())
+
+	leaf, err = unconstrained.ca.issueLeafCertificate("other-host.example.internal")
+	if err != nil {
+		t.Fatalf("issueLeafCertificate() failed: %v", err)
+	}
+
+	_, err = leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "other-host.example.internal", Roots: roots})
+	if err != nil {
+		t.Errorf("\nVerify()\nwant: valid certificate for other-host.example.internal\ngot:  %v", err)
+	}
+}
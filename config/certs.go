@@ -4,36 +4,125 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
-var selfSignedTLSCertificate []byte = []byte{}
-var selfSignedTLSKey []byte = []byte{}
+func (c *Config) GetTLSCertificate() []byte {
+	return c.tlsCertificate
+}
+
+// GetTLSFingerprint returns the SHA-256 fingerprint of the leaf certificate in
+// the colon separated notation browsers use, or an empty string if there is
+// no certificate.
+func (c *Config) GetTLSFingerprint() string {
+	block, _ := pem.Decode(c.tlsCertificate)
+	if block == nil {
+		return ""
+	}
 
-func GetTLSCertificate() []byte {
-	return selfSignedTLSCertificate
+	return getFingerprint(block.Bytes)
 }
 
-func GetTLSKey() []byte {
-	return selfSignedTLSKey
+func (c *Config) GetTLSKey() []byte {
+	return c.tlsKey
 }
 
-func generateSelfSignedTLSCertificate() error {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+func (c *Config) getPathSelfSignedTLSCertFile() string {
+	return filepath.Join(c.PathStateFolder, DefaultNameSelfSignedTLSCertFile)
+}
+
+func (c *Config) getPathSelfSignedTLSKeyFile() string {
+	return filepath.Join(c.PathStateFolder, DefaultNameSelfSignedTLSKeyFile)
+}
+
+func (c *Config) loadOrGenerateSelfSignedTLSCertificate() error {
+	dnsNames, ipAddresses := getLocalHostnamesAndIPs()
+
+	certData, errCert := os.ReadFile(c.getPathSelfSignedTLSCertFile())
+	keyData, errKey := os.ReadFile(c.getPathSelfSignedTLSKeyFile())
+	if errCert == nil && errKey == nil && isSelfSignedTLSCertificateReusable(certData, keyData, dnsNames, ipAddresses) {
+		c.tlsCertificate = certData
+		c.tlsKey = keyData
+		return nil
+	}
+
+	certData, keyData, err := generateSelfSignedTLSCertificate(dnsNames, ipAddresses)
 	if err != nil {
 		return err
 	}
 
+	err = os.MkdirAll(c.PathStateFolder, 0700)
+	if err != nil {
+		return fmt.Errorf("Could not create state folder '%s': %v", c.PathStateFolder, err)
+	}
+
+	err = os.WriteFile(c.getPathSelfSignedTLSKeyFile(), keyData, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not persist self-signed TLS key: %v", err)
+	}
+
+	err = os.WriteFile(c.getPathSelfSignedTLSCertFile(), certData, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not persist self-signed TLS certificate: %v", err)
+	}
+
+	c.tlsCertificate = certData
+	c.tlsKey = keyData
+
+	return nil
+}
+
+func (c *Config) loadOrGenerateTLSCertificate() error {
+	if c.HttpMode || c.GetACMEMode() || c.CAMode {
+		return nil
+	}
+
+	if c.PathTLSCertFile == "" || c.PathTLSKeyFile == "" {
+		return c.loadOrGenerateSelfSignedTLSCertificate()
+	}
+
+	_, err := tls.LoadX509KeyPair(c.PathTLSCertFile, c.PathTLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load TLS certificate or key: %w", err)
+	}
+
+	certData, err := os.ReadFile(c.PathTLSCertFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read TLS certificate file: %w", err)
+	}
+
+	keyData, err := os.ReadFile(c.PathTLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read TLS key file: %w", err)
+	}
+
+	c.tlsCertificate = certData
+	c.tlsKey = keyData
+
+	return nil
+}
+
+func generateSelfSignedTLSCertificate(dnsNames []string, ipAddresses []net.IP) ([]byte, []byte, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	template := x509.Certificate{
@@ -41,8 +130,10 @@ func generateSelfSignedTLSCertificate() error {
 		Subject: pkix.Name{
 			CommonName: "ablage",
 		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(SelfSignedTLSCertificateValidity),
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
@@ -50,48 +141,86 @@ func generateSelfSignedTLSCertificate() error {
 
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
 	if err != nil {
-		return fmt.Errorf("Failed to create new x509 certificate: %v", err)
+		return nil, nil, fmt.Errorf("Failed to create new x509 certificate: %v", err)
 	}
 
 	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	key, err := x509.MarshalECPrivateKey(privateKey)
 	if err != nil {
-		return fmt.Errorf("Failed to marshal EC private key: %v", err)
+		return nil, nil, fmt.Errorf("Failed to marshal EC private key: %v", err)
 	}
 	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
 
-	selfSignedTLSCertificate = cert
-	selfSignedTLSKey = keyPEM
-
-	return nil
+	return cert, keyPEM, nil
 }
 
-func loadOrGenerateTLSCertificate() error {
-	if GetHttpMode() {
-		return nil
+func getFingerprint(derBytes []byte) string {
+	sum := sha256.Sum256(derBytes)
+
+	hexBytes := make([]string, len(sum))
+	for i, b := range sum {
+		hexBytes[i] = fmt.Sprintf("%02X", b)
 	}
 
-	if pathTLSCertFile == "" || pathTLSKeyFile == "" {
-		return generateSelfSignedTLSCertificate()
+	return strings.Join(hexBytes, ":")
+}
+
+func getLocalHostnamesAndIPs() ([]string, []net.IP) {
+	dnsNames := []string{"localhost"}
+
+	hostname, err := os.Hostname()
+	hostname = strings.ToLower(hostname)
+	if err == nil && hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
 	}
 
-	_, err := tls.LoadX509KeyPair(pathTLSCertFile, pathTLSKeyFile)
+	ipAddresses := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	addresses, err := net.InterfaceAddrs()
 	if err != nil {
-		return fmt.Errorf("Failed to load TLS certificate or key: %w", err)
+		return dnsNames, ipAddresses
 	}
 
-	certData, err := os.ReadFile(pathTLSCertFile)
+	for _, address := range addresses {
+		ipNet, ok := address.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if !slices.ContainsFunc(ipAddresses, ipNet.IP.Equal) {
+			ipAddresses = append(ipAddresses, ipNet.IP)
+		}
+	}
+
+	return dnsNames, ipAddresses
+}
+
+func isSelfSignedTLSCertificateReusable(certData []byte, keyData []byte, dnsNames []string, ipAddresses []net.IP) bool {
+	keyPair, err := tls.X509KeyPair(certData, keyData)
 	if err != nil {
-		return fmt.Errorf("Failed to read TLS certificate file: %w", err)
+		return false
 	}
 
-	keyData, err := os.ReadFile(pathTLSKeyFile)
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
 	if err != nil {
-		return fmt.Errorf("Failed to read TLS key file: %w", err)
+		return false
 	}
 
-	selfSignedTLSCertificate = certData
-	selfSignedTLSKey = keyData
+	if time.Now().Add(SelfSignedTLSCertificateRenewBefore).After(cert.NotAfter) {
+		return false
+	}
 
-	return nil
+	for _, dnsName := range dnsNames {
+		if !slices.Contains(cert.DNSNames, dnsName) {
+			return false
+		}
+	}
+
+	for _, ipAddress := range ipAddresses {
+		if !slices.ContainsFunc(cert.IPAddresses, ipAddress.Equal) {
+			return false
+		}
+	}
+
+	return true
 }
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_isSelfSignedTLSCertificateReusable(t *testing.T) {
+	dnsNames := []string{"localhost", "ablage-host"}
+	ipAddresses := []net.IP{net.IPv4(127, 0, 0, 1), net.ParseIP("192.0.2.10")}
+
+	certData, keyData, err := generateSelfSignedTLSCertificate(dnsNames, ipAddresses)
+	if err != nil {
+		t.Fatalf("generateSelfSignedTLSCertificate() failed: %v", err)
+	}
+
+	_, otherKeyData, err := generateSelfSignedTLSCertificate(dnsNames, ipAddresses)
+	if err != nil {
+		t.Fatalf("generateSelfSignedTLSCertificate() failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		certData    []byte
+		keyData     []byte
+		dnsNames    []string
+		ipAddresses []net.IP
+		want        bool
+	}{
+		{
+			name:        "1",
+			certData:    certData,
+			keyData:     keyData,
+			dnsNames:    dnsNames,
+			ipAddresses: ipAddresses,
+			want:        true,
+		},
+		{
+			name:        "2",
+			certData:    certData,
+			keyData:     keyData,
+			dnsNames:    []string{"localhost"},
+			ipAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+			want:        true,
+		},
+		{
+			name:        "3",
+			certData:    certData,
+			keyData:     keyData,
+			dnsNames:    []string{"localhost", "renamed-host"},
+			ipAddresses: ipAddresses,
+			want:        false,
+		},
+		{
+			name:        "4",
+			certData:    certData,
+			keyData:     keyData,
+			dnsNames:    dnsNames,
+			ipAddresses: append(ipAddresses, net.ParseIP("198.51.100.7")),
+			want:        false,
+		},
+		{
+			name:        "5",
+			certData:    certData,
+			keyData:     otherKeyData,
+			dnsNames:    dnsNames,
+			ipAddresses: ipAddresses,
+			want:        false,
+		},
+		{
+			name:        "6",
+			certData:    []byte("garbage"),
+			keyData:     keyData,
+			dnsNames:    dnsNames,
+			ipAddresses: ipAddresses,
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSelfSignedTLSCertificateReusable(tt.certData, tt.keyData, tt.dnsNames, tt.ipAddresses); got != tt.want {
+				t.Errorf("\nisSelfSignedTLSCertificateReusable()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_getFingerprint(t *testing.T) {
+	want := "E3:B0:C4:42:98:FC:1C:14:9A:FB:F4:C8:99:6F:B9:24:27:AE:41:E4:64:9B:93:4C:A4:95:99:1B:78:52:B8:55"
+	if got := getFingerprint([]byte{}); got != want {
+		t.Errorf("\ngetFingerprint()\nwant: %v\ngot:  %v", want, got)
+	}
+}
@@ -2,36 +2,320 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
+const CACertificateValidity time.Duration = 10 * 365 * 24 * time.Hour
+const CALeafCertificateRenewBefore time.Duration = 2 * 24 * time.Hour
+const CALeafCertificateValidity time.Duration = 7 * 24 * time.Hour
+const CollisionPolicyAutoRename string = "auto-rename"
+const CollisionPolicyKeepBoth string = "keep-both"
+const CollisionPolicyOverwrite string = "overwrite"
+const CollisionPolicyReject string = "reject"
+const DefaultACMEDirectoryURL string = acme.LetsEncryptURL
+const DefaultACMEHTTPPort int = 80
 const DefaultBasicAuthUsername string = "ablage"
+const DefaultDrainTimeout time.Duration = 30 * time.Second
+const DefaultHTTP2MaxConcurrentStreams int = 100
+const DefaultHealthMinFreeDiskSpace int64 = 100 * 1024 * 1024
+const DefaultLogFormat string = "text"
+const DefaultLogLevel string = "info"
+const DefaultNameACMECacheFolder string = "acme"
+const DefaultNameCACertFile string = "ca.crt"
+const DefaultNameCAKeyFile string = "ca.key"
 const DefaultNameDataFolder string = "data"
+const DefaultNameIndexFolder string = ".index"
+const DefaultNameMetadataFolder string = ".metadata"
+const DefaultNameObjectsFolder string = ".objects"
+const DefaultNameSelfSignedTLSCertFile string = "selfsigned.crt"
+const DefaultNameSelfSignedTLSKeyFile string = "selfsigned.key"
+const DefaultNameStateFolder string = "state"
+const DefaultNameTrashFolder string = ".trash"
 const DefaultNameUploadFolder string = ".upload"
+const DefaultNameVersionsFolder string = ".versions"
 const DefaultPortToListenOn int = 13692
+const DefaultTrashRetention time.Duration = 7 * 24 * time.Hour
+const DefaultVersionsToKeep int = 5
 const LengthOfRandomBasicAuthPassword int = 16
+const SelfSignedTLSCertificateRenewBefore time.Duration = 30 * 24 * time.Hour
+const SelfSignedTLSCertificateValidity time.Duration = 365 * 24 * time.Hour
 const VersionString string = "1.2"
 
-var randomBasicAuthPassword string = generateRandomPassword()
+// Config holds everything a single ablage instance needs to know. The zero
+// value is usable: Init fills in defaults for every field that was left empty.
+type Config struct {
+	ACMEDirectoryURL          string
+	ACMEDomains               []string
+	ACMEEmail                 string
+	ACMEHTTPPort              int
+	BLAKE3Mode                bool
+	BasicAuthMode             bool
+	BasicAuthPassword         string
+	BasicAuthUsername         string
+	CADomains                 []string
+	CAMode                    bool
+	CollisionPolicy           string
+	DedupMode                 bool
+	DrainTimeout              time.Duration
+	FileTTL                   time.Duration
+	HTTP2MaxConcurrentStreams int
+	HTTP3Mode                 bool
+	HealthMinFreeDiskSpace    int64
+	HttpMode                  bool
+	ListenAddresses           []string
+	LogFormat                 string
+	LogLevel                  string
+	LogTLSErrors              bool
+	Logger                    *slog.Logger
+	MetricsListenAddress      string
+	MetricsMode               bool
+	PathACMECacheFolder       string
+	PathACMECARootFile        string
+	PathDataFolder            string
+	PathStateFolder           string
+	PathTLSCertFile           string
+	PathTLSKeyFile            string
+	PortToListenOn            int
+	QuotaMaxFileSize          int64
+	QuotaMinFreeDiskSpace     int64
+	QuotaPerUser              int64
+	QuotaTotal                int64
+	ReadonlyMode              bool
+	RedirectHTTPToHTTPS       bool
+	SearchMode                bool
+	SinkholeMode              bool
+	TrashMode                 bool
+	TrashRetention            time.Duration
+	TrustedProxies            []string
+	VersioningMode            bool
+	VersionsToKeep            int
+
+	acmeManager      *autocert.Manager
+	ca               *certificateAuthority
+	tlsCertificate   []byte
+	tlsKey           []byte
+	trustUnixProxies bool
+	trustedProxies   []netip.Prefix
+}
+
+func New() *Config {
+	return &Config{
+		ACMEDirectoryURL:          DefaultACMEDirectoryURL,
+		BasicAuthUsername:         DefaultBasicAuthUsername,
+		DrainTimeout:              DefaultDrainTimeout,
+		HTTP2MaxConcurrentStreams: DefaultHTTP2MaxConcurrentStreams,
+		HealthMinFreeDiskSpace:    DefaultHealthMinFreeDiskSpace,
+		LogFormat:                 DefaultLogFormat,
+		LogLevel:                  DefaultLogLevel,
+		PortToListenOn:            DefaultPortToListenOn,
+		TrashRetention:            DefaultTrashRetention,
+		VersionsToKeep:            DefaultVersionsToKeep,
+	}
+}
+
+func (c *Config) Init() error {
+	if c.PathDataFolder == "" {
+		defaultPathDataFolder, err := getDefaultPathDataFolder()
+		if err != nil {
+			return err
+		}
+		c.PathDataFolder = defaultPathDataFolder
+	}
+
+	if c.PathStateFolder == "" {
+		c.PathStateFolder = filepath.Join(filepath.Dir(c.PathDataFolder), DefaultNameStateFolder)
+	}
+
+	if c.BasicAuthUsername == "" {
+		c.BasicAuthUsername = DefaultBasicAuthUsername
+	}
+
+	if len(c.BasicAuthPassword) < 1 || len(c.BasicAuthPassword) > 128 {
+		c.BasicAuthPassword = generateRandomPassword()
+	}
+
+	if c.PortToListenOn == 0 {
+		c.PortToListenOn = DefaultPortToListenOn
+	}
+
+	if c.PortToListenOn < 1 || c.PortToListenOn > 65535 {
+		return fmt.Errorf("The port must be between 1 and 65535 (both ports included).")
+	}
+
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = DefaultDrainTimeout
+	}
+
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("The drain timeout must not be negative.")
+	}
+
+	if c.FileTTL < 0 {
+		return fmt.Errorf("The file TTL must not be negative.")
+	}
 
-func Init() error {
-	err := gatherDefaultPaths()
+	if c.TrashRetention == 0 {
+		c.TrashRetention = DefaultTrashRetention
+	}
+
+	if c.TrashRetention < 0 {
+		return fmt.Errorf("The trash retention must not be negative.")
+	}
+
+	// Re-uploads are meant to replace files in versioning mode, as the
+	// replaced files are kept as versions.
+	if c.CollisionPolicy == "" && c.VersioningMode {
+		c.CollisionPolicy = CollisionPolicyOverwrite
+	}
+
+	if c.CollisionPolicy == "" {
+		c.CollisionPolicy = CollisionPolicyReject
+	}
+
+	if !IsValidCollisionPolicy(c.CollisionPolicy) {
+		return fmt.Errorf("The collision policy must be one of reject, overwrite, auto-rename or keep-both.")
+	}
+
+	if c.VersionsToKeep == 0 {
+		c.VersionsToKeep = DefaultVersionsToKeep
+	}
+
+	if c.VersionsToKeep < 1 {
+		return fmt.Errorf("At least one version must be kept.")
+	}
+
+	if c.HTTP2MaxConcurrentStreams == 0 {
+		c.HTTP2MaxConcurrentStreams = DefaultHTTP2MaxConcurrentStreams
+	}
+
+	if c.HTTP2MaxConcurrentStreams < 1 {
+		return fmt.Errorf("The maximum number of concurrent HTTP/2 streams must be at least 1.")
+	}
+
+	if c.HealthMinFreeDiskSpace < 0 {
+		return fmt.Errorf("The minimum free disk space must not be negative.")
+	}
+
+	if c.QuotaMaxFileSize < 0 || c.QuotaMinFreeDiskSpace < 0 || c.QuotaPerUser < 0 || c.QuotaTotal < 0 {
+		return fmt.Errorf("Quotas and size limits must not be negative.")
+	}
+
+	if c.HttpMode && c.HTTP3Mode {
+		return fmt.Errorf("Cannot enable both http mode and HTTP/3 at the same time.")
+	}
+
+	if c.ReadonlyMode && c.SinkholeMode {
+		return fmt.Errorf("Cannot enable both readonly and sinkhole modes at the same time.")
+	}
+
+	err := c.initLogger()
 	if err != nil {
 		return err
 	}
 
-	err = parseFlags()
+	err = c.initListenAddresses()
 	if err != nil {
 		return err
 	}
 
-	if GetReadonlyMode() && GetSinkholeMode() {
-		return fmt.Errorf("Cannot enable both readonly and sinkhole modes at the same time.")
+	err = c.initTrustedProxies()
+	if err != nil {
+		return err
+	}
+
+	err = c.initMetrics()
+	if err != nil {
+		return err
 	}
 
-	err = loadOrGenerateTLSCertificate()
+	err = c.initACME()
 	if err != nil {
 		return err
 	}
 
+	err = c.initCA()
+	if err != nil {
+		return err
+	}
+
+	return c.loadOrGenerateTLSCertificate()
+}
+
+func (c *Config) GetACMEMode() bool {
+	return len(c.ACMEDomains) > 0
+}
+
+func (c *Config) GetPathIndexFolder() string {
+	return filepath.Join(c.PathDataFolder, DefaultNameIndexFolder)
+}
+
+func (c *Config) GetPathMetadataFolder() string {
+	return filepath.Join(c.PathDataFolder, DefaultNameMetadataFolder)
+}
+
+func (c *Config) GetPathObjectsFolder() string {
+	return filepath.Join(c.PathDataFolder, DefaultNameObjectsFolder)
+}
+
+func (c *Config) GetPathTrashFolder() string {
+	return filepath.Join(c.PathDataFolder, DefaultNameTrashFolder)
+}
+
+func (c *Config) GetPathUploadFolder() string {
+	return filepath.Join(c.PathDataFolder, DefaultNameUploadFolder)
+}
+
+func (c *Config) GetPathVersionsFolder() string {
+	return filepath.Join(c.PathDataFolder, DefaultNameVersionsFolder)
+}
+
+// initLogger creates the logger from LogFormat and LogLevel, unless a Logger
+// was passed in by a program embedding ablage.
+func (c *Config) initLogger() error {
+	if c.LogFormat == "" {
+		c.LogFormat = DefaultLogFormat
+	}
+
+	if c.LogLevel == "" {
+		c.LogLevel = DefaultLogLevel
+	}
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	if err != nil {
+		return fmt.Errorf("The log level must be one of debug, info, warn or error.")
+	}
+
+	if c.Logger != nil {
+		return nil
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	switch c.LogFormat {
+	case "json":
+		c.Logger = slog.New(slog.NewJSONHandler(os.Stderr, options))
+	case "text":
+		c.Logger = slog.New(slog.NewTextHandler(os.Stderr, options))
+	default:
+		return fmt.Errorf("The log format must be either text or json.")
+	}
+
 	return nil
 }
+
+func IsValidCollisionPolicy(policy string) bool {
+	switch policy {
+	case CollisionPolicyAutoRename, CollisionPolicyKeepBoth, CollisionPolicyOverwrite, CollisionPolicyReject:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+)
+
+func Test_Config_initLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		logFormat string
+		logLevel  string
+		wantErr   bool
+	}{
+		{
+			name:      "1",
+			logFormat: "",
+			logLevel:  "",
+			wantErr:   false,
+		},
+		{
+			name:      "2",
+			logFormat: "json",
+			logLevel:  "debug",
+			wantErr:   false,
+		},
+		{
+			name:      "3",
+			logFormat: "text",
+			logLevel:  "WARN",
+			wantErr:   false,
+		},
+		{
+			name:      "4",
+			logFormat: "xml",
+			logLevel:  "info",
+			wantErr:   true,
+		},
+		{
+			name:      "5",
+			logFormat: "text",
+			logLevel:  "verbose",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{LogFormat: tt.logFormat, LogLevel: tt.logLevel}
+
+			err := c.initLogger()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\ninitLogger()\nname: %v\nwantErr: %v\ngot:  %v", tt.name, tt.wantErr, err)
+				return
+			}
+
+			if !tt.wantErr && c.Logger == nil {
+				t.Errorf("\ninitLogger()\nname: %v\nwant: logger\ngot:  nil", tt.name)
+			}
+		})
+	}
+}
@@ -6,17 +6,11 @@ import (
 	"path/filepath"
 )
 
-var defaultPathDataFolder string = ""
-var defaultPathUploadFolder string = ""
-
-func gatherDefaultPaths() error {
+func getDefaultPathDataFolder() (string, error) {
 	execPath, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("Could not determine binary path: %v", err)
+		return "", fmt.Errorf("Could not determine binary path: %v", err)
 	}
 
-	defaultPathDataFolder = filepath.Join(filepath.Dir(execPath), DefaultNameDataFolder)
-	defaultPathUploadFolder = filepath.Join(defaultPathDataFolder, DefaultNameUploadFolder)
-
-	return nil
+	return filepath.Join(filepath.Dir(execPath), DefaultNameDataFolder), nil
 }
@@ -6,125 +6,150 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-var basicAuthMode bool = false
-var basicAuthPassword string = ""
-var httpMode bool = false
-var pathDataFolder string = ""
-var pathTLSCertFile string = ""
-var pathTLSKeyFile string = ""
-var pathUploadFolder string = ""
-var portToListenOn int = DefaultPortToListenOn
-var readonlyMode bool = false
-var sinkholeMode bool = false
-
-func GetBasicAuthMode() bool {
-	return basicAuthMode
+// byteSizeFlag parses human readable sizes like "100MB" into bytes.
+type byteSizeFlag struct {
+	value *int64
 }
 
-func GetBasicAuthPassword() string {
-	return basicAuthPassword
+// stringListFlag collects the values of a flag that may be given multiple
+// times, each time with one or more comma separated values.
+type stringListFlag struct {
+	values *[]string
 }
 
-func GetBasicAuthUsername() string {
-	return DefaultBasicAuthUsername
-}
+func (f byteSizeFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
 
-func GetHttpMode() bool {
-	return httpMode
+	return strconv.FormatInt(*f.value, 10)
 }
 
-func GetPathDataFolder() string {
-	return pathDataFolder
-}
+func (f byteSizeFlag) Set(value string) error {
+	size, err := ParseHumanReadableSize(value)
+	if err != nil {
+		return err
+	}
 
-func GetPathTLSCertFile() string {
-	return pathTLSCertFile
-}
+	*f.value = size
 
-func GetPathTLSKeyFile() string {
-	return pathTLSKeyFile
+	return nil
 }
 
-func GetPathUploadFolder() string {
-	return pathUploadFolder
-}
+func (f stringListFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
 
-func GetPortToListenOn() int {
-	return portToListenOn
+	return strings.Join(*f.values, ",")
 }
 
-func GetReadonlyMode() bool {
-	return readonlyMode
-}
+func (f stringListFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		*f.values = append(*f.values, strings.TrimSpace(v))
+	}
 
-func GetSinkholeMode() bool {
-	return sinkholeMode
+	return nil
 }
 
-func generateRandomPassword() string {
-	b := make([]byte, LengthOfRandomBasicAuthPassword)
-	_, err := rand.Read(b)
+func ParseFlags(arguments []string) (*Config, error) {
+	c := New()
+
+	flags := flag.NewFlagSet("ablage", flag.ExitOnError)
+	flags.BoolVar(&c.BasicAuthMode, "auth", false, "Enable basic authentication.")
+	flags.BoolVar(&c.BLAKE3Mode, "blake3", false, "Compute BLAKE3 hashes of uploaded files in addition to SHA-256.")
+	flags.BoolVar(&c.CAMode, "ca", false, "Enable CA mode. ablage issues its own certificates from a local root CA.")
+	flags.BoolVar(&c.DedupMode, "dedup", false, "Enable dedup mode. Uploaded files with the same content are stored only once.")
+	flags.BoolVar(&c.HTTP3Mode, "http3", false, "Enable HTTP/3 (QUIC) on the same port via UDP.")
+	flags.BoolVar(&c.HttpMode, "http", false, "Enable http mode. Nothing will be encrypted.")
+	flags.BoolVar(&c.LogTLSErrors, "log-tls-errors", false, "Log TLS handshake errors at debug level instead of dropping them.")
+	flags.BoolVar(&c.MetricsMode, "metrics", false, "Enable Prometheus metrics on /metrics.")
+	flags.BoolVar(&c.RedirectHTTPToHTTPS, "redirect-http", false, "Redirect requests on http listen addresses to the first https listen address.")
+	flags.BoolVar(&c.ReadonlyMode, "readonly", false, "Enable readonly mode. No files can be uploaded or deleted.")
+	flags.BoolVar(&c.SearchMode, "search", false, "Enable search mode. The text of plain text, Markdown, source code and PDF files is indexed for full-text search.")
+	flags.BoolVar(&c.SinkholeMode, "sinkhole", false, "Enable sinkhole mode. Existing files won't be visible.")
+	flags.BoolVar(&c.TrashMode, "trash", false, "Enable trash mode. Deleted files are moved to the trash and can be restored.")
+	flags.BoolVar(&c.VersioningMode, "versioning", false, "Enable versioning mode. Re-uploads replace files and keep the previous versions.")
+	flags.IntVar(&c.ACMEHTTPPort, "acme-http-port", DefaultACMEHTTPPort, "Set port to answer ACME HTTP-01 challenges on (0 disables HTTP-01).")
+	flags.IntVar(&c.HTTP2MaxConcurrentStreams, "http2-max-streams", DefaultHTTP2MaxConcurrentStreams, "Set maximum number of concurrent HTTP/2 and HTTP/3 streams per connection.")
+	flags.IntVar(&c.PortToListenOn, "port", DefaultPortToListenOn, "Set Port to listen on.")
+	flags.IntVar(&c.VersionsToKeep, "keep-versions", DefaultVersionsToKeep, "Set how many previous versions of a file are kept in versioning mode.")
+	flags.DurationVar(&c.DrainTimeout, "drain-timeout", DefaultDrainTimeout, "Set how long to wait for in-flight uploads on shutdown.")
+	flags.DurationVar(&c.TrashRetention, "trash-retention", DefaultTrashRetention, "Set how long deleted files are kept in the trash.")
+	flags.DurationVar(&c.FileTTL, "ttl", 0, "Delete files this long after they were uploaded, e.g. 168h (default is to keep them forever).")
+	flags.StringVar(&c.ACMEDirectoryURL, "acme-directory", DefaultACMEDirectoryURL, "Set ACME directory URL.")
+	flags.StringVar(&c.ACMEEmail, "acme-email", "", "Set contact email for the ACME account.")
+	flags.StringVar(&c.PathACMECacheFolder, "acme-cache", "", "Set path to the ACME certificate cache (default is 'acme' in the state folder).")
+	flags.StringVar(&c.PathACMECARootFile, "acme-ca-root", "", "Trust the CA certificates in this PEM file when talking to the ACME directory.")
+	flags.Var(stringListFlag{values: &c.ACMEDomains}, "acme-domain", "Request certificates via ACME for this domain (repeatable or comma separated).")
+	flags.Var(byteSizeFlag{value: &c.HealthMinFreeDiskSpace}, "health-min-free", "Report not ready on /readyz below this much free disk space, e.g. 1GB (default is 100MB, 0 disables the check).")
+	flags.Var(byteSizeFlag{value: &c.QuotaMaxFileSize}, "max-file-size", "Reject uploaded files larger than this, e.g. 4GB (default is no limit).")
+	flags.Var(byteSizeFlag{value: &c.QuotaMinFreeDiskSpace}, "min-free", "Reject uploads that would leave less than this much free disk space, e.g. 1GB (default is no reserve).")
+	flags.Var(byteSizeFlag{value: &c.QuotaPerUser}, "quota-per-user", "Limit the total size of the files uploaded by each user, e.g. 10GB (default is no limit).")
+	flags.Var(byteSizeFlag{value: &c.QuotaTotal}, "quota", "Limit the total size of all files in the data folder, e.g. 100GB (default is no limit).")
+	flags.Var(stringListFlag{values: &c.ListenAddresses}, "listen", "Listen on this address, e.g. https://[::1]:13692, http://0.0.0.0:8080 or unix:/run/ablage.sock (repeatable or comma separated, default is all interfaces on --port).")
+	flags.Var(stringListFlag{values: &c.CADomains}, "ca-domain", "Issue CA mode certificates for this additional domain, a new CA is name constrained to these domains (repeatable or comma separated).")
+	flags.Var(stringListFlag{values: &c.TrustedProxies}, "trusted-proxy", "Use X-Forwarded-For on requests from this proxy address or network, e.g. 10.0.0.0/8, or unix for Unix domain sockets (repeatable or comma separated).")
+	flags.StringVar(&c.CollisionPolicy, "collision", "", "Set what happens when an uploaded file exists, one of reject, overwrite, auto-rename or keep-both (default is reject, or overwrite in versioning mode).")
+	flags.StringVar(&c.LogFormat, "log-format", DefaultLogFormat, "Set log format, either text or json.")
+	flags.StringVar(&c.LogLevel, "log-level", DefaultLogLevel, "Set log level, one of debug, info, warn or error.")
+	flags.StringVar(&c.MetricsListenAddress, "metrics-listen", "", "Serve Prometheus metrics on this address instead of the main listeners, e.g. 127.0.0.1:9100 (implies --metrics).")
+	flags.StringVar(&c.BasicAuthPassword, "password", "", "Set password for basic authentication (or let ablage generate a random one).")
+	flags.StringVar(&c.PathDataFolder, "path", "", "Set path to data folder (default is 'data' in the same directory as ablage).")
+	flags.StringVar(&c.PathStateFolder, "state", "", "Set path to the state folder for certificates (default is 'state' next to the data folder).")
+	flags.StringVar(&c.PathTLSCertFile, "cert", "", "TLS cert file")
+	flags.StringVar(&c.PathTLSKeyFile, "key", "", "TLS key file")
+
+	err := flags.Parse(arguments)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return base64.RawURLEncoding.EncodeToString(b)[:LengthOfRandomBasicAuthPassword]
-}
-
-func parseFlags() error {
-	flag.BoolVar(&basicAuthMode, "auth", false, "Enable basic authentication.")
-	flag.BoolVar(&httpMode, "http", false, "Enable http mode. Nothing will be encrypted.")
-	flag.BoolVar(&readonlyMode, "readonly", false, "Enable readonly mode. No files can be uploaded or deleted.")
-	flag.BoolVar(&sinkholeMode, "sinkhole", false, "Enable sinkhole mode. Existing files won't be visible.")
-	flag.IntVar(&portToListenOn, "port", DefaultPortToListenOn, "Set Port to listen on.")
-	flag.StringVar(&basicAuthPassword, "password", "", "Set password for basic authentication (or let ablage generate a random one).")
-	flag.StringVar(&pathDataFolder, "path", "", "Set path to data folder (default is 'data' in the same directory as ablage).")
-	flag.StringVar(&pathTLSCertFile, "cert", "", "TLS cert file")
-	flag.StringVar(&pathTLSKeyFile, "key", "", "TLS key file")
-
-	flag.Parse()
 
-	parseFlagValueBasicAuthPassword()
-
-	err := parseFlagValuePortToListenOn()
+	err = parseFlagValuePortToListenOn(c.PortToListenOn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	parseFlagValuePathDataFolder()
+	err = parseFlagValueACMEHTTPPort(c.ACMEHTTPPort)
+	if err != nil {
+		return nil, err
+	}
 
-	err = parseFlagValuePathTLSCertFile()
+	err = parseFlagValuePathTLSCertFile(c.PathTLSCertFile, c.PathTLSKeyFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = parseFlagValuePathTLSKeyFile()
+	err = parseFlagValuePathTLSKeyFile(c.PathTLSKeyFile, c.PathTLSCertFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return c, nil
 }
 
-func parseFlagValueBasicAuthPassword() {
-	if len(basicAuthPassword) < 1 || len(basicAuthPassword) > 128 {
-		basicAuthPassword = generateRandomPassword()
+func generateRandomPassword() string {
+	b := make([]byte, LengthOfRandomBasicAuthPassword)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
 	}
+	return base64.RawURLEncoding.EncodeToString(b)[:LengthOfRandomBasicAuthPassword]
 }
 
-func parseFlagValuePathDataFolder() {
-	if pathDataFolder == "" {
-		pathDataFolder = defaultPathDataFolder
-		pathUploadFolder = defaultPathUploadFolder
-		return
+func parseFlagValueACMEHTTPPort(acmeHTTPPort int) error {
+	if acmeHTTPPort < 0 || acmeHTTPPort > 65535 {
+		return fmt.Errorf("The ACME HTTP port must be between 0 and 65535 (both ports included).")
 	}
 
-	pathUploadFolder = filepath.Join(pathDataFolder, DefaultNameUploadFolder)
+	return nil
 }
 
-func parseFlagValuePortToListenOn() error {
+func parseFlagValuePortToListenOn(portToListenOn int) error {
 	if portToListenOn < 1 || portToListenOn > 65535 {
 		return fmt.Errorf("The port must be between 1 and 65535 (both ports included).")
 	}
@@ -132,7 +157,7 @@ func parseFlagValuePortToListenOn() error {
 	return nil
 }
 
-func parseFlagValuePathTLSCertFile() error {
+func parseFlagValuePathTLSCertFile(pathTLSCertFile string, pathTLSKeyFile string) error {
 	if pathTLSCertFile == "" {
 		if pathTLSKeyFile != "" {
 			return fmt.Errorf("Both a certificate and the corresponding key must be provided.")
@@ -147,14 +172,13 @@ func parseFlagValuePathTLSCertFile() error {
 	}
 
 	if info.IsDir() {
-		fmt.Fprintf(os.Stderr, "Error: Cert must be a file\n")
 		return fmt.Errorf("Cert must be a valid file.")
 	}
 
 	return nil
 }
 
-func parseFlagValuePathTLSKeyFile() error {
+func parseFlagValuePathTLSKeyFile(pathTLSKeyFile string, pathTLSCertFile string) error {
 	if pathTLSKeyFile == "" {
 		if pathTLSCertFile != "" {
 			return fmt.Errorf("Both a certificate and the corresponding key must be provided.")
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenAddress is a single address ablage listens on, e.g. parsed from
+// "https://[::1]:13692", "http://0.0.0.0:8080" or "unix:/run/ablage.sock".
+type ListenAddress struct {
+	Address string
+	Network string
+	Scheme  string
+}
+
+// GetURLs returns the URLs ablage is reachable by on boundAddress, the
+// address the listener for l was actually bound to. Wildcard addresses are
+// expanded to every local IP address of the matching family.
+func (l ListenAddress) GetURLs(boundAddress net.Addr) []string {
+	if l.Network == "unix" {
+		return []string{l.String()}
+	}
+
+	host, port, err := net.SplitHostPort(boundAddress.String())
+	if err != nil {
+		return []string{l.Scheme + "://" + boundAddress.String()}
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsUnspecified() {
+		return []string{l.Scheme + "://" + net.JoinHostPort(host, port)}
+	}
+
+	_, ipAddresses := getLocalHostnamesAndIPs()
+
+	urls := []string{}
+	for _, ipAddress := range ipAddresses {
+		if ip.To4() != nil && ipAddress.To4() == nil {
+			continue
+		}
+
+		urls = append(urls, l.Scheme+"://"+net.JoinHostPort(ipAddress.String(), port))
+	}
+
+	return urls
+}
+
+func (l ListenAddress) IsTLS() bool {
+	return l.Scheme == "https"
+}
+
+func (l ListenAddress) String() string {
+	if l.Network == "unix" {
+		return "unix:" + l.Address
+	}
+
+	return l.Scheme + "://" + l.Address
+}
+
+// GetHTTPSPort returns the port of the first HTTPS listen address, which is
+// the target of redirects from HTTP listeners.
+func (c *Config) GetHTTPSPort() int {
+	listenAddresses, err := c.GetListenAddresses()
+	if err != nil {
+		return c.PortToListenOn
+	}
+
+	for _, listenAddress := range listenAddresses {
+		if !listenAddress.IsTLS() {
+			continue
+		}
+
+		_, port, err := net.SplitHostPort(listenAddress.Address)
+		if err != nil {
+			continue
+		}
+
+		portNumber, err := strconv.Atoi(port)
+		if err == nil && portNumber > 0 {
+			return portNumber
+		}
+	}
+
+	return c.PortToListenOn
+}
+
+// GetListenAddresses parses ListenAddresses. Addresses without a scheme use
+// https, or http in http mode. Without any ListenAddresses, ablage listens on
+// PortToListenOn on all interfaces.
+func (c *Config) GetListenAddresses() ([]ListenAddress, error) {
+	defaultScheme := "https"
+	if c.HttpMode {
+		defaultScheme = "http"
+	}
+
+	if len(c.ListenAddresses) == 0 {
+		return []ListenAddress{
+			{
+				Address: fmt.Sprintf(":%d", c.PortToListenOn),
+				Network: "tcp",
+				Scheme:  defaultScheme,
+			},
+		}, nil
+	}
+
+	listenAddresses := make([]ListenAddress, 0, len(c.ListenAddresses))
+	for _, value := range c.ListenAddresses {
+		listenAddress, err := parseListenAddress(value, defaultScheme)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.HttpMode && listenAddress.IsTLS() {
+			return nil, fmt.Errorf("Cannot listen on '%s' in http mode.", value)
+		}
+
+		listenAddresses = append(listenAddresses, listenAddress)
+	}
+
+	return listenAddresses, nil
+}
+
+// GetMetricsListenAddress parses MetricsListenAddress. Metrics are always
+// served via plain HTTP.
+func (c *Config) GetMetricsListenAddress() (ListenAddress, error) {
+	listenAddress, err := parseListenAddress(c.MetricsListenAddress, "http")
+	if err != nil {
+		return ListenAddress{}, err
+	}
+
+	if listenAddress.IsTLS() {
+		return ListenAddress{}, fmt.Errorf("Metrics listen address '%s' must not use https.", c.MetricsListenAddress)
+	}
+
+	return listenAddress, nil
+}
+
+func (c *Config) initListenAddresses() error {
+	listenAddresses, err := c.GetListenAddresses()
+	if err != nil {
+		return err
+	}
+
+	hasTLSListenAddress := false
+	for _, listenAddress := range listenAddresses {
+		if listenAddress.IsTLS() {
+			hasTLSListenAddress = true
+		}
+	}
+
+	if c.RedirectHTTPToHTTPS && !hasTLSListenAddress {
+		return fmt.Errorf("Cannot redirect to https without an https listen address.")
+	}
+
+	return nil
+}
+
+func (c *Config) initMetrics() error {
+	if c.MetricsListenAddress == "" {
+		return nil
+	}
+
+	c.MetricsMode = true
+
+	_, err := c.GetMetricsListenAddress()
+	return err
+}
+
+func parseListenAddress(value string, defaultScheme string) (ListenAddress, error) {
+	value = strings.TrimSpace(value)
+
+	if path, isUnix := strings.CutPrefix(value, "unix:"); isUnix {
+		path = strings.TrimPrefix(path, "//")
+		if path == "" {
+			return ListenAddress{}, fmt.Errorf("Listen address '%s' is missing the socket path.", value)
+		}
+
+		return ListenAddress{Address: path, Network: "unix", Scheme: "http"}, nil
+	}
+
+	scheme := defaultScheme
+	address := value
+	if before, after, hasScheme := strings.Cut(value, "://"); hasScheme {
+		scheme = strings.ToLower(before)
+		address = after
+	}
+
+	if scheme != "http" && scheme != "https" {
+		return ListenAddress{}, fmt.Errorf("Listen address '%s' has an unsupported scheme, use http, https or unix.", value)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ListenAddress{}, fmt.Errorf("Listen address '%s' is invalid: %v", value, err)
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 0 || portNumber > 65535 {
+		return ListenAddress{}, fmt.Errorf("Listen address '%s' has an invalid port.", value)
+	}
+
+	// Go listens on both IPv4 and IPv6 for wildcard addresses with network
+	// "tcp", so literal IP addresses pin the address family.
+	network := "tcp"
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() != nil {
+		network = "tcp4"
+	} else if ip != nil {
+		network = "tcp6"
+	} else if strings.ContainsAny(host, "/ ") {
+		return ListenAddress{}, fmt.Errorf("Listen address '%s' has an invalid host.", value)
+	}
+
+	return ListenAddress{Address: net.JoinHostPort(host, port), Network: network, Scheme: scheme}, nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"testing"
+)
+
+func Test_parseListenAddress(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		defaultScheme string
+		want          ListenAddress
+		wantErr       bool
+	}{
+		{
+			name:          "1",
+			value:         "https://0.0.0.0:13692",
+			defaultScheme: "http",
+			want:          ListenAddress{Address: "0.0.0.0:13692", Network: "tcp4", Scheme: "https"},
+		},
+		{
+			name:          "2",
+			value:         "http://[::1]:8080",
+			defaultScheme: "https",
+			want:          ListenAddress{Address: "[::1]:8080", Network: "tcp6", Scheme: "http"},
+		},
+		{
+			name:          "3",
+			value:         ":13692",
+			defaultScheme: "https",
+			want:          ListenAddress{Address: ":13692", Network: "tcp", Scheme: "https"},
+		},
+		{
+			name:          "4",
+			value:         "unix:///run/ablage.sock",
+			defaultScheme: "https",
+			want:          ListenAddress{Address: "/run/ablage.sock", Network: "unix", Scheme: "http"},
+		},
+		{
+			name:          "5",
+			value:         "unix:ablage.sock",
+			defaultScheme: "https",
+			want:          ListenAddress{Address: "ablage.sock", Network: "unix", Scheme: "http"},
+		},
+		{
+			name:          "6",
+			value:         "ftp://0.0.0.0:21",
+			defaultScheme: "https",
+			wantErr:       true,
+		},
+		{
+			name:          "7",
+			value:         "https://::1:8080",
+			defaultScheme: "https",
+			wantErr:       true,
+		},
+		{
+			name:          "8",
+			value:         "https://localhost:70000",
+			defaultScheme: "https",
+			wantErr:       true,
+		},
+		{
+			name:          "9",
+			value:         "unix:",
+			defaultScheme: "https",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListenAddress(tt.value, tt.defaultScheme)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nparseListenAddress()\nname: %v\nwantErr: %v\ngot:  %v", tt.name, tt.wantErr, err)
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("\nparseListenAddress()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_Config_GetListenAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []ListenAddress
+		wantErr bool
+	}{
+		{
+			name:   "1",
+			config: Config{PortToListenOn: 13692},
+			want:   []ListenAddress{{Address: ":13692", Network: "tcp", Scheme: "https"}},
+		},
+		{
+			name:   "2",
+			config: Config{HttpMode: true, PortToListenOn: 8080},
+			want:   []ListenAddress{{Address: ":8080", Network: "tcp", Scheme: "http"}},
+		},
+		{
+			name:   "3",
+			config: Config{ListenAddresses: []string{"127.0.0.1:443", "http://[::]:80"}},
+			want: []ListenAddress{
+				{Address: "127.0.0.1:443", Network: "tcp4", Scheme: "https"},
+				{Address: "[::]:80", Network: "tcp6", Scheme: "http"},
+			},
+		},
+		{
+			name:    "4",
+			config:  Config{HttpMode: true, ListenAddresses: []string{"https://127.0.0.1:443"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.GetListenAddresses()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nGetListenAddresses()\nname: %v\nwantErr: %v\ngot:  %v", tt.name, tt.wantErr, err)
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("\nGetListenAddresses()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("\nGetListenAddresses()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// IsTrustedProxy reports whether address, the remote address of a connection
+// or an entry of X-Forwarded-For, belongs to a proxy passed via
+// TrustedProxies. Connections via Unix domain sockets have no address, they
+// are trusted if TrustedProxies contains "unix".
+func (c *Config) IsTrustedProxy(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	if host == "" || host == "@" {
+		return c.trustUnixProxies
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range c.trustedProxies {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Config) initTrustedProxies() error {
+	c.trustedProxies = nil
+	c.trustUnixProxies = false
+
+	for _, proxy := range c.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+
+		if proxy == "unix" {
+			c.trustUnixProxies = true
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			ip, errIP := netip.ParseAddr(proxy)
+			if errIP != nil {
+				return fmt.Errorf("Trusted proxies must be IP addresses, networks like 10.0.0.0/8 or unix, not '%s'.", proxy)
+			}
+			prefix = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+		}
+
+		c.trustedProxies = append(c.trustedProxies, prefix.Masked())
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"testing"
+)
+
+func Test_Config_IsTrustedProxy(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		address        string
+		want           bool
+		wantErr        bool
+	}{
+		{
+			name:    "1",
+			address: "127.0.0.1:1234",
+			want:    false,
+		},
+		{
+			name:           "2",
+			trustedProxies: []string{"127.0.0.1"},
+			address:        "127.0.0.1:1234",
+			want:           true,
+		},
+		{
+			name:           "3",
+			trustedProxies: []string{"10.0.0.0/8", "fd00::/8"},
+			address:        "[fd00::1]:1234",
+			want:           true,
+		},
+		{
+			name:           "4",
+			trustedProxies: []string{"10.0.0.0/8"},
+			address:        "::ffff:10.1.2.3",
+			want:           true,
+		},
+		{
+			name:           "5",
+			trustedProxies: []string{"10.0.0.0/8"},
+			address:        "unknown",
+			want:           false,
+		},
+		{
+			name:           "6",
+			trustedProxies: []string{"unix"},
+			address:        "@",
+			want:           true,
+		},
+		{
+			name:           "7",
+			trustedProxies: []string{"proxy.example.com"},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{TrustedProxies: tt.trustedProxies}
+
+			err := c.initTrustedProxies()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("\ninitTrustedProxies()\nname: %v\nwantErr: %v\ngot:  %v", tt.name, tt.wantErr, err)
+			}
+
+			if got := c.IsTrustedProxy(tt.address); got != tt.want {
+				t.Errorf("\nIsTrustedProxy()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
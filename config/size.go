@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseHumanReadableSize parses sizes like "512", "100KB", "1.5 GB" or
+// "2GiB". Units are powers of 1024, just like the sizes ablage prints.
+func ParseHumanReadableSize(value string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(value))
+
+	numberEnd := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numberEnd == -1 {
+		numberEnd = len(trimmed)
+	}
+
+	number, err := strconv.ParseFloat(trimmed[:numberEnd], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("'%s' is not a valid size.", value)
+	}
+
+	exponent := 0
+	switch unit := strings.TrimSpace(trimmed[numberEnd:]); unit {
+	case "", "B", "BYTE", "BYTES":
+	default:
+		prefix := strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+		exponent = strings.Index("KMGTPE", prefix) + 1
+		if len(prefix) != 1 || exponent == 0 {
+			return 0, fmt.Errorf("'%s' has an unknown unit, use B, KB, MB, GB, TB, PB or EB.", value)
+		}
+	}
+
+	size := number * math.Pow(1024, float64(exponent))
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("'%s' is too large.", value)
+	}
+
+	return int64(size), nil
+}
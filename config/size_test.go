@@ -0,0 +1,88 @@
+package config
+
+import (
+	"testing"
+)
+
+func Test_ParseHumanReadableSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{
+			name:  "1",
+			input: "512",
+			want:  512,
+		},
+		{
+			name:  "2",
+			input: "100KB",
+			want:  100 * 1024,
+		},
+		{
+			name:  "3",
+			input: "1.5 GB",
+			want:  1536 * 1024 * 1024,
+		},
+		{
+			name:  "4",
+			input: "2GiB",
+			want:  2 * 1024 * 1024 * 1024,
+		},
+		{
+			name:  "5",
+			input: "10 m",
+			want:  10 * 1024 * 1024,
+		},
+		{
+			name:  "6",
+			input: "42 Bytes",
+			want:  42,
+		},
+		{
+			name:  "7",
+			input: "0",
+			want:  0,
+		},
+		{
+			name:    "8",
+			input:   "",
+			wantErr: true,
+		},
+		{
+			name:    "9",
+			input:   "ten MB",
+			wantErr: true,
+		},
+		{
+			name:    "10",
+			input:   "10 XB",
+			wantErr: true,
+		},
+		{
+			name:    "11",
+			input:   "-1 MB",
+			wantErr: true,
+		},
+		{
+			name:    "12",
+			input:   "100000 EB",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHumanReadableSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nParseHumanReadableSize()\nname: %v\nwantErr: %v\ngot:  %v", tt.name, tt.wantErr, err)
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("\nParseHumanReadableSize()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DeduplicateFile replaces the file at path with a hard link to the object
+// with the same content, or makes the file that object if there is none yet.
+// Objects are stored in the objects folder and named after the SHA-256 hash of
+// their content, so every file with the same content shares a single copy on
+// disk. It reports whether the file shares its content with another file now.
+func (s *Storage) DeduplicateFile(path string, sha256 string) (bool, error) {
+	if !isValidSHA256(sha256) {
+		return false, fmt.Errorf("Could not deduplicate '%s': invalid hash '%s'", filepath.Base(path), sha256)
+	}
+
+	pathObject := s.getPathObjectFile(sha256)
+
+	err := os.MkdirAll(filepath.Dir(pathObject), 0755)
+	if err != nil {
+		return false, fmt.Errorf("Could not create objects folder of '%s': %v", sha256, err)
+	}
+
+	// The object may be deleted in between, once it is not used by other
+	// files anymore, so there is a second attempt.
+	for range 2 {
+		err = os.Link(path, pathObject)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return false, fmt.Errorf("Could not create object '%s': %v", sha256, err)
+		}
+
+		// Linking the object next to the file and renaming it over the file
+		// replaces the file atomically.
+		pathTemporaryLink := path + ".dedup-" + generateID()
+		err = os.Link(pathObject, pathTemporaryLink)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("Could not link object '%s': %v", sha256, err)
+		}
+
+		err = os.Rename(pathTemporaryLink, path)
+		if err != nil {
+			_ = os.Remove(pathTemporaryLink)
+			return false, fmt.Errorf("Could not link object '%s': %v", sha256, err)
+		}
+
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Could not create object '%s': %v", sha256, err)
+}
+
+// DeleteObjectIfUnused deletes the object with the given hash once the last
+// file referring to it was deleted or an upload that was deduplicated could
+// not be stored. Files without an object are ignored.
+func (s *Storage) DeleteObjectIfUnused(sha256 string) error {
+	if !isValidSHA256(sha256) {
+		return nil
+	}
+
+	pathObject := s.getPathObjectFile(sha256)
+
+	links, err := getLinkCount(pathObject)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not access object '%s': %v", sha256, err)
+	}
+
+	if links > 1 {
+		return nil
+	}
+
+	err = os.Remove(pathObject)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Could not delete object '%s': %v", sha256, err)
+	}
+
+	return nil
+}
+
+// DeleteUnusedObjects deletes the objects no file refers to anymore, e.g.
+// because a file was deleted outside of ablage.
+func (s *Storage) DeleteUnusedObjects() error {
+	return s.walkObjects(func(path string, info os.FileInfo, links uint64) error {
+		if links > 1 {
+			return nil
+		}
+
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("Could not delete object '%s': %v", info.Name(), err)
+		}
+
+		return nil
+	})
+}
+
+// GetDeduplicationSavings returns the number of bytes that would be used in
+// addition if every file had its own copy of its content.
+func (s *Storage) GetDeduplicationSavings() (int64, error) {
+	var savings int64
+
+	err := s.walkObjects(func(path string, info os.FileInfo, links uint64) error {
+		// One link is the object itself and one is the copy that is needed
+		// anyway.
+		if links > 2 {
+			savings += info.Size() * int64(links-2)
+		}
+
+		return nil
+	})
+
+	return savings, err
+}
+
+func (s *Storage) getPathObjectFile(sha256 string) string {
+	return filepath.Join(s.config.GetPathObjectsFolder(), sha256[:2], sha256)
+}
+
+// walkObjects calls fn for every object along with its number of hard links.
+func (s *Storage) walkObjects(fn func(path string, info os.FileInfo, links uint64) error) error {
+	folders, err := os.ReadDir(s.config.GetPathObjectsFolder())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not read objects folder '%s': %v", s.config.GetPathObjectsFolder(), err)
+	}
+
+	for _, folder := range folders {
+		if !folder.IsDir() {
+			continue
+		}
+
+		pathFolder := filepath.Join(s.config.GetPathObjectsFolder(), folder.Name())
+
+		entries, err := os.ReadDir(pathFolder)
+		if err != nil {
+			return fmt.Errorf("Could not read objects folder '%s': %v", pathFolder, err)
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || !isValidSHA256(entry.Name()) {
+				continue
+			}
+
+			path := filepath.Join(pathFolder, entry.Name())
+
+			info, err := entry.Info()
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Could not access object '%s': %v", entry.Name(), err)
+			}
+
+			links, err := getLinkCount(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Could not access object '%s': %v", entry.Name(), err)
+			}
+
+			err = fn(path, info, links)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkHardLinks makes sure the filesystem of folder supports hard links and
+// reports their number, which dedup mode relies on to tell whether an object
+// is still used.
+func checkHardLinks(folder string) error {
+	pathFile := filepath.Join(folder, ".check-"+generateID())
+	pathLink := pathFile + ".link"
+
+	err := os.WriteFile(pathFile, nil, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pathFile)
+
+	err = os.Link(pathFile, pathLink)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pathLink)
+
+	links, err := getLinkCount(pathFile)
+	if err != nil {
+		return err
+	}
+	if links != 2 {
+		return fmt.Errorf("expected 2 links to '%s', got %d", pathFile, links)
+	}
+
+	return nil
+}
+
+func isValidSHA256(sha256 string) bool {
+	if len(sha256) != 64 {
+		return false
+	}
+
+	for _, r := range sha256 {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package filesystem
+
+import "errors"
+
+// GetFreeDiskSpace is not supported on this platform.
+func GetFreeDiskSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package filesystem
+
+import "syscall"
+
+// GetFreeDiskSpace returns the number of bytes available to unprivileged
+// users on the filesystem that contains path.
+func GetFreeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package filesystem
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetFreeDiskSpace returns the number of bytes available to the current user
+// on the volume that contains path.
+func GetFreeDiskSpace(path string) (uint64, error) {
+	pathPointer, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var freeBytesAvailable uint64
+	result, _, err := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPointer)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)),
+		0,
+		0,
+	)
+	if result == 0 {
+		return 0, err
+	}
+
+	return freeBytesAvailable, nil
+}
@@ -1,50 +1,252 @@
 package filesystem
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"git.0x0001f346.de/andreas/ablage/config"
 )
 
-func Init() error {
-	err := createWriteableFolder(config.GetPathDataFolder())
+// ErrDataFolderUnavailable matches every *DataFolderError via errors.Is.
+var ErrDataFolderUnavailable = errors.New("data folder unavailable")
+
+// DataFolderError is returned when the data folder can't be accessed, e.g.
+// because a network share was unmounted. The storage is degraded until the
+// data folder can be accessed again.
+type DataFolderError struct {
+	Err  error
+	Path string
+}
+
+// File is a file in the data folder. In dedup mode, files with the same
+// content are hard links that share their modification time, see
+// ResolveModifiedAt.
+type File struct {
+	ModifiedAt time.Time
+	Name       string
+	Size       int64
+}
+
+// Storage manages the data folder of a single ablage instance and the upload
+// folder inside of it, where files are staged while they are being received.
+type Storage struct {
+	config   *config.Config
+	degraded atomic.Bool
+}
+
+func New(c *config.Config) (*Storage, error) {
+	s := &Storage{config: c}
+
+	err := createWriteableFolder(s.config.PathDataFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.RemoveAll(s.config.GetPathUploadFolder())
+	if err != nil {
+		return nil, fmt.Errorf("Could not delete upload folder '%s': %v", s.config.GetPathUploadFolder(), err)
+	}
+
+	err = createWriteableFolder(s.config.GetPathUploadFolder())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range s.getPathsOfInternalFolders() {
+		err = createWriteableFolder(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.config.DedupMode {
+		err = checkHardLinks(s.config.GetPathObjectsFolder())
+		if err != nil {
+			return nil, fmt.Errorf("Dedup mode needs a filesystem with hard links: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (e *DataFolderError) Error() string {
+	return fmt.Sprintf("Data folder '%s' became unavailable: %v", e.Path, e.Err)
+}
+
+func (e *DataFolderError) Is(target error) bool {
+	return target == ErrDataFolderUnavailable
+}
+
+func (e *DataFolderError) Unwrap() error {
+	return e.Err
+}
+
+// CheckAvailability checks whether the data folder can be accessed and
+// updates the degraded state accordingly.
+func (s *Storage) CheckAvailability() error {
+	_, err := os.ReadDir(s.config.PathDataFolder)
+	if err != nil {
+		err = &DataFolderError{Err: err, Path: s.config.PathDataFolder}
+	}
+
+	return s.updateAvailability(err)
+}
+
+// CheckDataFolderWritable creates and removes a temporary folder instead of a
+// file, so the check never shows up in the file list.
+func (s *Storage) CheckDataFolderWritable() error {
+	path, err := os.MkdirTemp(s.config.PathDataFolder, ".healthcheck-")
+	if err != nil {
+		return fmt.Errorf("Could not write to data folder '%s': %v", s.config.PathDataFolder, err)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("Could not delete test folder '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// CheckFreeDiskSpace returns an error if less than minFreeBytes are available
+// on the filesystem of the data folder. On platforms where the free space
+// can't be determined, it always succeeds.
+func (s *Storage) CheckFreeDiskSpace(minFreeBytes int64) error {
+	if minFreeBytes <= 0 {
+		return nil
+	}
+
+	freeBytes, err := GetFreeDiskSpace(s.config.PathDataFolder)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not determine free disk space of '%s': %v", s.config.PathDataFolder, err)
+	}
+
+	if freeBytes < uint64(minFreeBytes) {
+		return fmt.Errorf(
+			"Only %s of disk space left, at least %s required.",
+			GetHumanReadableSize(int64(freeBytes)),
+			GetHumanReadableSize(minFreeBytes),
+		)
+	}
+
+	return nil
+}
+
+func (s *Storage) CheckUploadFolder() error {
+	info, err := os.Stat(s.config.GetPathUploadFolder())
+	if err != nil {
+		return fmt.Errorf("Could not access upload folder '%s': %v", s.config.GetPathUploadFolder(), err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' exists but is not a directory", s.config.GetPathUploadFolder())
+	}
+
+	return nil
+}
+
+func (s *Storage) CleanupUploadFolder() error {
+	entries, err := os.ReadDir(s.config.GetPathUploadFolder())
+	if err != nil {
+		return fmt.Errorf("Could not read upload folder '%s': %v", s.config.GetPathUploadFolder(), err)
+	}
+
+	for _, entry := range entries {
+		err = os.RemoveAll(filepath.Join(s.config.GetPathUploadFolder(), entry.Name()))
+		if err != nil {
+			return fmt.Errorf("Could not delete staging file '%s': %v", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// CreateStagingFile creates a new file in the upload folder to receive an
+// upload in. Every upload gets a file of its own, even if several uploads of
+// the same filename are received at the same time.
+func (s *Storage) CreateStagingFile() (*os.File, error) {
+	const maxAttempts int = 10
+
+	var err error
+	for range maxAttempts {
+		var file *os.File
+		file, err = os.OpenFile(filepath.Join(s.config.GetPathUploadFolder(), generateID()), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("Could not create staging file: %v", err)
+}
+
+// DeleteFile deletes filename along with its metadata and its previous
+// versions. In dedup mode, the content is deleted as well if no other file
+// refers to it anymore.
+func (s *Storage) DeleteFile(filename string) error {
+	metadata, err := s.GetMetadata(filename)
+	if err != nil {
+		s.config.Logger.Warn("Could not read metadata", "file", filename, "error", err)
+	}
+
+	err = os.Remove(filepath.Join(s.config.PathDataFolder, filename))
 	if err != nil {
 		return err
 	}
 
-	err = os.RemoveAll(config.GetPathUploadFolder())
+	err = s.DeleteVersions(filename)
 	if err != nil {
-		return fmt.Errorf("Could not delete upload folder '%s': %v", config.GetPathUploadFolder(), err)
+		return err
 	}
 
-	err = createWriteableFolder(config.GetPathUploadFolder())
+	err = s.DeleteMetadata(filename)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.DeleteObjectIfUnused(metadata.SHA256)
 }
 
-func DeleteFile(filename string) error {
-	return os.Remove(filepath.Join(config.GetPathDataFolder(), filename))
+func (s *Storage) GetFileListOfDataFolder() (map[string]int64, error) {
+	files, err := s.GetFilesOfDataFolder()
+	if err != nil {
+		return map[string]int64{}, err
+	}
+
+	sizes := make(map[string]int64, len(files))
+	for _, file := range files {
+		sizes[file.Name] = file.Size
+	}
+
+	return sizes, nil
 }
 
-func GetFileListOfDataFolder() (map[string]int64, error) {
-	entries, err := os.ReadDir(config.GetPathDataFolder())
+// GetFilesOfDataFolder returns the files in the data folder, sorted by name.
+func (s *Storage) GetFilesOfDataFolder() ([]File, error) {
+	entries, err := os.ReadDir(s.config.PathDataFolder)
 	if err != nil {
-		return map[string]int64{}, fmt.Errorf(
-			"Data folder '%s' became unavailable: %v",
-			config.GetPathDataFolder(),
-			err,
-		)
+		return []File{}, s.updateAvailability(&DataFolderError{Err: err, Path: s.config.PathDataFolder})
+	}
+
+	err = s.updateAvailability(nil)
+	if err != nil {
+		return []File{}, err
 	}
 
-	files := map[string]int64{}
+	files := make([]File, 0, len(entries))
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
@@ -52,16 +254,215 @@ func GetFileListOfDataFolder() (map[string]int64, error) {
 
 		info, err := entry.Info()
 		if err != nil {
-			log.Printf("WARN: Could not read info for '%s': %v", entry.Name(), err)
+			s.config.Logger.Warn("Could not read file info", "file", entry.Name(), "error", err)
 			continue
 		}
 
-		files[info.Name()] = info.Size()
+		files = append(files, File{
+			ModifiedAt: info.ModTime(),
+			Name:       info.Name(),
+			Size:       info.Size(),
+		})
 	}
 
 	return files, nil
 }
 
+// IsDegraded reports whether the data folder was unavailable the last time it
+// was accessed.
+func (s *Storage) IsDegraded() bool {
+	return s.degraded.Load()
+}
+
+// StoreFile moves a received file from the upload folder into the data
+// folder as filename and returns the name it was stored as. policy decides
+// what happens if filename exists:
+//   - CollisionPolicyReject fails with ErrFileExists
+//   - CollisionPolicyOverwrite replaces the file atomically, keeping it as a
+//     version in versioning mode
+//   - CollisionPolicyAutoRename stores the file as "name (1).ext" or the
+//     next free number
+//   - CollisionPolicyKeepBoth renames the existing file that way instead,
+//     along with its metadata and versions
+func (s *Storage) StoreFile(pathToFileInUploadFolder string, filename string, policy string) (string, error) {
+	pathToFileInDataFolder := filepath.Join(s.config.PathDataFolder, filename)
+
+	switch policy {
+	case config.CollisionPolicyAutoRename:
+		return s.moveIntoDataFolder(pathToFileInUploadFolder, filename, true)
+
+	case config.CollisionPolicyKeepBoth:
+		info, err := os.Stat(pathToFileInDataFolder)
+		if err == nil && !info.IsDir() {
+			metadata, err := s.GetMetadata(filename)
+			if err != nil {
+				s.config.Logger.Warn("Could not read metadata", "file", filename, "error", err)
+			}
+
+			renamedFilename, err := s.moveIntoDataFolder(pathToFileInDataFolder, filename, true)
+			if err != nil {
+				return "", err
+			}
+
+			err = s.SaveMetadata(renamedFilename, metadata)
+			if err != nil {
+				s.config.Logger.Warn("Could not save metadata", "file", renamedFilename, "error", err)
+			}
+
+			err = s.moveVersions(filename, renamedFilename)
+			if err != nil {
+				s.config.Logger.Warn("Could not move versions", "file", renamedFilename, "error", err)
+			}
+		}
+
+		return s.moveIntoDataFolder(pathToFileInUploadFolder, filename, false)
+
+	case config.CollisionPolicyOverwrite:
+		info, err := os.Stat(pathToFileInDataFolder)
+		if err == nil && info.IsDir() {
+			return "", ErrFileExists
+		}
+
+		// In dedup mode, a file with the same content is the same file on
+		// disk already. A rename between two links to the same file does
+		// nothing, so the received file would be left behind.
+		if err == nil {
+			infoUpload, errUpload := os.Stat(pathToFileInUploadFolder)
+			if errUpload == nil && os.SameFile(info, infoUpload) {
+				err = os.Remove(pathToFileInUploadFolder)
+				if err != nil {
+					return "", fmt.Errorf("Could not store '%s': %v", filename, err)
+				}
+				return filename, nil
+			}
+		}
+
+		metadata, err := s.GetMetadata(filename)
+		if err != nil {
+			s.config.Logger.Warn("Could not read metadata", "file", filename, "error", err)
+		}
+
+		if s.config.VersioningMode {
+			_, err = s.ArchiveVersion(filename)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		err = os.Rename(pathToFileInUploadFolder, pathToFileInDataFolder)
+		if err != nil {
+			return "", fmt.Errorf("Could not store '%s': %v", filename, err)
+		}
+
+		err = s.DeleteObjectIfUnused(metadata.SHA256)
+		if err != nil {
+			s.config.Logger.Warn("Could not delete object", "file", filename, "error", err)
+		}
+
+		return filename, nil
+
+	default:
+		return s.moveIntoDataFolder(pathToFileInUploadFolder, filename, false)
+	}
+}
+
+// moveIntoDataFolder moves source into the data folder as filename without
+// replacing an existing file. If rename is set, it tries "name (1).ext" and
+// so on until it finds a free name, otherwise it fails with ErrFileExists.
+func (s *Storage) moveIntoDataFolder(source string, filename string, rename bool) (string, error) {
+	const maxNumber int = 1000
+
+	for number := 0; number <= maxNumber; number++ {
+		if number > 0 && !rename {
+			break
+		}
+
+		candidate := getNumberedFilename(filename, number)
+		pathCandidate := filepath.Join(s.config.PathDataFolder, candidate)
+
+		// A hard link fails if the target exists, unlike a rename, which would
+		// silently replace a file stored in the meantime. Filesystems without
+		// hard links fall back to a rename.
+		err := os.Link(source, pathCandidate)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err == nil {
+			err = os.Remove(source)
+			if err != nil {
+				return "", fmt.Errorf("Could not store '%s': %v", candidate, err)
+			}
+			return candidate, nil
+		}
+
+		_, err = os.Lstat(pathCandidate)
+		if err == nil {
+			continue
+		}
+
+		err = os.Rename(source, pathCandidate)
+		if err != nil {
+			return "", fmt.Errorf("Could not store '%s': %v", candidate, err)
+		}
+		return candidate, nil
+	}
+
+	return "", ErrFileExists
+}
+
+// updateAvailability records the outcome of an access to the data folder.
+// When the data folder becomes available again, the upload folder and the
+// other internal folders are recreated, as they are gone if the data folder
+// was remounted.
+func (s *Storage) updateAvailability(err error) error {
+	if err != nil {
+		if s.degraded.CompareAndSwap(false, true) {
+			s.config.Logger.Warn("Data folder unavailable, running in degraded mode", "error", err)
+		}
+		return err
+	}
+
+	if !s.degraded.Load() {
+		return nil
+	}
+
+	for _, path := range append([]string{s.config.GetPathUploadFolder()}, s.getPathsOfInternalFolders()...) {
+		err = createWriteableFolder(path)
+		if err != nil {
+			return &DataFolderError{Err: err, Path: s.config.PathDataFolder}
+		}
+	}
+
+	if s.degraded.CompareAndSwap(true, false) {
+		s.config.Logger.Info("Data folder is available again", "path", s.config.PathDataFolder)
+	}
+
+	return nil
+}
+
+// getPathsOfInternalFolders returns the folders inside the data folder
+// ablage keeps its own state in, except for the upload folder.
+func (s *Storage) getPathsOfInternalFolders() []string {
+	paths := []string{s.config.GetPathMetadataFolder()}
+	if s.config.DedupMode {
+		paths = append(paths, s.config.GetPathObjectsFolder())
+	}
+
+	if s.config.SearchMode {
+		paths = append(paths, s.config.GetPathIndexFolder())
+	}
+
+	if s.config.TrashMode {
+		paths = append(paths, s.config.GetPathTrashFolder())
+	}
+
+	if s.config.VersioningMode {
+		paths = append(paths, s.config.GetPathVersionsFolder())
+	}
+
+	return paths
+}
+
 func GetHumanReadableSize(bytes int64) string {
 	const unit int64 = 1024
 
@@ -140,3 +541,46 @@ func createWriteableFolder(path string) error {
 
 	return nil
 }
+
+// generateID returns a unique ID, which sorts by creation time. It names the
+// entries in the trash and the version store, as the same filename can be
+// deleted or replaced more than once, as well as staging files and other
+// temporary files.
+func generateID() string {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// getNumberedFilename returns filename for 0 and "name (number).ext"
+// otherwise.
+func getNumberedFilename(filename string, number int) string {
+	if number == 0 {
+		return filename
+	}
+
+	extension := filepath.Ext(filename)
+	if extension == filename {
+		extension = ""
+	}
+
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(filename, extension), number, extension)
+}
+
+func isValidID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, r := range id {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') && r != 'T' && r != '-' {
+			return false
+		}
+	}
+
+	return true
+}
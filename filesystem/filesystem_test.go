@@ -1,8 +1,14 @@
 package filesystem
 
 import (
+	"log/slog"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"git.0x0001f346.de/andreas/ablage/config"
 )
 
 func Test_sanitizeFilename(t *testing.T) {
@@ -111,3 +117,276 @@ func Test_getHumanReadableSize(t *testing.T) {
 		})
 	}
 }
+
+func Test_getNumberedFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		number   int
+		want     string
+	}{
+		{
+			name:     "1",
+			filename: "file.txt",
+			number:   0,
+			want:     "file.txt",
+		},
+		{
+			name:     "2",
+			filename: "file.txt",
+			number:   1,
+			want:     "file (1).txt",
+		},
+		{
+			name:     "3",
+			filename: "archive.tar.gz",
+			number:   12,
+			want:     "archive.tar (12).gz",
+		},
+		{
+			name:     "4",
+			filename: "README",
+			number:   2,
+			want:     "README (2)",
+		},
+		{
+			name:     "5",
+			filename: ".txt",
+			number:   1,
+			want:     ".txt (1)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getNumberedFilename(tt.filename, tt.number); got != tt.want {
+				t.Errorf("\ngetNumberedFilename()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	c := config.New()
+	c.HttpMode = true
+	c.Logger = slog.New(slog.DiscardHandler)
+	c.PathDataFolder = filepath.Join(t.TempDir(), "data")
+
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("config.Init() failed: %v", err)
+	}
+
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	return s
+}
+
+func Test_Storage_GetUsage(t *testing.T) {
+	s := newTestStorage(t)
+
+	files := []struct {
+		filename string
+		content  string
+		uploader string
+	}{
+		{filename: "a.txt", content: "0123456789", uploader: "alice"},
+		{filename: "b.txt", content: "01234", uploader: "bob"},
+		{filename: "c.txt", content: "012", uploader: "alice"},
+		{filename: "d.txt", content: "01", uploader: ""},
+	}
+	for _, file := range files {
+		err := os.WriteFile(filepath.Join(s.config.PathDataFolder, file.filename), []byte(file.content), 0644)
+		if err != nil {
+			t.Fatalf("os.WriteFile() failed: %v", err)
+		}
+
+		if file.uploader != "" {
+			err = s.SaveMetadata(file.filename, Metadata{Uploader: file.uploader})
+			if err != nil {
+				t.Fatalf("SaveMetadata() failed: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		uploader string
+		want     Usage
+	}{
+		{
+			name:     "1",
+			uploader: "",
+			want:     Usage{TotalBytes: 20, UploaderBytes: 0},
+		},
+		{
+			name:     "2",
+			uploader: "alice",
+			want:     Usage{TotalBytes: 20, UploaderBytes: 13},
+		},
+		{
+			name:     "3",
+			uploader: "bob",
+			want:     Usage{TotalBytes: 20, UploaderBytes: 5},
+		},
+		{
+			name:     "4",
+			uploader: "carol",
+			want:     Usage{TotalBytes: 20, UploaderBytes: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetUsage(tt.uploader)
+			if err != nil {
+				t.Fatalf("GetUsage() failed: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("\nGetUsage()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+
+	err := s.DeleteFile("a.txt")
+	if err != nil {
+		t.Fatalf("DeleteFile() failed: %v", err)
+	}
+
+	if _, err = os.Stat(s.getPathMetadataFile("a.txt")); !os.IsNotExist(err) {
+		t.Errorf("\nDeleteFile()\nwant: metadata deleted\ngot:  %v", err)
+	}
+}
+
+func Test_Storage_DeleteObjectIfUnused(t *testing.T) {
+	s := newTestStorage(t)
+
+	const sumOfHello string = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	pathObject := s.getPathObjectFile(sumOfHello)
+
+	paths := []string{
+		filepath.Join(s.config.PathDataFolder, "a.txt"),
+		filepath.Join(s.config.GetPathUploadFolder(), "b.txt"),
+	}
+	for _, path := range paths {
+		err := os.WriteFile(path, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("os.WriteFile() failed: %v", err)
+		}
+
+		_, err = s.DeduplicateFile(path, sumOfHello)
+		if err != nil {
+			t.Fatalf("DeduplicateFile() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		remove     string
+		wantObject bool
+	}{
+		{
+			name:       "1",
+			remove:     paths[1],
+			wantObject: true,
+		},
+		{
+			name:       "2",
+			remove:     paths[0],
+			wantObject: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := os.Remove(tt.remove)
+			if err != nil {
+				t.Fatalf("os.Remove() failed: %v", err)
+			}
+
+			err = s.DeleteObjectIfUnused(sumOfHello)
+			if err != nil {
+				t.Fatalf("DeleteObjectIfUnused() failed: %v", err)
+			}
+
+			_, err = os.Stat(pathObject)
+			if (err == nil) != tt.wantObject {
+				t.Errorf("\nDeleteObjectIfUnused()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantObject, err)
+			}
+		})
+	}
+}
+
+func Test_Storage_ResolveModifiedAt(t *testing.T) {
+	s := newTestStorage(t)
+
+	modifiedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	uploadedAt := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	restoredAt := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	files := []struct {
+		filename string
+		metadata Metadata
+	}{
+		{filename: "a.txt", metadata: Metadata{}},
+		{filename: "b.txt", metadata: Metadata{UploadedAt: uploadedAt}},
+		{filename: "c.txt", metadata: Metadata{RestoredAt: restoredAt, UploadedAt: uploadedAt}},
+		{filename: "d.txt", metadata: Metadata{UploadedAt: modifiedAt.Add(-time.Hour)}},
+	}
+	for _, file := range files {
+		path := filepath.Join(s.config.PathDataFolder, file.filename)
+
+		err := os.WriteFile(path, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("os.WriteFile() failed: %v", err)
+		}
+
+		err = os.Chtimes(path, modifiedAt, modifiedAt)
+		if err != nil {
+			t.Fatalf("os.Chtimes() failed: %v", err)
+		}
+
+		err = s.SaveMetadata(file.filename, file.metadata)
+		if err != nil {
+			t.Fatalf("SaveMetadata() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		resolve bool
+		want    map[string]time.Time
+	}{
+		{
+			name:    "1",
+			resolve: false,
+			want:    map[string]time.Time{"a.txt": modifiedAt, "b.txt": modifiedAt, "c.txt": modifiedAt, "d.txt": modifiedAt},
+		},
+		{
+			name:    "2",
+			resolve: true,
+			want:    map[string]time.Time{"a.txt": modifiedAt, "b.txt": uploadedAt, "c.txt": restoredAt, "d.txt": modifiedAt},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetFilesOfDataFolder()
+			if err != nil {
+				t.Fatalf("GetFilesOfDataFolder() failed: %v", err)
+			}
+
+			if tt.resolve {
+				s.ResolveModifiedAt(got)
+			}
+
+			for _, file := range got {
+				if !file.ModifiedAt.Equal(tt.want[file.Name]) {
+					t.Errorf("\nResolveModifiedAt()\nname: %v\nfile: %v\nwant: %v\ngot:  %v", tt.name, file.Name, tt.want[file.Name], file.ModifiedAt)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package filesystem
+
+import "errors"
+
+// getLinkCount is not supported on this platform.
+func getLinkCount(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// getLinkCount returns the number of hard links to the file at path.
+func getLinkCount(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+
+	return uint64(stat.Nlink), nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// getLinkCount returns the number of hard links to the file at path.
+func getLinkCount(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var info syscall.ByHandleFileInformation
+	err = syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), &info)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(info.NumberOfLinks), nil
+}
@@ -0,0 +1,229 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata is stored next to every uploaded file as a JSON sidecar in the
+// metadata folder. Files that were put into the data folder by other means
+// have no sidecar and the zero value as metadata. ClientIP is the address of
+// the connection, or the one from X-Forwarded-For if the connection came from a
+// trusted proxy.
+type Metadata struct {
+	BLAKE3      string    `json:"BLAKE3,omitempty"`
+	ClientIP    string    `json:"ClientIP,omitempty"`
+	Description string    `json:"Description,omitempty"`
+	ExpiresAt   time.Time `json:"ExpiresAt,omitzero"`
+	MIMEType    string    `json:"MIMEType,omitempty"`
+	RestoredAt  time.Time `json:"RestoredAt,omitzero"`
+	SHA256      string    `json:"SHA256,omitempty"`
+	Tags        []string  `json:"Tags,omitempty"`
+	UploadedAt  time.Time `json:"UploadedAt,omitzero"`
+	Uploader    string    `json:"Uploader,omitempty"`
+}
+
+// Usage is the space taken by the files in the data folder, in total and by
+// the files of a single uploader.
+type Usage struct {
+	TotalBytes    int64
+	UploaderBytes int64
+}
+
+// GetModifiedAt returns when the file was uploaded or last restored, or
+// modifiedAt if that is later or unknown.
+func (metadata Metadata) GetModifiedAt(modifiedAt time.Time) time.Time {
+	for _, t := range []time.Time{metadata.UploadedAt, metadata.RestoredAt} {
+		if t.After(modifiedAt) {
+			modifiedAt = t
+		}
+	}
+
+	return modifiedAt
+}
+
+func (s *Storage) DeleteMetadata(filename string) error {
+	err := os.Remove(s.getPathMetadataFile(filename))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Could not delete metadata of '%s': %v", filename, err)
+	}
+
+	return nil
+}
+
+// DeleteOrphanedMetadata deletes the sidecars of files that were deleted
+// without ablage, e.g. directly on the disk.
+func (s *Storage) DeleteOrphanedMetadata() error {
+	entries, err := os.ReadDir(s.config.GetPathMetadataFolder())
+	if err != nil {
+		return fmt.Errorf("Could not read metadata folder '%s': %v", s.config.GetPathMetadataFolder(), err)
+	}
+
+	for _, entry := range entries {
+		filename, isSidecar := strings.CutSuffix(entry.Name(), ".json")
+		if !isSidecar || entry.IsDir() {
+			continue
+		}
+
+		_, err = os.Stat(filepath.Join(s.config.PathDataFolder, filename))
+		if !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		err = s.DeleteMetadata(filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetExpiresAt returns when filename expires, which is the earlier of the
+// lifetime chosen by the uploader and the global FileTTL, or the zero time if
+// it never expires. FileTTL counts from the upload or the last restore, files
+// without metadata expire FileTTL after they were last modified.
+func (s *Storage) GetExpiresAt(filename string) (time.Time, error) {
+	metadata, err := s.GetMetadata(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if s.config.FileTTL == 0 {
+		return metadata.ExpiresAt, nil
+	}
+
+	uploadedAt := metadata.UploadedAt
+	if metadata.RestoredAt.After(uploadedAt) {
+		uploadedAt = metadata.RestoredAt
+	}
+	if uploadedAt.IsZero() {
+		info, err := os.Stat(filepath.Join(s.config.PathDataFolder, filename))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Could not access '%s': %v", filename, err)
+		}
+		uploadedAt = info.ModTime()
+	}
+
+	expiresAt := uploadedAt.Add(s.config.FileTTL)
+	if !metadata.ExpiresAt.IsZero() && metadata.ExpiresAt.Before(expiresAt) {
+		return metadata.ExpiresAt, nil
+	}
+
+	return expiresAt, nil
+}
+
+func (s *Storage) GetMetadata(filename string) (Metadata, error) {
+	var metadata Metadata
+
+	data, err := os.ReadFile(s.getPathMetadataFile(filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, fmt.Errorf("Could not read metadata of '%s': %v", filename, err)
+	}
+
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return metadata, fmt.Errorf("Could not parse metadata of '%s': %v", filename, err)
+	}
+
+	return metadata, nil
+}
+
+// GetUsage sums up the sizes of the files in the data folder. The files of
+// uploader are only looked up if uploader is not empty, as this requires
+// reading every sidecar.
+func (s *Storage) GetUsage(uploader string) (Usage, error) {
+	var usage Usage
+
+	files, err := s.GetFileListOfDataFolder()
+	if err != nil {
+		return usage, err
+	}
+
+	for filename, sizeInBytes := range files {
+		usage.TotalBytes += sizeInBytes
+
+		if uploader == "" {
+			continue
+		}
+
+		metadata, err := s.GetMetadata(filename)
+		if err != nil {
+			s.config.Logger.Warn("Could not read metadata", "file", filename, "error", err)
+			continue
+		}
+
+		if metadata.Uploader == uploader {
+			usage.UploaderBytes += sizeInBytes
+		}
+	}
+
+	return usage, nil
+}
+
+// ResolveModifiedAt sets the modification time of files to the time they were
+// uploaded or last restored if that is later, see Metadata.GetModifiedAt. In
+// dedup mode, files with the same content are hard links that share their
+// modification time. This reads the sidecar of every file, so it is only meant
+// for listings that sort or filter by time.
+func (s *Storage) ResolveModifiedAt(files []File) {
+	for i, file := range files {
+		metadata, err := s.GetMetadata(file.Name)
+		if err != nil {
+			s.config.Logger.Warn("Could not read metadata", "file", file.Name, "error", err)
+			continue
+		}
+
+		files[i].ModifiedAt = metadata.GetModifiedAt(file.ModifiedAt)
+	}
+}
+
+// SaveMetadata writes the sidecar to a temporary file first, so it is never
+// read half-written.
+func (s *Storage) SaveMetadata(filename string, metadata Metadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("Could not encode metadata of '%s': %v", filename, err)
+	}
+
+	pathMetadataFile := s.getPathMetadataFile(filename)
+	pathTemporaryFile := pathMetadataFile + ".tmp"
+
+	err = os.WriteFile(pathTemporaryFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not write metadata of '%s': %v", filename, err)
+	}
+
+	err = os.Rename(pathTemporaryFile, pathMetadataFile)
+	if err != nil {
+		_ = os.Remove(pathTemporaryFile)
+		return fmt.Errorf("Could not write metadata of '%s': %v", filename, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) getPathMetadataFile(filename string) string {
+	return filepath.Join(s.config.GetPathMetadataFolder(), filepath.Base(filename)+".json")
+}
+
+// restartLifetime gives a restored file the lifetime it was uploaded with
+// again, counting from now. Otherwise the janitor would delete a file that
+// expired while it was in the trash or a version right after its restore.
+func restartLifetime(metadata Metadata, now time.Time) Metadata {
+	if !metadata.ExpiresAt.IsZero() && !metadata.UploadedAt.IsZero() {
+		metadata.ExpiresAt = now.Add(metadata.ExpiresAt.Sub(metadata.UploadedAt))
+	}
+
+	metadata.RestoredAt = now
+
+	return metadata
+}
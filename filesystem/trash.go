@@ -0,0 +1,200 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrFileExists is returned when a file can't be stored or restored from the
+// trash, because a file with the same name exists.
+var ErrFileExists = errors.New("file exists")
+
+// ErrTrashEntryNotFound is returned for IDs that are not in the trash.
+var ErrTrashEntryNotFound = errors.New("trash entry not found")
+
+// TrashEntry describes a deleted file. Every entry is stored in the trash
+// folder as the file itself, named by ID, and a JSON sidecar, which keeps the
+// metadata of the file to restore it along with the file.
+type TrashEntry struct {
+	DeletedAt time.Time `json:"DeletedAt"`
+	DeletedBy string    `json:"DeletedBy,omitempty"`
+	ID        string    `json:"ID"`
+	Metadata  Metadata  `json:"Metadata"`
+	Name      string    `json:"Name"`
+	Size      int64     `json:"Size"`
+}
+
+func (s *Storage) GetTrash() ([]TrashEntry, error) {
+	entries, err := os.ReadDir(s.config.GetPathTrashFolder())
+	if err != nil {
+		availabilityErr := s.CheckAvailability()
+		if availabilityErr != nil {
+			return nil, availabilityErr
+		}
+		return nil, fmt.Errorf("Could not read trash folder '%s': %v", s.config.GetPathTrashFolder(), err)
+	}
+
+	trash := []TrashEntry{}
+	for _, entry := range entries {
+		id, isSidecar := strings.CutSuffix(entry.Name(), ".json")
+		if !isSidecar || !isValidID(id) {
+			continue
+		}
+
+		trashEntry, err := s.getTrashEntry(id)
+		if err != nil {
+			s.config.Logger.Warn("Could not read trash entry", "id", id, "error", err)
+			continue
+		}
+
+		trash = append(trash, trashEntry)
+	}
+
+	return trash, nil
+}
+
+// MoveFileToTrash moves filename and its metadata into the trash folder.
+// deletedBy is recorded to show who deleted the file.
+func (s *Storage) MoveFileToTrash(filename string, deletedBy string) (TrashEntry, error) {
+	info, err := os.Stat(filepath.Join(s.config.PathDataFolder, filename))
+	if err != nil {
+		return TrashEntry{}, fmt.Errorf("Could not access '%s': %v", filename, err)
+	}
+
+	metadata, err := s.GetMetadata(filename)
+	if err != nil {
+		s.config.Logger.Warn("Could not read metadata", "file", filename, "error", err)
+	}
+
+	trashEntry := TrashEntry{
+		DeletedAt: time.Now().UTC(),
+		DeletedBy: deletedBy,
+		ID:        generateID(),
+		Metadata:  metadata,
+		Name:      filename,
+		Size:      info.Size(),
+	}
+
+	data, err := json.Marshal(trashEntry)
+	if err != nil {
+		return TrashEntry{}, fmt.Errorf("Could not encode trash entry of '%s': %v", filename, err)
+	}
+
+	pathTrashSidecar := s.getPathTrashFile(trashEntry.ID) + ".json"
+
+	err = os.WriteFile(pathTrashSidecar, data, 0644)
+	if err != nil {
+		return TrashEntry{}, fmt.Errorf("Could not write trash entry of '%s': %v", filename, err)
+	}
+
+	err = os.Rename(filepath.Join(s.config.PathDataFolder, filename), s.getPathTrashFile(trashEntry.ID))
+	if err != nil {
+		_ = os.Remove(pathTrashSidecar)
+		return TrashEntry{}, fmt.Errorf("Could not move '%s' to the trash: %v", filename, err)
+	}
+
+	return trashEntry, s.DeleteMetadata(filename)
+}
+
+// PurgeExpiredTrash deletes every entry that was deleted before
+// deletedBefore and returns the purged entries.
+func (s *Storage) PurgeExpiredTrash(deletedBefore time.Time) ([]TrashEntry, error) {
+	trash, err := s.GetTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []TrashEntry{}
+	for _, trashEntry := range trash {
+		if !trashEntry.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+
+		_, err = s.PurgeFromTrash(trashEntry.ID)
+		if err != nil {
+			return purged, err
+		}
+
+		purged = append(purged, trashEntry)
+	}
+
+	return purged, nil
+}
+
+func (s *Storage) PurgeFromTrash(id string) (TrashEntry, error) {
+	trashEntry, err := s.getTrashEntry(id)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+
+	err = os.Remove(s.getPathTrashFile(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return TrashEntry{}, fmt.Errorf("Could not purge '%s' from the trash: %v", trashEntry.Name, err)
+	}
+
+	err = os.Remove(s.getPathTrashFile(id) + ".json")
+	if err != nil {
+		return TrashEntry{}, fmt.Errorf("Could not purge '%s' from the trash: %v", trashEntry.Name, err)
+	}
+
+	return trashEntry, s.DeleteObjectIfUnused(trashEntry.Metadata.SHA256)
+}
+
+// RestoreFromTrash moves a file back into the data folder under its original
+// name, unless a file with that name exists by now. Its lifetime restarts, see
+// restartLifetime.
+func (s *Storage) RestoreFromTrash(id string) (TrashEntry, error) {
+	trashEntry, err := s.getTrashEntry(id)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+
+	_, err = s.moveIntoDataFolder(s.getPathTrashFile(id), trashEntry.Name, false)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+
+	err = s.SaveMetadata(trashEntry.Name, restartLifetime(trashEntry.Metadata, time.Now()))
+	if err != nil {
+		s.config.Logger.Warn("Could not save metadata", "file", trashEntry.Name, "error", err)
+	}
+
+	_, err = s.PurgeFromTrash(id)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+
+	return trashEntry, nil
+}
+
+func (s *Storage) getPathTrashFile(id string) string {
+	return filepath.Join(s.config.GetPathTrashFolder(), id)
+}
+
+func (s *Storage) getTrashEntry(id string) (TrashEntry, error) {
+	var trashEntry TrashEntry
+
+	if !isValidID(id) {
+		return trashEntry, ErrTrashEntryNotFound
+	}
+
+	data, err := os.ReadFile(s.getPathTrashFile(id) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return trashEntry, ErrTrashEntryNotFound
+	}
+	if err != nil {
+		return trashEntry, fmt.Errorf("Could not read trash entry '%s': %v", id, err)
+	}
+
+	err = json.Unmarshal(data, &trashEntry)
+	if err != nil {
+		return trashEntry, fmt.Errorf("Could not parse trash entry '%s': %v", id, err)
+	}
+
+	return trashEntry, nil
+}
@@ -0,0 +1,320 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrVersionNotFound is returned for versions that don't exist, including
+// every version of an invalid filename.
+var ErrVersionNotFound = errors.New("version not found")
+
+// Version describes a previous version of a file, which was replaced by a
+// re-upload or a rollback. Every version is stored in a folder named after
+// the file inside the versions folder, as the file itself, named by ID, and a
+// JSON sidecar, which keeps the metadata of the file to restore it along with
+// the file.
+type Version struct {
+	ArchivedAt time.Time `json:"ArchivedAt"`
+	ID         string    `json:"ID"`
+	Metadata   Metadata  `json:"Metadata"`
+	Name       string    `json:"Name"`
+	Size       int64     `json:"Size"`
+}
+
+// ArchiveVersion keeps the current content of filename as a new version. The
+// file itself stays in place, so it can be replaced atomically afterwards.
+// It returns the zero Version if filename does not exist.
+func (s *Storage) ArchiveVersion(filename string) (Version, error) {
+	if !isValidVersionedFilename(filename) {
+		return Version{}, ErrVersionNotFound
+	}
+
+	pathToFileInDataFolder := filepath.Join(s.config.PathDataFolder, filename)
+
+	info, err := os.Stat(pathToFileInDataFolder)
+	if errors.Is(err, os.ErrNotExist) {
+		return Version{}, nil
+	}
+	if err != nil {
+		return Version{}, fmt.Errorf("Could not access '%s': %v", filename, err)
+	}
+	if info.IsDir() {
+		return Version{}, fmt.Errorf("'%s' is a directory", filename)
+	}
+
+	metadata, err := s.GetMetadata(filename)
+	if err != nil {
+		s.config.Logger.Warn("Could not read metadata", "file", filename, "error", err)
+	}
+
+	version := Version{
+		ArchivedAt: time.Now().UTC(),
+		ID:         generateID(),
+		Metadata:   metadata,
+		Name:       filename,
+		Size:       info.Size(),
+	}
+
+	err = os.MkdirAll(s.getPathVersionsFolderOfFile(filename), 0755)
+	if err != nil {
+		return Version{}, fmt.Errorf("Could not create versions folder of '%s': %v", filename, err)
+	}
+
+	data, err := json.Marshal(version)
+	if err != nil {
+		return Version{}, fmt.Errorf("Could not encode version of '%s': %v", filename, err)
+	}
+
+	pathVersionFile := s.GetPathVersionFile(filename, version.ID)
+	pathVersionSidecar := pathVersionFile + ".json"
+
+	err = os.WriteFile(pathVersionSidecar, data, 0644)
+	if err != nil {
+		return Version{}, fmt.Errorf("Could not write version of '%s': %v", filename, err)
+	}
+
+	// A hard link keeps the file readable until it is replaced. Filesystems
+	// without hard links fall back to a rename.
+	err = os.Link(pathToFileInDataFolder, pathVersionFile)
+	if err != nil {
+		err = os.Rename(pathToFileInDataFolder, pathVersionFile)
+	}
+	if err != nil {
+		_ = os.Remove(pathVersionSidecar)
+		return Version{}, fmt.Errorf("Could not archive version of '%s': %v", filename, err)
+	}
+
+	return version, nil
+}
+
+// DeleteOrphanedVersions deletes the versions of files that are neither in
+// the data folder nor in the trash anymore.
+func (s *Storage) DeleteOrphanedVersions() error {
+	entries, err := os.ReadDir(s.config.GetPathVersionsFolder())
+	if err != nil {
+		return fmt.Errorf("Could not read versions folder '%s': %v", s.config.GetPathVersionsFolder(), err)
+	}
+
+	namesInTrash := map[string]bool{}
+	if s.config.TrashMode {
+		trash, err := s.GetTrash()
+		if err != nil {
+			return err
+		}
+
+		for _, trashEntry := range trash {
+			namesInTrash[trashEntry.Name] = true
+		}
+	}
+
+	for _, entry := range entries {
+		filename := entry.Name()
+		if !entry.IsDir() || namesInTrash[filename] {
+			continue
+		}
+
+		_, err = os.Stat(filepath.Join(s.config.PathDataFolder, filename))
+		if !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		err = s.DeleteVersions(filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteVersions(filename string) error {
+	if !isValidVersionedFilename(filename) {
+		return nil
+	}
+
+	err := os.RemoveAll(s.getPathVersionsFolderOfFile(filename))
+	if err != nil {
+		return fmt.Errorf("Could not delete versions of '%s': %v", filename, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetPathVersionFile(filename string, id string) string {
+	return filepath.Join(s.getPathVersionsFolderOfFile(filename), id)
+}
+
+func (s *Storage) GetVersion(filename string, id string) (Version, error) {
+	var version Version
+
+	if !isValidVersionedFilename(filename) || !isValidID(id) {
+		return version, ErrVersionNotFound
+	}
+
+	data, err := os.ReadFile(s.GetPathVersionFile(filename, id) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return version, ErrVersionNotFound
+	}
+	if err != nil {
+		return version, fmt.Errorf("Could not read version '%s' of '%s': %v", id, filename, err)
+	}
+
+	err = json.Unmarshal(data, &version)
+	if err != nil {
+		return version, fmt.Errorf("Could not parse version '%s' of '%s': %v", id, filename, err)
+	}
+
+	// The versions move along with a file that is renamed, see moveVersions.
+	version.Name = filename
+
+	return version, nil
+}
+
+// GetVersions returns the versions of filename, newest first.
+func (s *Storage) GetVersions(filename string) ([]Version, error) {
+	versions := []Version{}
+
+	if !isValidVersionedFilename(filename) {
+		return versions, nil
+	}
+
+	entries, err := os.ReadDir(s.getPathVersionsFolderOfFile(filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return versions, nil
+	}
+	if err != nil {
+		availabilityErr := s.CheckAvailability()
+		if availabilityErr != nil {
+			return nil, availabilityErr
+		}
+		return nil, fmt.Errorf("Could not read versions of '%s': %v", filename, err)
+	}
+
+	for _, entry := range entries {
+		id, isSidecar := strings.CutSuffix(entry.Name(), ".json")
+		if !isSidecar || !isValidID(id) {
+			continue
+		}
+
+		version, err := s.GetVersion(filename, id)
+		if err != nil {
+			s.config.Logger.Warn("Could not read version", "file", filename, "id", id, "error", err)
+			continue
+		}
+
+		versions = append(versions, version)
+	}
+
+	slices.SortFunc(versions, func(a, b Version) int {
+		if c := b.ArchivedAt.Compare(a.ArchivedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+
+	return versions, nil
+}
+
+// PruneVersions deletes all but the keep newest versions of filename and
+// returns the deleted versions.
+func (s *Storage) PruneVersions(filename string, keep int) ([]Version, error) {
+	versions, err := s.GetVersions(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []Version{}
+	for _, version := range versions[min(keep, len(versions)):] {
+		err = s.deleteVersion(filename, version.ID)
+		if err != nil {
+			return pruned, err
+		}
+
+		pruned = append(pruned, version)
+	}
+
+	return pruned, nil
+}
+
+// RestoreVersion makes a version the current content of filename again. The
+// content it replaces is kept as a new version, so a rollback can be undone.
+// Its lifetime restarts, see restartLifetime.
+func (s *Storage) RestoreVersion(filename string, id string) (Version, error) {
+	version, err := s.GetVersion(filename, id)
+	if err != nil {
+		return Version{}, err
+	}
+
+	_, err = s.ArchiveVersion(filename)
+	if err != nil {
+		return Version{}, err
+	}
+
+	err = os.Rename(s.GetPathVersionFile(filename, id), filepath.Join(s.config.PathDataFolder, filename))
+	if err != nil {
+		return Version{}, fmt.Errorf("Could not restore version '%s' of '%s': %v", id, filename, err)
+	}
+
+	err = s.SaveMetadata(filename, restartLifetime(version.Metadata, time.Now()))
+	if err != nil {
+		s.config.Logger.Warn("Could not save metadata", "file", filename, "error", err)
+	}
+
+	err = s.deleteVersion(filename, id)
+	if err != nil {
+		return Version{}, err
+	}
+
+	return version, nil
+}
+
+func (s *Storage) deleteVersion(filename string, id string) error {
+	version, err := s.GetVersion(filename, id)
+	if err != nil {
+		s.config.Logger.Warn("Could not read version", "file", filename, "id", id, "error", err)
+	}
+
+	err = os.Remove(s.GetPathVersionFile(filename, id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Could not delete version '%s' of '%s': %v", id, filename, err)
+	}
+
+	err = os.Remove(s.GetPathVersionFile(filename, id) + ".json")
+	if err != nil {
+		return fmt.Errorf("Could not delete version '%s' of '%s': %v", id, filename, err)
+	}
+
+	return s.DeleteObjectIfUnused(version.Metadata.SHA256)
+}
+
+func (s *Storage) getPathVersionsFolderOfFile(filename string) string {
+	return filepath.Join(s.config.GetPathVersionsFolder(), filename)
+}
+
+// moveVersions moves the versions of filename along with the file when it is
+// renamed to newFilename.
+func (s *Storage) moveVersions(filename string, newFilename string) error {
+	if !isValidVersionedFilename(filename) || !isValidVersionedFilename(newFilename) {
+		return nil
+	}
+
+	err := os.Rename(s.getPathVersionsFolderOfFile(filename), s.getPathVersionsFolderOfFile(newFilename))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Could not move versions of '%s' to '%s': %v", filename, newFilename, err)
+	}
+
+	return nil
+}
+
+// isValidVersionedFilename rejects names that would point outside of the
+// versions folder of a single file.
+func isValidVersionedFilename(filename string) bool {
+	return filename != "." && filename != ".." && filename == filepath.Base(filename)
+}
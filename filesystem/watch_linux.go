@@ -0,0 +1,76 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// watchMask covers files that are created, replaced, deleted or touched in the
+// data folder and the metadata folder. Writes only need to be seen once they
+// are complete, as uploads are moved into the data folder when they are.
+const watchMask uint32 = syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+
+// WatchDataFolder calls changed whenever a file in the data folder or its
+// metadata changes, until done is closed. It uses inotify and returns an error
+// as soon as the data folder can't be watched anymore, e.g. because it was
+// unmounted or replaced.
+func (s *Storage) WatchDataFolder(done <-chan struct{}, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("Could not watch the data folder: %v", err)
+	}
+
+	// A non-blocking descriptor is handled by the runtime poller, so Close
+	// interrupts a pending Read.
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	for _, path := range []string{s.config.PathDataFolder, s.config.GetPathMetadataFolder()} {
+		_, err = syscall.InotifyAddWatch(fd, path, watchMask)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("Could not watch '%s': %v", path, err)
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		file.Close()
+	}()
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buffer)
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return fmt.Errorf("Could not read changes of the data folder: %v", err)
+			}
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			mask := binary.NativeEndian.Uint32(buffer[offset+4:])
+			length := binary.NativeEndian.Uint32(buffer[offset+12:])
+
+			if mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED|syscall.IN_MOVE_SELF|syscall.IN_UNMOUNT) != 0 {
+				changed()
+				return fmt.Errorf("The data folder is not watched anymore.")
+			}
+
+			offset += syscall.SizeofInotifyEvent + int(length)
+		}
+
+		changed()
+	}
+}
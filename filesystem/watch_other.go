@@ -0,0 +1,11 @@
+//go:build !linux
+
+package filesystem
+
+import "errors"
+
+// WatchDataFolder is only available on Linux, other platforms have to poll
+// the data folder.
+func (s *Storage) WatchDataFolder(done <-chan struct{}, changed func()) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,181 @@
+package search
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Only the beginning of large files is indexed. PDF files have to be read as a
+// whole, so larger ones aren't indexed at all.
+const maxPDFFileSize int64 = 64 * 1024 * 1024
+const maxTextSize int = 16 * 1024 * 1024
+
+// Terms are the words of a text, lowercased. Shorter words are too common to
+// be useful, longer ones are mostly hashes or encoded data.
+const maxTermLength int = 64
+const minTermLength int = 2
+
+const sniffLength int = 1024
+
+// windows1252 maps the bytes 0x80 to 0x9f of Windows-1252, which is what
+// files that aren't UTF-8 and the text of simple PDF fonts mostly use. Every
+// other byte is the same as in ISO 8859-1.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// extractText returns the text of the file at path. Plain text files, which
+// includes Markdown and source code, are recognized by their content, PDF
+// files by their header. For every other file, it returns an empty text.
+// Uploads are untrusted, so a panic while extracting the text of one is
+// returned as an error instead of taking down the server.
+func extractText(path string) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			text = ""
+			err = fmt.Errorf("Extracting the text panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	head = head[:n]
+
+	content := io.MultiReader(bytes.NewReader(head), file)
+
+	if bytes.Contains(head, []byte("%PDF-")) {
+		info, err := file.Stat()
+		if err != nil {
+			return "", err
+		}
+
+		if info.Size() > maxPDFFileSize {
+			return "", fmt.Errorf("PDF files larger than %d MiB are not indexed", maxPDFFileSize/1024/1024)
+		}
+
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return "", err
+		}
+
+		return extractPDFText(data)
+	}
+
+	if !isText(head) {
+		return "", nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(content, int64(maxTextSize)))
+	if err != nil {
+		return "", err
+	}
+
+	return decodeText(data), nil
+}
+
+// decodeText returns data as UTF-8. Text that isn't valid UTF-8 is taken to be
+// Windows-1252.
+func decodeText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	if utf8.Valid(data) {
+		return string(data)
+	}
+
+	// A text that was cut off may end in the middle of a character.
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if !utf8.FullRune(data[len(data)-i:]) && utf8.Valid(data[:len(data)-i]) {
+			return string(data[:len(data)-i])
+		}
+	}
+
+	return decodeWindows1252(data)
+}
+
+func decodeWindows1252(data []byte) string {
+	var text strings.Builder
+	text.Grow(len(data))
+
+	for _, b := range data {
+		if b >= 0x80 && b < 0xa0 {
+			text.WriteRune(windows1252[b-0x80])
+			continue
+		}
+		text.WriteRune(rune(b))
+	}
+
+	return text.String()
+}
+
+// forEachTerm calls fn for every term of text along with its position in text,
+// until fn returns false.
+func forEachTerm(text string, fn func(term string, start int, end int) bool) {
+	start := -1
+
+	emit := func(end int) bool {
+		word := text[start:end]
+		start = -1
+
+		length := utf8.RuneCountInString(word)
+		if length < minTermLength || length > maxTermLength {
+			return true
+		}
+
+		return fn(strings.ToLower(word), end-len(word), end)
+	}
+
+	for i, r := range text {
+		if isTermRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 && !emit(i) {
+			return
+		}
+	}
+
+	if start >= 0 {
+		emit(len(text))
+	}
+}
+
+// isText tells plain text from binary files by the beginning of their
+// content: text has no NUL bytes and hardly any other control characters.
+func isText(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+
+	controls := 0
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != 0x1b {
+			controls++
+		}
+	}
+
+	return controls*100 <= len(head)
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
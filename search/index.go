@@ -0,0 +1,553 @@
+// Package search keeps a full-text index of the files in the data folder. The
+// index is an inverted index, which maps every term to the files containing
+// it. It is held in memory and saved to the index folder, along with the text
+// of every file for the snippets of the search results.
+package search
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+// The parameters of the Okapi BM25 ranking function.
+const bm25B float64 = 0.75
+const bm25K1 float64 = 1.2
+
+// indexFormatVersion is raised whenever the format of the index file changes,
+// so older index files are rebuilt instead of being misread.
+const indexFormatVersion int = 1
+const nameIndexFile string = "index.gob"
+const nameTextFolder string = "text"
+
+// snippetContext is the number of bytes shown before the first match of a
+// search result. Twice as many are shown after it.
+const snippetContext int = 80
+
+// ErrEmptyQuery is returned by Search if the query has no terms.
+var ErrEmptyQuery = errors.New("empty query")
+
+// Index is the full-text index of the files in the data folder. Sync has to be
+// called whenever files changed, it may not be called concurrently. Search can
+// be called at any time.
+type Index struct {
+	config    *config.Config
+	documents map[uint32]document
+	ids       map[string]uint32
+	mutex     sync.RWMutex
+	nextID    uint32
+	postings  map[string][]posting
+}
+
+// Result is a file that matches a query.
+type Result struct {
+	ModifiedAt time.Time
+	Name       string
+	Score      float64
+	Size       int64
+	Snippet    string
+}
+
+// document is a file as it was indexed. A file gets a new ID whenever it is
+// indexed again, so postings are always sorted by document.
+type document struct {
+	Length     int
+	ModifiedAt time.Time
+	Name       string
+	Size       int64
+}
+
+// indexFile is what is saved to the index folder.
+type indexFile struct {
+	Documents map[uint32]document
+	NextID    uint32
+	Postings  map[string][]posting
+	Version   int
+}
+
+// posting records how often a term occurs in a document.
+type posting struct {
+	Count    uint32
+	Document uint32
+}
+
+// queryTerm is a term of a query. Prefix terms match every term that starts
+// with them.
+type queryTerm struct {
+	prefix bool
+	text   string
+}
+
+func New(c *config.Config) *Index {
+	return &Index{
+		config:    c,
+		documents: map[uint32]document{},
+		ids:       map[string]uint32{},
+		postings:  map[string][]posting{},
+	}
+}
+
+// Load reads the index saved by a previous run. An index that can't be read
+// is discarded, Sync builds it again.
+func (index *Index) Load() error {
+	file, err := os.Open(index.getPathIndexFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not open index '%s': %v", index.getPathIndexFile(), err)
+	}
+	defer file.Close()
+
+	var saved indexFile
+	err = gob.NewDecoder(file).Decode(&saved)
+	if err == nil && saved.Version != indexFormatVersion {
+		err = fmt.Errorf("unsupported version %d", saved.Version)
+	}
+	if err != nil {
+		index.config.Logger.Warn("Could not read index, rebuilding it", "path", index.getPathIndexFile(), "error", err)
+
+		err = os.RemoveAll(index.getPathTextFolder())
+		if err != nil {
+			return fmt.Errorf("Could not delete text folder '%s': %v", index.getPathTextFolder(), err)
+		}
+
+		return nil
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	if saved.Documents != nil {
+		index.documents = saved.Documents
+	}
+	index.nextID = saved.NextID
+	if saved.Postings != nil {
+		index.postings = saved.Postings
+	}
+
+	index.ids = make(map[string]uint32, len(index.documents))
+	for id, doc := range index.documents {
+		index.ids[doc.Name] = id
+	}
+
+	return nil
+}
+
+// Search returns the files that contain every term of query, the best
+// matches first. A term ending with * matches every term that starts with
+// it. At most limit results are returned.
+func (index *Index) Search(query string, limit int) ([]Result, error) {
+	terms := parseQuery(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	index.mutex.RLock()
+
+	var scores map[uint32]float64
+	for i, term := range terms {
+		matches := index.getScores(term)
+
+		if i == 0 {
+			scores = matches
+			continue
+		}
+
+		for id := range scores {
+			score, matched := matches[id]
+			if !matched {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += score
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	ids := make(map[string]uint32, len(scores))
+	for id, score := range scores {
+		doc := index.documents[id]
+		results = append(results, Result{
+			ModifiedAt: doc.ModifiedAt,
+			Name:       doc.Name,
+			Score:      score,
+			Size:       doc.Size,
+		})
+		ids[doc.Name] = id
+	}
+
+	index.mutex.RUnlock()
+
+	slices.SortFunc(results, func(a, b Result) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Name, b.Name))
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		text, err := index.readText(ids[results[i].Name])
+		if err != nil {
+			index.config.Logger.Warn("Could not read indexed text", "file", results[i].Name, "error", err)
+			continue
+		}
+		results[i].Snippet = getSnippet(text, terms)
+	}
+
+	return results, nil
+}
+
+// Sync brings the index up to date with files, the files in the data folder.
+// New and changed files are indexed, deleted ones are removed. If done is
+// closed, it saves what was indexed so far and returns.
+func (index *Index) Sync(files []filesystem.File, done <-chan struct{}) error {
+	index.mutex.Lock()
+
+	exists := make(map[string]bool, len(files))
+	outdated := map[uint32]bool{}
+	pending := []filesystem.File{}
+
+	for _, file := range files {
+		exists[file.Name] = true
+
+		id, indexed := index.ids[file.Name]
+		if indexed && index.documents[id].ModifiedAt.Equal(file.ModifiedAt) && index.documents[id].Size == file.Size {
+			continue
+		}
+		if indexed {
+			outdated[id] = true
+		}
+
+		pending = append(pending, file)
+	}
+
+	for name, id := range index.ids {
+		if !exists[name] {
+			outdated[id] = true
+		}
+	}
+
+	// Changed files are removed right away, so they don't show up with their
+	// old content until they are indexed again.
+	index.removeDocuments(outdated)
+	index.mutex.Unlock()
+
+	changed := len(outdated) > 0
+
+	for _, file := range pending {
+		select {
+		case <-done:
+			return index.save()
+		default:
+		}
+
+		// Files that can't be indexed are added without any text, so they
+		// aren't tried again until they change.
+		text, err := extractText(filepath.Join(index.config.PathDataFolder, file.Name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			index.config.Logger.Warn("Could not extract text", "file", file.Name, "error", err)
+		}
+
+		err = index.addDocument(file, text)
+		if err != nil {
+			index.config.Logger.Warn("Could not index file", "file", file.Name, "error", err)
+			continue
+		}
+
+		index.config.Logger.Debug("Indexed file", "file", file.Name, "size", file.Size)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return index.save()
+}
+
+// addDocument adds file with its text to the index.
+func (index *Index) addDocument(file filesystem.File, text string) error {
+	counts := map[string]uint32{}
+	length := 0
+	forEachTerm(text, func(term string, start int, end int) bool {
+		counts[term]++
+		length++
+		return true
+	})
+
+	index.mutex.Lock()
+	id := index.nextID
+	index.nextID++
+	index.mutex.Unlock()
+
+	if text != "" {
+		err := index.writeText(id, text)
+		if err != nil {
+			return err
+		}
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.documents[id] = document{
+		Length:     length,
+		ModifiedAt: file.ModifiedAt,
+		Name:       file.Name,
+		Size:       file.Size,
+	}
+	index.ids[file.Name] = id
+
+	for term, count := range counts {
+		index.postings[term] = append(index.postings[term], posting{Count: count, Document: id})
+	}
+
+	return nil
+}
+
+func (index *Index) getPathIndexFile() string {
+	return filepath.Join(index.config.GetPathIndexFolder(), nameIndexFile)
+}
+
+func (index *Index) getPathTextFile(id uint32) string {
+	return filepath.Join(index.getPathTextFolder(), strconv.FormatUint(uint64(id), 10)+".txt.gz")
+}
+
+func (index *Index) getPathTextFolder() string {
+	return filepath.Join(index.config.GetPathIndexFolder(), nameTextFolder)
+}
+
+// getScores returns the BM25 scores of the documents that match term. The
+// caller must hold the mutex.
+func (index *Index) getScores(term queryTerm) map[uint32]float64 {
+	scores := map[uint32]float64{}
+	if len(index.documents) == 0 {
+		return scores
+	}
+
+	totalLength := 0
+	for _, doc := range index.documents {
+		totalLength += doc.Length
+	}
+	averageLength := max(float64(totalLength)/float64(len(index.documents)), 1)
+
+	add := func(postings []posting) {
+		n := float64(len(postings))
+		idf := math.Log(1 + (float64(len(index.documents))-n+0.5)/(n+0.5))
+
+		for _, p := range postings {
+			length := float64(index.documents[p.Document].Length)
+			tf := float64(p.Count)
+			scores[p.Document] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/averageLength))
+		}
+	}
+
+	if !term.prefix {
+		add(index.postings[term.text])
+		return scores
+	}
+
+	for text, postings := range index.postings {
+		if strings.HasPrefix(text, term.text) {
+			add(postings)
+		}
+	}
+
+	return scores
+}
+
+func (index *Index) readText(id uint32) (string, error) {
+	file, err := os.Open(index.getPathTextFile(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return string(text), nil
+}
+
+// removeDocuments removes the documents with the given IDs and their text.
+// The caller must hold the mutex.
+func (index *Index) removeDocuments(ids map[uint32]bool) {
+	if len(ids) == 0 {
+		return
+	}
+
+	for term, postings := range index.postings {
+		postings = slices.DeleteFunc(postings, func(p posting) bool { return ids[p.Document] })
+		if len(postings) == 0 {
+			delete(index.postings, term)
+			continue
+		}
+		index.postings[term] = postings
+	}
+
+	for id := range ids {
+		delete(index.ids, index.documents[id].Name)
+		delete(index.documents, id)
+
+		err := os.Remove(index.getPathTextFile(id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			index.config.Logger.Warn("Could not delete indexed text", "path", index.getPathTextFile(id), "error", err)
+		}
+	}
+}
+
+// save writes the index to a temporary file first, so a crash never leaves a
+// partially written index behind.
+func (index *Index) save() error {
+	pathTemporaryFile := index.getPathIndexFile() + ".tmp"
+
+	file, err := os.Create(pathTemporaryFile)
+	if err != nil {
+		return fmt.Errorf("Could not save index '%s': %v", index.getPathIndexFile(), err)
+	}
+	defer os.Remove(pathTemporaryFile)
+
+	index.mutex.RLock()
+	err = gob.NewEncoder(file).Encode(indexFile{
+		Documents: index.documents,
+		NextID:    index.nextID,
+		Postings:  index.postings,
+		Version:   indexFormatVersion,
+	})
+	index.mutex.RUnlock()
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Could not save index '%s': %v", index.getPathIndexFile(), err)
+	}
+
+	err = os.Rename(pathTemporaryFile, index.getPathIndexFile())
+	if err != nil {
+		return fmt.Errorf("Could not save index '%s': %v", index.getPathIndexFile(), err)
+	}
+
+	return nil
+}
+
+func (index *Index) writeText(id uint32, text string) error {
+	err := os.MkdirAll(index.getPathTextFolder(), 0755)
+	if err != nil {
+		return fmt.Errorf("Could not create text folder '%s': %v", index.getPathTextFolder(), err)
+	}
+
+	file, err := os.Create(index.getPathTextFile(id))
+	if err != nil {
+		return fmt.Errorf("Could not save text '%s': %v", index.getPathTextFile(id), err)
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+
+	_, err = io.WriteString(writer, text)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Could not save text '%s': %v", index.getPathTextFile(id), err)
+	}
+
+	return nil
+}
+
+// getSnippet returns the part of text around the first term that matches one
+// of terms, with its whitespace collapsed.
+func getSnippet(text string, terms []queryTerm) string {
+	start, end := -1, -1
+	forEachTerm(text, func(term string, termStart int, termEnd int) bool {
+		for _, t := range terms {
+			if term == t.text || t.prefix && strings.HasPrefix(term, t.text) {
+				start, end = termStart, termEnd
+				return false
+			}
+		}
+		return true
+	})
+
+	if start < 0 {
+		return ""
+	}
+
+	from := max(start-snippetContext, 0)
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+
+	to := min(end+2*snippetContext, len(text))
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+
+	snippet := strings.Join(strings.Fields(text[from:to]), " ")
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(text) {
+		snippet += "…"
+	}
+
+	return snippet
+}
+
+// parseQuery returns the distinct terms of query. A word ending with * is a
+// prefix.
+func parseQuery(query string) []queryTerm {
+	terms := []queryTerm{}
+
+	for _, word := range strings.Fields(query) {
+		words := []string{}
+		forEachTerm(word, func(term string, start int, end int) bool {
+			words = append(words, term)
+			return true
+		})
+
+		for i, text := range words {
+			term := queryTerm{
+				prefix: i == len(words)-1 && strings.HasSuffix(word, "*"),
+				text:   text,
+			}
+			if !slices.Contains(terms, term) {
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	return terms
+}
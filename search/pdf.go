@@ -0,0 +1,950 @@
+package search
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Limits that keep malformed or malicious PDF files from using up memory or
+// from sending the extraction into endless recursion. The inflated size is
+// the total of every stream of a file, so a file can't get around it by
+// splitting a deflate bomb into many streams or by using a stream many times.
+const maxPDFCMapRange int = 65536
+const maxPDFInflatedSize int64 = 256 * 1024 * 1024
+const maxPDFNesting int = 64
+const maxPDFOperands int = 64
+const maxPDFXObjectNesting int = 8
+
+var errPDFEncrypted = errors.New("encrypted PDF files are not supported")
+var errPDFInflatedTooLarge = errors.New("PDF streams inflate to too much data")
+var errPDFNestedTooDeep = errors.New("PDF objects are nested too deep")
+var errPDFNoPages = errors.New("PDF file has no pages")
+
+// pdfObjectPattern finds the beginnings of indirect objects. The cross
+// reference table isn't used, so files with a broken one can still be read.
+var pdfObjectPattern = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+
+// The objects of the PDF syntax. Numbers are float64, booleans bool and null
+// is nil. Operators of content streams and everything else that isn't an
+// object is a pdfKeyword.
+type pdfArray []any
+type pdfDict map[pdfName]any
+type pdfKeyword string
+type pdfName string
+type pdfRef int
+type pdfString []byte
+
+// pdfCMap maps the character codes of a font to Unicode text.
+type pdfCMap struct {
+	codeLengths []int
+	codes       map[string]string
+}
+
+// pdfDocument holds the objects of a PDF file by their number. inflated
+// counts the bytes its streams were inflated to so far, see
+// maxPDFInflatedSize.
+type pdfDocument struct {
+	fonts    map[pdfRef]*pdfFont
+	inflated int64
+	objects  map[pdfRef]any
+}
+
+// pdfFont decodes the strings that are shown in this font. Without a CMap,
+// composite fonts can't be decoded and simple fonts are taken to use
+// Windows-1252.
+type pdfFont struct {
+	cmap      *pdfCMap
+	composite bool
+}
+
+// pdfParser reads objects and keywords from the PDF syntax in data.
+type pdfParser struct {
+	data     []byte
+	position int
+}
+
+type pdfStream struct {
+	data []byte
+	dict pdfDict
+}
+
+// addRange maps the codes from low to high either to consecutive characters
+// starting with unicode or to the strings of an array.
+func (cmap *pdfCMap) addRange(low pdfString, high pdfString, unicode any) {
+	first := decodeBigEndian(low)
+	last := decodeBigEndian(high)
+	if last < first || last-first >= maxPDFCMapRange {
+		return
+	}
+
+	for i := range last - first + 1 {
+		code := make([]byte, len(low))
+		value := first + i
+		for j := len(code) - 1; j >= 0; j-- {
+			code[j] = byte(value)
+			value >>= 8
+		}
+
+		switch unicode := unicode.(type) {
+		case pdfString:
+			runes := []rune(decodeUTF16BE(unicode))
+			if len(runes) == 0 {
+				return
+			}
+			runes[len(runes)-1] += rune(i)
+			cmap.codes[string(code)] = string(runes)
+		case pdfArray:
+			if i >= len(unicode) {
+				return
+			}
+			if s, ok := unicode[i].(pdfString); ok {
+				cmap.codes[string(code)] = decodeUTF16BE(s)
+			}
+		}
+	}
+}
+
+// decode returns the text of the character codes in s. Codes without a
+// mapping are left out.
+func (cmap *pdfCMap) decode(s []byte) string {
+	var text strings.Builder
+
+	for len(s) > 0 {
+		decoded := false
+		for _, length := range cmap.codeLengths {
+			if length > len(s) {
+				break
+			}
+
+			if unicode, exists := cmap.codes[string(s[:length])]; exists {
+				text.WriteString(unicode)
+				s = s[length:]
+				decoded = true
+				break
+			}
+		}
+
+		if !decoded {
+			s = s[min(cmap.codeLengths[0], len(s)):]
+		}
+	}
+
+	return text.String()
+}
+
+// decodeStream applies the filters of stream to its data. Only FlateDecode is
+// supported, which is what the text of almost every PDF file is compressed
+// with.
+func (doc *pdfDocument) decodeStream(stream pdfStream) ([]byte, error) {
+	var filters []any
+	switch filter := doc.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{filter}
+	case pdfArray:
+		filters = filter
+	}
+
+	if len(filters) > 0 {
+		if parameters, ok := doc.resolve(stream.dict["DecodeParms"]).(pdfDict); ok {
+			if predictor, ok := doc.resolve(parameters["Predictor"]).(float64); ok && predictor > 1 {
+				return nil, fmt.Errorf("PDF predictor %v is not supported", predictor)
+			}
+		}
+	}
+
+	data := stream.data
+	for _, filter := range filters {
+		switch doc.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			decoded, err := doc.inflate(data)
+			if err != nil {
+				return nil, err
+			}
+			data = decoded
+		default:
+			return nil, fmt.Errorf("PDF filter %v is not supported", filter)
+		}
+	}
+
+	return data, nil
+}
+
+// extractContent appends the text shown by the content stream in data to
+// text. Strings are shown as a text, positioning them separates them by a
+// space, which is enough to tell the words apart.
+func (doc *pdfDocument) extractContent(data []byte, resources pdfDict, text *strings.Builder, nesting int) {
+	parser := &pdfParser{data: data}
+	operands := []any{}
+	font := &pdfFont{}
+
+	for text.Len() < maxTextSize {
+		value, err := parser.next(0)
+		if err != nil {
+			return
+		}
+
+		operator, isOperator := value.(pdfKeyword)
+		if !isOperator {
+			if len(operands) == maxPDFOperands {
+				operands = operands[1:]
+			}
+			operands = append(operands, value)
+			continue
+		}
+
+		var operand any
+		if len(operands) > 0 {
+			operand = operands[len(operands)-1]
+		}
+
+		switch operator {
+		case "'", "\"", "Tj":
+			if operator != "Tj" {
+				text.WriteByte(' ')
+			}
+			if s, ok := operand.(pdfString); ok {
+				text.WriteString(font.decode(s))
+			}
+		case "TJ":
+			array, _ := operand.(pdfArray)
+			for _, item := range array {
+				switch item := item.(type) {
+				case pdfString:
+					text.WriteString(font.decode(item))
+				case float64:
+					// Large gaps between the strings of an array are
+					// spaces between words.
+					if item < -200 {
+						text.WriteByte(' ')
+					}
+				}
+			}
+		case "ET", "T*", "TD", "Td", "Tm":
+			text.WriteByte(' ')
+		case "Tf":
+			if len(operands) >= 2 {
+				name, _ := operands[len(operands)-2].(pdfName)
+				font = doc.getFont(resources, name)
+			}
+		case "Do":
+			name, _ := operand.(pdfName)
+			doc.extractXObject(resources, name, text, nesting)
+		case "ID":
+			parser.skipInlineImage()
+		}
+
+		operands = operands[:0]
+	}
+}
+
+// extractXObject appends the text of a form XObject, which is a content
+// stream of its own that pages can include. Images are skipped.
+func (doc *pdfDocument) extractXObject(resources pdfDict, name pdfName, text *strings.Builder, nesting int) {
+	if nesting >= maxPDFXObjectNesting {
+		return
+	}
+
+	xobjects, _ := doc.resolve(resources["XObject"]).(pdfDict)
+	stream, ok := doc.resolve(xobjects[name]).(pdfStream)
+	if !ok || doc.resolve(stream.dict["Subtype"]) != pdfName("Form") {
+		return
+	}
+
+	data, err := doc.decodeStream(stream)
+	if err != nil {
+		return
+	}
+
+	if formResources, ok := doc.resolve(stream.dict["Resources"]).(pdfDict); ok {
+		resources = formResources
+	}
+
+	doc.extractContent(data, resources, text, nesting+1)
+	text.WriteByte(' ')
+}
+
+// getFont returns the font the resources of a page know by name.
+func (doc *pdfDocument) getFont(resources pdfDict, name pdfName) *pdfFont {
+	fonts, _ := doc.resolve(resources["Font"]).(pdfDict)
+
+	ref, isRef := fonts[name].(pdfRef)
+	if isRef && doc.fonts[ref] != nil {
+		return doc.fonts[ref]
+	}
+
+	font := &pdfFont{}
+
+	dict, _ := doc.resolve(fonts[name]).(pdfDict)
+	font.composite = doc.resolve(dict["Subtype"]) == pdfName("Type0")
+
+	if stream, ok := doc.resolve(dict["ToUnicode"]).(pdfStream); ok {
+		data, err := doc.decodeStream(stream)
+		if err == nil {
+			font.cmap = parsePDFCMap(data)
+		}
+	}
+
+	if isRef {
+		doc.fonts[ref] = font
+	}
+
+	return font
+}
+
+// getPages returns the resources and the content of every page in the order
+// of the page tree. Files without a usable page tree have their pages
+// returned in the order of their object numbers.
+func (doc *pdfDocument) getPages() []pdfDict {
+	pages := []pdfDict{}
+
+	for _, ref := range doc.getSortedRefs() {
+		catalog, ok := doc.objects[ref].(pdfDict)
+		if !ok || catalog["Type"] != pdfName("Catalog") {
+			continue
+		}
+
+		doc.walkPageTree(catalog["Pages"], nil, map[pdfRef]bool{}, &pages)
+		if len(pages) > 0 {
+			return pages
+		}
+	}
+
+	for _, ref := range doc.getSortedRefs() {
+		page, ok := doc.objects[ref].(pdfDict)
+		if ok && page["Type"] == pdfName("Page") {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages
+}
+
+func (doc *pdfDocument) getSortedRefs() []pdfRef {
+	refs := make([]pdfRef, 0, len(doc.objects))
+	for ref := range doc.objects {
+		refs = append(refs, ref)
+	}
+	slices.Sort(refs)
+
+	return refs
+}
+
+// inflate decompresses zlib data. Damaged streams are common in PDF files, so
+// whatever could be decompressed before an error is kept. It fails once the
+// streams of the document were inflated to more than maxPDFInflatedSize.
+func (doc *pdfDocument) inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		reader = flate.NewReader(bytes.NewReader(data))
+	}
+	defer reader.Close()
+
+	// Reading one byte more than what is left tells a stream that fits from
+	// one that doesn't.
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxPDFInflatedSize-doc.inflated+1))
+	doc.inflated += int64(len(decoded))
+	if doc.inflated > maxPDFInflatedSize {
+		return nil, errPDFInflatedTooLarge
+	}
+	if err != nil && len(decoded) == 0 {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+// parseObjectStream adds the objects that are compressed into stream. Objects
+// that also exist outside of object streams are kept as they are.
+func (doc *pdfDocument) parseObjectStream(stream pdfStream) {
+	first, _ := doc.resolve(stream.dict["First"]).(float64)
+	count, _ := doc.resolve(stream.dict["N"]).(float64)
+
+	data, err := doc.decodeStream(stream)
+	if err != nil || int(first) > len(data) {
+		return
+	}
+
+	header := &pdfParser{data: data[:int(first)]}
+	for range int(count) {
+		number, err := header.next(0)
+		if err != nil {
+			return
+		}
+
+		offset, err := header.next(0)
+		if err != nil {
+			return
+		}
+
+		n, isNumber := number.(float64)
+		o, isOffset := offset.(float64)
+		if !isNumber || !isOffset || o < 0 || int(first)+int(o) >= len(data) {
+			continue
+		}
+
+		if _, exists := doc.objects[pdfRef(n)]; exists {
+			continue
+		}
+
+		parser := &pdfParser{data: data, position: int(first) + int(o)}
+		object, err := parser.next(0)
+		if err == nil {
+			doc.objects[pdfRef(n)] = object
+		}
+	}
+}
+
+// resolve follows references until it reaches an object. References to
+// objects that don't exist resolve to null.
+func (doc *pdfDocument) resolve(value any) any {
+	for range maxPDFNesting {
+		ref, isRef := value.(pdfRef)
+		if !isRef {
+			return value
+		}
+		value = doc.objects[ref]
+	}
+
+	return nil
+}
+
+// walkPageTree appends the pages below node to pages. Pages inherit the
+// resources of their ancestors.
+func (doc *pdfDocument) walkPageTree(node any, resources pdfDict, visited map[pdfRef]bool, pages *[]pdfDict) {
+	if ref, isRef := node.(pdfRef); isRef {
+		if visited[ref] {
+			return
+		}
+		visited[ref] = true
+	}
+
+	dict, ok := doc.resolve(node).(pdfDict)
+	if !ok {
+		return
+	}
+
+	if own, ok := doc.resolve(dict["Resources"]).(pdfDict); ok {
+		resources = own
+	}
+
+	kids, hasKids := doc.resolve(dict["Kids"]).(pdfArray)
+	if !hasKids {
+		page := pdfDict{"Contents": dict["Contents"], "Resources": resources}
+		*pages = append(*pages, page)
+		return
+	}
+
+	for _, kid := range kids {
+		doc.walkPageTree(kid, resources, visited, pages)
+	}
+}
+
+func (font *pdfFont) decode(s pdfString) string {
+	switch {
+	case font.cmap != nil:
+		return font.cmap.decode(s)
+	case font.composite:
+		return ""
+	default:
+		return decodeWindows1252(s)
+	}
+}
+
+// next returns the next object or keyword. Arrays and dictionaries are read
+// as a whole, references within them are resolved to pdfRef.
+func (p *pdfParser) next(nesting int) (any, error) {
+	if nesting > maxPDFNesting {
+		return nil, errPDFNestedTooDeep
+	}
+
+	p.skipWhitespace()
+	if p.position >= len(p.data) {
+		return nil, io.EOF
+	}
+
+	c := p.data[p.position]
+	switch {
+	case c == '/':
+		return p.readName(), nil
+	case c == '(':
+		return p.readLiteralString(), nil
+	case c == '<' && p.peek(1) == '<':
+		p.position += 2
+		return p.readDict(nesting)
+	case c == '<':
+		return p.readHexString(), nil
+	case c == '>' && p.peek(1) == '>':
+		p.position += 2
+		return pdfKeyword(">>"), nil
+	case c == '[':
+		p.position++
+		items, err := p.readSequence("]", nesting)
+		return pdfArray(items), err
+	case isPDFDelimiter(c):
+		p.position++
+		return pdfKeyword(p.data[p.position-1 : p.position]), nil
+	}
+
+	start := p.position
+	for p.position < len(p.data) && !isPDFWhitespace(p.data[p.position]) && !isPDFDelimiter(p.data[p.position]) {
+		p.position++
+	}
+	token := string(p.data[start:p.position])
+
+	if strings.ContainsRune("+-.0123456789", rune(token[0])) {
+		number, err := strconv.ParseFloat(token, 64)
+		if err == nil {
+			return number, nil
+		}
+	}
+
+	switch token {
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	}
+
+	return pdfKeyword(token), nil
+}
+
+func (p *pdfParser) peek(offset int) byte {
+	if p.position+offset >= len(p.data) {
+		return 0
+	}
+
+	return p.data[p.position+offset]
+}
+
+func (p *pdfParser) readDict(nesting int) (pdfDict, error) {
+	items, err := p.readSequence(">>", nesting)
+	if err != nil {
+		return nil, err
+	}
+
+	dict := pdfDict{}
+	for i := 0; i+1 < len(items); i++ {
+		key, isName := items[i].(pdfName)
+		if !isName {
+			continue
+		}
+
+		dict[key] = items[i+1]
+		i++
+	}
+
+	return dict, nil
+}
+
+func (p *pdfParser) readHexString() pdfString {
+	p.position++
+
+	s := pdfString{}
+	high := -1
+	for p.position < len(p.data) {
+		c := p.data[p.position]
+		p.position++
+
+		if c == '>' {
+			break
+		}
+
+		digit, err := strconv.ParseUint(string(c), 16, 8)
+		if err != nil {
+			continue
+		}
+
+		if high < 0 {
+			high = int(digit)
+			continue
+		}
+
+		s = append(s, byte(high<<4|int(digit)))
+		high = -1
+	}
+
+	if high >= 0 {
+		s = append(s, byte(high<<4))
+	}
+
+	return s
+}
+
+func (p *pdfParser) readLiteralString() pdfString {
+	p.position++
+
+	s := pdfString{}
+	depth := 1
+	for p.position < len(p.data) {
+		c := p.data[p.position]
+		p.position++
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s
+			}
+		case '\\':
+			if p.position >= len(p.data) {
+				return s
+			}
+
+			c = p.data[p.position]
+			p.position++
+
+			switch c {
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case '\r', '\n':
+				// A backslash at the end of a line continues the string on
+				// the next line.
+				if c == '\r' && p.peek(0) == '\n' {
+					p.position++
+				}
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				code := int(c - '0')
+				for i := 0; i < 2 && p.peek(0) >= '0' && p.peek(0) <= '7'; i++ {
+					code = code<<3 | int(p.peek(0)-'0')
+					p.position++
+				}
+				c = byte(code)
+			}
+		}
+
+		s = append(s, c)
+	}
+
+	return s
+}
+
+func (p *pdfParser) readName() pdfName {
+	p.position++
+
+	var name strings.Builder
+	for p.position < len(p.data) && !isPDFWhitespace(p.data[p.position]) && !isPDFDelimiter(p.data[p.position]) {
+		c := p.data[p.position]
+		p.position++
+
+		if c == '#' && p.position+2 <= len(p.data) {
+			code, err := strconv.ParseUint(string(p.data[p.position:p.position+2]), 16, 8)
+			if err == nil {
+				c = byte(code)
+				p.position += 2
+			}
+		}
+
+		name.WriteByte(c)
+	}
+
+	return pdfName(name.String())
+}
+
+// readSequence reads the items of an array or a dictionary up to the keyword
+// end. Two numbers followed by R are a reference.
+func (p *pdfParser) readSequence(end pdfKeyword, nesting int) ([]any, error) {
+	items := []any{}
+
+	for {
+		value, err := p.next(nesting + 1)
+		if errors.Is(err, io.EOF) {
+			return items, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if keyword, isKeyword := value.(pdfKeyword); isKeyword {
+			if keyword == end {
+				return items, nil
+			}
+
+			if keyword == "R" && len(items) >= 2 {
+				number, isNumber := items[len(items)-2].(float64)
+				_, isGeneration := items[len(items)-1].(float64)
+				if isNumber && isGeneration {
+					items = append(items[:len(items)-2], pdfRef(number))
+					continue
+				}
+			}
+		}
+
+		items = append(items, value)
+	}
+}
+
+// readStream reads the data of the stream that follows the dictionary dict.
+// The length in dict is only trusted if endstream follows it, as it is often
+// wrong or a reference.
+func (p *pdfParser) readStream(dict pdfDict) pdfStream {
+	if p.peek(0) == '\r' {
+		p.position++
+	}
+	if p.peek(0) == '\n' {
+		p.position++
+	}
+
+	start := p.position
+
+	if length, ok := dict["Length"].(float64); ok && length >= 0 && start+int(length) <= len(p.data) {
+		end := start + int(length)
+		rest := bytes.TrimLeft(p.data[end:], "\x00\t\n\f\r ")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			p.position = end
+			return pdfStream{data: p.data[start:end], dict: dict}
+		}
+	}
+
+	end := bytes.Index(p.data[start:], []byte("endstream"))
+	if end < 0 {
+		end = len(p.data) - start
+	}
+	end += start
+	p.position = end
+
+	data := bytes.TrimSuffix(p.data[start:end], []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+
+	return pdfStream{data: data, dict: dict}
+}
+
+// skipInlineImage skips the data of an inline image, which ends with EI.
+func (p *pdfParser) skipInlineImage() {
+	p.position++
+
+	for p.position < len(p.data) {
+		i := bytes.Index(p.data[p.position:], []byte("EI"))
+		if i < 0 {
+			p.position = len(p.data)
+			return
+		}
+
+		p.position += i + 2
+		if isPDFWhitespace(p.data[p.position-3]) && (p.position == len(p.data) || isPDFWhitespace(p.data[p.position])) {
+			return
+		}
+	}
+}
+
+func (p *pdfParser) skipWhitespace() {
+	for p.position < len(p.data) {
+		c := p.data[p.position]
+
+		if c == '%' {
+			for p.position < len(p.data) && p.data[p.position] != '\n' && p.data[p.position] != '\r' {
+				p.position++
+			}
+			continue
+		}
+
+		if !isPDFWhitespace(c) {
+			return
+		}
+
+		p.position++
+	}
+}
+
+func decodeBigEndian(b []byte) int {
+	value := 0
+	for _, c := range b {
+		value = value<<8 | int(c)
+	}
+
+	return value
+}
+
+func decodeUTF16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// extractPDFText returns the text of the pages of the PDF file in data.
+func extractPDFText(data []byte) (string, error) {
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errPDFEncrypted
+	}
+
+	doc := &pdfDocument{
+		fonts:   map[pdfRef]*pdfFont{},
+		objects: map[pdfRef]any{},
+	}
+
+	// Files that were changed incrementally have newer versions of their
+	// objects further back, which replace the older ones.
+	for _, match := range pdfObjectPattern.FindAllSubmatchIndex(data, -1) {
+		number, err := strconv.Atoi(string(data[match[2]:match[3]]))
+		if err != nil {
+			continue
+		}
+
+		parser := &pdfParser{data: data, position: match[1]}
+		object, err := parser.next(0)
+		if err != nil {
+			continue
+		}
+
+		if dict, isDict := object.(pdfDict); isDict {
+			position := parser.position
+			keyword, _ := parser.next(0)
+			if keyword == pdfKeyword("stream") {
+				object = parser.readStream(dict)
+			} else {
+				parser.position = position
+			}
+		}
+
+		doc.objects[pdfRef(number)] = object
+	}
+
+	for _, ref := range doc.getSortedRefs() {
+		stream, ok := doc.objects[ref].(pdfStream)
+		if ok && stream.dict["Type"] == pdfName("ObjStm") {
+			doc.parseObjectStream(stream)
+		}
+	}
+
+	pages := doc.getPages()
+	if len(pages) == 0 {
+		return "", errPDFNoPages
+	}
+
+	text := &strings.Builder{}
+	for _, page := range pages {
+		resources, _ := doc.resolve(page["Resources"]).(pdfDict)
+
+		var contents []any
+		switch content := doc.resolve(page["Contents"]).(type) {
+		case pdfArray:
+			contents = content
+		case pdfStream:
+			contents = []any{content}
+		}
+
+		for _, content := range contents {
+			stream, ok := doc.resolve(content).(pdfStream)
+			if !ok {
+				continue
+			}
+
+			data, err := doc.decodeStream(stream)
+			if err != nil {
+				continue
+			}
+
+			doc.extractContent(data, resources, text, 0)
+			text.WriteByte(' ')
+		}
+
+		text.WriteByte('\n')
+	}
+
+	// The text of the streams that could be inflated is kept.
+	if doc.inflated > maxPDFInflatedSize {
+		return text.String(), errPDFInflatedTooLarge
+	}
+
+	return text.String(), nil
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isPDFWhitespace(c byte) bool {
+	return strings.IndexByte("\x00\t\n\f\r ", c) >= 0
+}
+
+// parsePDFCMap reads the mappings to Unicode of a ToUnicode CMap.
+func parsePDFCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{codes: map[string]string{}}
+	parser := &pdfParser{data: data}
+	operands := []any{}
+
+	for {
+		value, err := parser.next(0)
+		if err != nil {
+			break
+		}
+
+		keyword, isKeyword := value.(pdfKeyword)
+		if !isKeyword {
+			if len(operands) == maxPDFOperands*maxPDFOperands {
+				operands = operands[1:]
+			}
+			operands = append(operands, value)
+			continue
+		}
+
+		switch keyword {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if low, ok := operands[i].(pdfString); ok && len(low) > 0 && !slices.Contains(cmap.codeLengths, len(low)) {
+					cmap.codeLengths = append(cmap.codeLengths, len(low))
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, isCode := operands[i].(pdfString)
+				unicode, isUnicode := operands[i+1].(pdfString)
+				if isCode && isUnicode {
+					cmap.codes[string(code)] = decodeUTF16BE(unicode)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, isLow := operands[i].(pdfString)
+				high, isHigh := operands[i+1].(pdfString)
+				if isLow && isHigh && len(low) == len(high) && len(low) > 0 && len(low) <= 4 {
+					cmap.addRange(low, high, operands[i+2])
+				}
+			}
+		}
+
+		if strings.HasPrefix(string(keyword), "end") || strings.HasPrefix(string(keyword), "begin") {
+			operands = operands[:0]
+		}
+	}
+
+	if len(cmap.codeLengths) == 0 {
+		for code := range cmap.codes {
+			if !slices.Contains(cmap.codeLengths, len(code)) {
+				cmap.codeLengths = append(cmap.codeLengths, len(code))
+			}
+		}
+	}
+
+	if len(cmap.codeLengths) == 0 {
+		cmap.codeLengths = []int{1}
+	}
+
+	slices.Sort(cmap.codeLengths)
+
+	return cmap
+}
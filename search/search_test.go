@@ -0,0 +1,321 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+)
+
+func newTestIndex(t *testing.T) (*Index, *filesystem.Storage) {
+	t.Helper()
+
+	c := config.New()
+	c.HttpMode = true
+	c.Logger = slog.New(slog.DiscardHandler)
+	c.PathDataFolder = filepath.Join(t.TempDir(), "data")
+	c.SearchMode = true
+
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("config.Init() failed: %v", err)
+	}
+
+	s, err := filesystem.New(c)
+	if err != nil {
+		t.Fatalf("filesystem.New() failed: %v", err)
+	}
+
+	return New(c), s
+}
+
+// newTestPDF returns a PDF file made of objects, which are numbered from 1.
+func newTestPDF(objects ...string) []byte {
+	var pdf bytes.Buffer
+
+	pdf.WriteString("%PDF-1.7\n")
+	for i, object := range objects {
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	return pdf.Bytes()
+}
+
+func newTestPDFStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func deflateTestData(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write([]byte(data))
+	writer.Close()
+
+	return compressed.Bytes()
+}
+
+func searchTestIndex(t *testing.T, index *Index, query string) []string {
+	t.Helper()
+
+	results, err := index.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search(%q) failed: %v", query, err)
+	}
+
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Name)
+	}
+
+	return names
+}
+
+func syncTestIndex(t *testing.T, index *Index, s *filesystem.Storage) {
+	t.Helper()
+
+	files, err := s.GetFilesOfDataFolder()
+	if err != nil {
+		t.Fatalf("GetFilesOfDataFolder() failed: %v", err)
+	}
+
+	err = index.Sync(files, nil)
+	if err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+}
+
+func Test_decodeText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{
+			name:  "1",
+			input: []byte("plain text"),
+			want:  "plain text",
+		},
+		{
+			name:  "2",
+			input: []byte("\xef\xbb\xbfGrüße"),
+			want:  "Grüße",
+		},
+		{
+			name:  "3",
+			input: []byte("Gr\xfc\xdfe \x80"),
+			want:  "Grüße €",
+		},
+		{
+			name:  "4",
+			input: []byte("cut off \xe2\x82"),
+			want:  "cut off ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeText(tt.input); got != tt.want {
+				t.Errorf("\ndecodeText()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_extractPDFText(t *testing.T) {
+	catalog := "<< /Type /Catalog /Pages 2 0 R >>"
+	pages := "<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F1 4 0 R /F2 6 0 R >> >> >>"
+	page := "<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>"
+	font := "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+	compositeFont := "<< /Type /Font /Subtype /Type0 /BaseFont /Arial /ToUnicode 7 0 R >>"
+	cmap := "/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"1 beginbfchar <0001> <0048> endbfchar\n" +
+		"1 beginbfrange <0002> <0003> <0069> endbfrange\n" +
+		"endcmap CMapName currentdict /CMap defineresource pop end end"
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "1",
+			input: newTestPDF(catalog, pages, page, font,
+				newTestPDFStream("", []byte("BT /F1 12 Tf 72 712 Td (Hello World) Tj ET")),
+			),
+			want: "Hello World",
+		},
+		{
+			name: "2",
+			input: newTestPDF(catalog, pages, page, font,
+				newTestPDFStream("/Filter /FlateDecode", deflateTestData(t, "BT /F1 12 Tf [(Quar) -20 (terly) -500 (report)] TJ T* (Caf\\351 \\(draft\\)) Tj ET")),
+			),
+			want: "Quarterly report Café (draft)",
+		},
+		{
+			name: "3",
+			input: newTestPDF(catalog, pages, page, font,
+				newTestPDFStream("", []byte("BT /F2 12 Tf <00010002> Tj ( ) Tj <0003> Tj ET")),
+				compositeFont,
+				newTestPDFStream("/Filter /FlateDecode", deflateTestData(t, cmap)),
+			),
+			want: "Hij",
+		},
+		{
+			name:    "4",
+			input:   append(newTestPDF(catalog, pages, page, font), []byte("trailer\n<< /Encrypt 8 0 R >>\n")...),
+			wantErr: true,
+		},
+		{
+			name:    "5",
+			input:   newTestPDF(catalog),
+			wantErr: true,
+		},
+		{
+			name: "6",
+			input: newTestPDF(catalog, pages,
+				"<< /Type /Page /Parent 2 0 R /Contents [5 0 R 6 0 R 6 0 R 6 0 R 6 0 R 6 0 R 6 0 R 6 0 R 6 0 R 6 0 R] >>", font,
+				newTestPDFStream("", []byte("BT /F1 12 Tf (Hello) Tj ET")),
+				newTestPDFStream("/Filter /FlateDecode", deflateTestData(t, "%"+strings.Repeat("x", 32*1024*1024))),
+			),
+			want:    "Hello",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := extractPDFText(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("\nextractPDFText()\nname: %v\nwant: error %v\ngot:  %v", tt.name, tt.wantErr, err)
+			}
+
+			if got := strings.Join(strings.Fields(text), " "); got != tt.want {
+				t.Errorf("\nextractPDFText()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_parseQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []queryTerm
+	}{
+		{
+			name:  "1",
+			input: "Quarterly Report",
+			want:  []queryTerm{{text: "quarterly"}, {text: "report"}},
+		},
+		{
+			name:  "2",
+			input: "deploy* a report REPORT",
+			want:  []queryTerm{{prefix: true, text: "deploy"}, {text: "report"}},
+		},
+		{
+			name:  "3",
+			input: "error_code:42*",
+			want:  []queryTerm{{text: "error"}, {text: "code"}, {prefix: true, text: "42"}},
+		},
+		{
+			name:  "4",
+			input: " * a ",
+			want:  []queryTerm{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseQuery(tt.input); !slices.Equal(got, tt.want) {
+				t.Errorf("\nparseQuery()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_Index(t *testing.T) {
+	index, s := newTestIndex(t)
+	pathDataFolder := index.config.PathDataFolder
+
+	files := map[string]string{
+		"image.bin": "\x00\x01\x02 revenue",
+		"notes.txt": "Quarter notes: revenue, revenue and more revenue",
+		"report.md": "# Quarterly report\n\nRevenue grew in the third quarter.",
+	}
+	for filename, content := range files {
+		err := os.WriteFile(filepath.Join(pathDataFolder, filename), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("os.WriteFile() failed: %v", err)
+		}
+	}
+
+	syncTestIndex(t, index, s)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "1", query: "revenue", want: []string{"notes.txt", "report.md"}},
+		{name: "2", query: "quarterly revenue", want: []string{"report.md"}},
+		{name: "3", query: "quarter*", want: []string{"report.md", "notes.txt"}},
+		{name: "4", query: "missing", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchTestIndex(t, index, tt.query); !slices.Equal(got, tt.want) {
+				t.Errorf("\nSearch()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+
+	results, err := index.Search("third", 10)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("\nSearch()\nwant: 1 result\ngot:  %v, %v", results, err)
+	}
+	if want := "# Quarterly report Revenue grew in the third quarter."; results[0].Snippet != want {
+		t.Errorf("\nSearch() snippet\nwant: %v\ngot:  %v", want, results[0].Snippet)
+	}
+
+	_, err = index.Search("a *", 10)
+	if !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("\nSearch() empty\nwant: %v\ngot:  %v", ErrEmptyQuery, err)
+	}
+
+	err = os.Remove(filepath.Join(pathDataFolder, "notes.txt"))
+	if err != nil {
+		t.Fatalf("os.Remove() failed: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(pathDataFolder, "report.md"), []byte("Nothing to see"), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile() failed: %v", err)
+	}
+
+	syncTestIndex(t, index, s)
+
+	if got := searchTestIndex(t, index, "revenue"); len(got) != 0 {
+		t.Errorf("\nSearch() after changes\nwant: []\ngot:  %v", got)
+	}
+
+	// A new index starts out with what the previous one saved.
+	loaded := New(index.config)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if got, want := searchTestIndex(t, loaded, "nothing"), []string{"report.md"}; !slices.Equal(got, want) {
+		t.Errorf("\nSearch() after Load()\nwant: %v\ngot:  %v", want, got)
+	}
+}
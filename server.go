@@ -0,0 +1,306 @@
+// Package ablage provides a minimal file exchange web application, which can
+// either be run with the ablage binary or be embedded into other Go programs.
+package ablage
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"git.0x0001f346.de/andreas/ablage/app"
+	"git.0x0001f346.de/andreas/ablage/config"
+	"git.0x0001f346.de/andreas/ablage/filesystem"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Options configures a Server. Every field left at its zero value falls back
+// to the default of the ablage binary.
+type Options = config.Config
+
+// serverErrorLogWriter passes the error log of the HTTP servers to the slog
+// logger. TLS handshake errors, which mostly come from browsers rejecting a
+// self-signed certificate, are only logged at debug level on request.
+type serverErrorLogWriter struct {
+	logTLSErrors bool
+	logger       *slog.Logger
+}
+
+// Server is a single ablage instance. Multiple servers can run side by side
+// in the same process as long as they use different data folders.
+type Server struct {
+	app            *app.App
+	config         *config.Config
+	http3Server    *http3.Server
+	httpServer     *http.Server
+	metricsServer  *http.Server
+	redirectServer *http.Server
+	stopEvents     func()
+	stopIndexer    func()
+	stopJanitor    func()
+	storage        *filesystem.Storage
+}
+
+func New(options Options) (*Server, error) {
+	c := options
+
+	err := c.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := filesystem.New(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		app:     app.New(&c, storage),
+		config:  &c,
+		storage: storage,
+	}
+
+	// The janitor deletes expired files, changes are sent to /events/ and
+	// files are indexed in search mode until Shutdown is called.
+	s.stopEvents = s.app.StartEvents()
+	s.stopIndexer = s.app.StartIndexer()
+	s.stopJanitor = s.app.StartJanitor()
+
+	errorLog := log.New(&serverErrorLogWriter{logTLSErrors: c.LogTLSErrors, logger: c.Logger}, "", 0)
+
+	s.httpServer = &http.Server{
+		ErrorLog: errorLog,
+		Handler:  s.app.Handler(),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: c.HTTP2MaxConcurrentStreams,
+		},
+	}
+
+	if c.MetricsMode {
+		s.metricsServer = &http.Server{
+			ErrorLog: errorLog,
+			Handler:  s.app.MetricsHandler(),
+		}
+	}
+
+	if c.HttpMode {
+		return s, nil
+	}
+
+	if c.GetACMEMode() || c.RedirectHTTPToHTTPS {
+		s.redirectServer = &http.Server{
+			ErrorLog: errorLog,
+			Handler:  httpsRedirectHandler(c.GetHTTPSPort()),
+		}
+	}
+
+	if c.GetACMEMode() {
+		s.httpServer.TLSConfig = c.GetACMEManager().TLSConfig()
+		s.redirectServer.Handler = c.GetACMEManager().HTTPHandler(s.redirectServer.Handler)
+	} else if c.CAMode {
+		s.httpServer.TLSConfig = &tls.Config{
+			GetCertificate: c.GetCertificate,
+		}
+	} else {
+		tlsCert, err := tls.X509KeyPair(c.GetTLSCertificate(), c.GetTLSKey())
+		if err != nil {
+			s.stopEvents()
+			s.stopIndexer()
+			s.stopJanitor()
+			return nil, fmt.Errorf("Faild to parse PEM encoded public/private key pair: %v", err)
+		}
+
+		s.httpServer.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{tlsCert},
+		}
+	}
+
+	if c.HTTP3Mode {
+		s.http3Server = &http3.Server{
+			Handler:   s.app.Handler(),
+			Logger:    c.Logger,
+			TLSConfig: http3.ConfigureTLSConfig(s.httpServer.TLSConfig),
+			QUICConfig: &quic.Config{
+				MaxIncomingStreams: int64(c.HTTP2MaxConcurrentStreams),
+			},
+		}
+
+		s.httpServer.Handler = s.advertiseHTTP3(s.httpServer.Handler)
+	}
+
+	return s, nil
+}
+
+// CheckLiveness reports an error if the data folder can't be reached or the
+// upload folder is missing. A check that hangs, e.g. on a stale network share,
+// doesn't return at all.
+func (s *Server) CheckLiveness() error {
+	return s.app.CheckLiveness()
+}
+
+// Handler returns the http.Handler of the server, including basic
+// authentication if it is enabled. It can be mounted into any other mux.
+func (s *Server) Handler() http.Handler {
+	return s.app.Handler()
+}
+
+// Logger returns the logger of the server, which is either Options.Logger or
+// created from Options.LogFormat and Options.LogLevel.
+func (s *Server) Logger() *slog.Logger {
+	return s.config.Logger
+}
+
+// PrintStartupBanner prints the effective configuration of the server to
+// stdout, followed by the URLs in listeningOn.
+func (s *Server) PrintStartupBanner(listeningOn []string) {
+	s.config.PrintStartupBanner(listeningOn)
+}
+
+// Serve accepts connections on listener until Shutdown is called. Unless
+// HttpMode is enabled, connections are served via TLS. Like http.Server, it
+// always returns a non-nil error, which is http.ErrServerClosed after
+// Shutdown.
+func (s *Server) Serve(listener net.Listener) error {
+	if s.config.HttpMode {
+		return s.ServeInsecure(listener)
+	}
+
+	return s.ServeTLS(listener)
+}
+
+// ServeACMEHTTP answers ACME HTTP-01 challenges on listener and redirects all
+// other requests to HTTPS. It returns an error if ACME is not enabled.
+// Certificates can also be obtained via TLS-ALPN-01 on the listener passed to
+// Serve, in which case ServeACMEHTTP is not needed at all.
+func (s *Server) ServeACMEHTTP(listener net.Listener) error {
+	if !s.config.GetACMEMode() {
+		return fmt.Errorf("ACME is not enabled")
+	}
+
+	return s.redirectServer.Serve(listener)
+}
+
+// ServeHTTP3 serves HTTP/3 via QUIC on conn until Shutdown is called. It
+// returns an error if HTTP3Mode is disabled. As long as ServeHTTP3 is running,
+// responses served via Serve advertise HTTP/3 in their Alt-Svc header.
+func (s *Server) ServeHTTP3(conn net.PacketConn) error {
+	if s.http3Server == nil {
+		return fmt.Errorf("HTTP/3 is not enabled")
+	}
+
+	return s.http3Server.Serve(conn)
+}
+
+// ServeInsecure serves unencrypted HTTP on listener until Shutdown is called,
+// regardless of HttpMode. If RedirectHTTPToHTTPS is enabled, all requests are
+// redirected to HTTPS instead (and ACME HTTP-01 challenges are answered).
+func (s *Server) ServeInsecure(listener net.Listener) error {
+	if s.config.RedirectHTTPToHTTPS {
+		return s.redirectServer.Serve(listener)
+	}
+
+	return s.httpServer.Serve(listener)
+}
+
+// ServeMetrics serves only the Prometheus metrics via plain HTTP on listener,
+// without basic authentication. It returns an error if MetricsMode is
+// disabled.
+func (s *Server) ServeMetrics(listener net.Listener) error {
+	if s.metricsServer == nil {
+		return fmt.Errorf("Metrics are not enabled")
+	}
+
+	return s.metricsServer.Serve(listener)
+}
+
+// ServeTLS serves HTTPS on listener until Shutdown is called. It returns an
+// error if HttpMode is enabled, as there is no certificate to serve.
+func (s *Server) ServeTLS(listener net.Listener) error {
+	if s.config.HttpMode {
+		return fmt.Errorf("TLS is not available in http mode")
+	}
+
+	return s.httpServer.ServeTLS(listener, "", "")
+}
+
+// Shutdown stops accepting new uploads, ends the event streams, saves the
+// search index, stops the janitor and waits for in-flight requests until ctx
+// is done. Connections that are still open by then are closed and the staging
+// files of aborted uploads are removed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.app.StartDraining()
+	s.stopEvents()
+	s.stopIndexer()
+	s.stopJanitor()
+
+	if s.redirectServer != nil {
+		_ = s.redirectServer.Shutdown(ctx)
+	}
+
+	if s.http3Server != nil {
+		_ = s.http3Server.Shutdown(ctx)
+	}
+
+	if s.metricsServer != nil {
+		_ = s.metricsServer.Shutdown(ctx)
+	}
+
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		_ = s.httpServer.Close()
+	}
+
+	cleanupErr := s.storage.CleanupUploadFolder()
+	if err != nil {
+		return err
+	}
+
+	return cleanupErr
+}
+
+func (w *serverErrorLogWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+
+	if strings.Contains(message, "TLS handshake error") {
+		if w.logTLSErrors {
+			w.logger.Debug("TLS handshake failed", "error", message)
+		}
+		return len(p), nil
+	}
+
+	w.logger.Warn("HTTP server error", "error", message)
+
+	return len(p), nil
+}
+
+func (s *Server) advertiseHTTP3(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = s.http3Server.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func httpsRedirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		target := url.URL{
+			Scheme:   "https",
+			Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	})
+}
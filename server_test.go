@@ -0,0 +1,134 @@
+package ablage
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func newTestServer(t *testing.T, options Options) (*Server, string) {
+	t.Helper()
+
+	options.PathDataFolder = filepath.Join(t.TempDir(), "data")
+
+	server, err := New(options)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+
+	go server.Serve(listener)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+
+	return server, listener.Addr().String()
+}
+
+func Test_Server_multipleInstances(t *testing.T) {
+	_, addressReadonly := newTestServer(t, Options{HttpMode: true, ReadonlyMode: true})
+	_, addressSinkhole := newTestServer(t, Options{HttpMode: true, SinkholeMode: true})
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{
+			name:    "1",
+			address: addressReadonly,
+			want:    `"Modes":{"Readonly":true,"Sinkhole":false}`,
+		},
+		{
+			name:    "2",
+			address: addressSinkhole,
+			want:    `"Modes":{"Readonly":false,"Sinkhole":true}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := http.Get("http://" + tt.address + "/config/")
+			if err != nil {
+				t.Fatalf("GET /config/ failed: %v", err)
+			}
+			defer res.Body.Close()
+
+			body, _ := io.ReadAll(res.Body)
+			if !strings.Contains(string(body), tt.want) {
+				t.Errorf("\n/config/\nname: %v\nwant: %v\ngot:  %s", tt.name, tt.want, body)
+			}
+		})
+	}
+}
+
+func Test_Server_HTTP2AndHTTP3(t *testing.T) {
+	server, address := newTestServer(t, Options{HTTP3Mode: true})
+
+	packetConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		t.Fatalf("net.ListenPacket() failed: %v", err)
+	}
+
+	go server.ServeHTTP3(packetConn)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	res, err := client.Get("https://" + address + "/config/")
+	if err != nil {
+		t.Fatalf("GET /config/ via TCP failed: %v", err)
+	}
+	res.Body.Close()
+
+	if res.ProtoMajor != 2 {
+		t.Errorf("\nTCP protocol\nwant: HTTP/2\ngot:  %v", res.Proto)
+	}
+
+	if res.Header.Get("Alt-Svc") == "" {
+		t.Errorf("\nAlt-Svc\nwant: h3 advertised\ngot:  none")
+	}
+
+	client = &http.Client{
+		Transport: &http3.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	res, err = client.Get("https://" + address + "/config/")
+	if err != nil {
+		t.Fatalf("GET /config/ via QUIC failed: %v", err)
+	}
+	res.Body.Close()
+
+	if res.ProtoMajor != 3 || res.StatusCode != http.StatusOK {
+		t.Errorf("\nQUIC protocol\nwant: HTTP/3 200\ngot:  %v %d", res.Proto, res.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("\nShutdown()\nwant: no error\ngot:  %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package systemd implements the parts of the systemd service protocol ablage
+// supports: socket activation, readiness notifications and the watchdog. Every
+// function is a no-op when ablage is not started by systemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The first file descriptor passed by systemd, see sd_listen_fds(3).
+const listenFDsStart int = 3
+
+// Listener is a socket passed by systemd. Stream sockets are available as
+// Listener, datagram sockets as PacketConn. Name is the FileDescriptorName=
+// of the socket unit, which defaults to the name of the unit.
+type Listener struct {
+	Listener   net.Listener
+	Name       string
+	PacketConn net.PacketConn
+}
+
+// GetListeners returns the sockets passed via LISTEN_FDS and unsets the
+// environment variables, so they are not inherited by child processes.
+func GetListeners() ([]Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if !isForThisProcess("LISTEN_PID", true) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]Listener, 0, count)
+	for i := range count {
+		fd := listenFDsStart + i
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		listener, err := newListener(fd, name)
+		if err != nil {
+			return nil, err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// GetWatchdogInterval returns the interval in which systemd expects a
+// WATCHDOG=1 notification, or 0 if the watchdog is disabled.
+func GetWatchdogInterval() time.Duration {
+	if !isForThisProcess("WATCHDOG_PID", false) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Notify sends state, e.g. "READY=1", to the service manager via
+// NOTIFY_SOCKET, see sd_notify(3). Without NOTIFY_SOCKET it does nothing.
+func Notify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	// Sockets in the abstract namespace are passed with a leading "@".
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("Could not connect to notify socket: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return fmt.Errorf("Could not send '%s' to notify socket: %v", state, err)
+	}
+
+	return nil
+}
+
+// StartWatchdog sends WATCHDOG=1 twice per watchdog interval until stop is
+// called, but only while check succeeds. If check fails or hangs, the service
+// manager restarts the process once the watchdog interval is over. Without an
+// enabled watchdog it does nothing.
+func StartWatchdog(check func() error) (stop func()) {
+	interval := GetWatchdogInterval()
+	if interval == 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval / 2)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if check() == nil {
+					_ = Notify("WATCHDOG=1")
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// isForThisProcess reports whether the environment variable pidVariable names
+// this process. If it is not set, it returns true unless it is required.
+func isForThisProcess(pidVariable string, required bool) bool {
+	value := os.Getenv(pidVariable)
+	if value == "" {
+		return !required
+	}
+
+	pid, err := strconv.Atoi(value)
+	return err == nil && pid == os.Getpid()
+}
+
+func newListener(fd int, name string) (Listener, error) {
+	file := os.NewFile(uintptr(fd), name)
+	if file == nil {
+		return Listener{}, fmt.Errorf("Socket '%s' (fd %d) passed by systemd is invalid.", name, fd)
+	}
+	defer file.Close()
+
+	// net.FileListener and net.FilePacketConn duplicate the file descriptor,
+	// so the original one can be closed in any case.
+	listener, err := net.FileListener(file)
+	if err == nil {
+		return Listener{Listener: listener, Name: name}, nil
+	}
+
+	packetConn, err := net.FilePacketConn(file)
+	if err == nil {
+		return Listener{Name: name, PacketConn: packetConn}, nil
+	}
+
+	return Listener{}, fmt.Errorf("Socket '%s' (fd %d) passed by systemd is neither a stream nor a datagram socket: %v", name, fd, err)
+}
@@ -0,0 +1,179 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_GetListeners(t *testing.T) {
+	tests := []struct {
+		name       string
+		listenPID  string
+		listenFDs  string
+		wantLength int
+	}{
+		{
+			name:       "1",
+			listenPID:  "",
+			listenFDs:  "",
+			wantLength: 0,
+		},
+		{
+			name:       "2",
+			listenPID:  strconv.Itoa(os.Getpid() + 1),
+			listenFDs:  "1",
+			wantLength: 0,
+		},
+		{
+			name:       "3",
+			listenPID:  strconv.Itoa(os.Getpid()),
+			listenFDs:  "0",
+			wantLength: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.listenPID)
+			t.Setenv("LISTEN_FDS", tt.listenFDs)
+
+			got, err := GetListeners()
+			if err != nil {
+				t.Fatalf("GetListeners() failed: %v", err)
+			}
+
+			if len(got) != tt.wantLength {
+				t.Errorf("\nGetListeners()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.wantLength, len(got))
+			}
+
+			if os.Getenv("LISTEN_FDS") != "" {
+				t.Errorf("\nGetListeners()\nname: %v\nwant: LISTEN_FDS unset\ngot:  %v", tt.name, os.Getenv("LISTEN_FDS"))
+			}
+		})
+	}
+}
+
+func Test_GetWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name         string
+		watchdogPID  string
+		watchdogUSEC string
+		want         time.Duration
+	}{
+		{
+			name:         "1",
+			watchdogPID:  "",
+			watchdogUSEC: "",
+			want:         0,
+		},
+		{
+			name:         "2",
+			watchdogPID:  "",
+			watchdogUSEC: "30000000",
+			want:         30 * time.Second,
+		},
+		{
+			name:         "3",
+			watchdogPID:  strconv.Itoa(os.Getpid()),
+			watchdogUSEC: "500000",
+			want:         500 * time.Millisecond,
+		},
+		{
+			name:         "4",
+			watchdogPID:  strconv.Itoa(os.Getpid() + 1),
+			watchdogUSEC: "30000000",
+			want:         0,
+		},
+		{
+			name:         "5",
+			watchdogPID:  "",
+			watchdogUSEC: "-1",
+			want:         0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_PID", tt.watchdogPID)
+			t.Setenv("WATCHDOG_USEC", tt.watchdogUSEC)
+
+			got := GetWatchdogInterval()
+			if got != tt.want {
+				t.Errorf("\nGetWatchdogInterval()\nname: %v\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_Notify(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets are not supported: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	err = Notify("READY=1")
+	if err != nil {
+		t.Fatalf("Notify() failed: %v", err)
+	}
+
+	buffer := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("Reading notification failed: %v", err)
+	}
+
+	if string(buffer[:n]) != "READY=1" {
+		t.Errorf("\nNotify()\nwant: READY=1\ngot:  %s", buffer[:n])
+	}
+}
+
+func Test_StartWatchdog(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets are not supported: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "100000")
+
+	var healthy atomic.Bool
+	stop := StartWatchdog(func() error {
+		if !healthy.Load() {
+			return os.ErrNotExist
+		}
+		return nil
+	})
+	defer stop()
+
+	buffer := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	n, err := conn.Read(buffer)
+	if err == nil {
+		t.Fatalf("\nStartWatchdog() with failing check\nwant: no notification\ngot:  %s", buffer[:n])
+	}
+
+	healthy.Store(true)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err = conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("Reading notification failed: %v", err)
+	}
+
+	if string(buffer[:n]) != "WATCHDOG=1" {
+		t.Errorf("\nStartWatchdog()\nwant: WATCHDOG=1\ngot:  %s", buffer[:n])
+	}
+}